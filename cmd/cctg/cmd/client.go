@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/ipc"
+)
+
+// newClient returns a client for the daemon named by --daemon or
+// CCTG_DAEMON, or for the local socket. Credentials for a remote daemon
+// come from the environment so they stay out of process listings.
+func newClient() (*ipc.Client, error) {
+	addr := daemonArg
+	if addr == "" {
+		addr = os.Getenv("CCTG_DAEMON")
+	}
+	if addr == "" {
+		return ipc.NewClient(config.GetSocketPath()), nil
+	}
+	return ipc.NewRemoteClient(addr, ipc.RemoteOptions{
+		Token:    os.Getenv("CCTG_DAEMON_TOKEN"),
+		CertFile: os.Getenv("CCTG_DAEMON_CERT"),
+		KeyFile:  os.Getenv("CCTG_DAEMON_KEY"),
+		CAFile:   os.Getenv("CCTG_DAEMON_CA"),
+	})
+}
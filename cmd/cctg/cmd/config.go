@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect configuration",
+	Long: `Inspect cctg configuration.
+
+Settings come from two places:
+  - ~/.config/cctg/config.yaml: bot token, allowed users and sessions
+  - .cctg.yaml: per-repository session settings, found by walking up
+    from the working directory to the git root`,
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+)
+
+var showResolved bool
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the configuration",
+	Long: `Print the loaded configuration with tokens and secrets redacted.
+
+With --resolved, also discover the .cctg.yaml for the current directory and
+print the session that "cctg send" would use after merging it.
+
+Examples:
+  cctg config show
+  cctg config show --resolved
+  cctg config show --resolved --session api`,
+	RunE: runConfigShow,
+}
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+	configShowCmd.Flags().BoolVar(&showResolved, "resolved", false, "merge .cctg.yaml and show the session used from this directory")
+}
+
+type resolvedConfig struct {
+	ConfigFile string                `yaml:"config_file"`
+	RepoConfig string                `yaml:"repo_config,omitempty"`
+	Telegram   config.TelegramConfig `yaml:"telegram"`
+	Session    *config.SessionConfig `yaml:"session"`
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	shown := redactSecrets(*cfg)
+
+	if !showResolved {
+		return printYAML(shown)
+	}
+
+	workDir, _ := os.Getwd()
+	repo, err := config.FindRepoConfig(workDir)
+	if err != nil {
+		return err
+	}
+
+	sess, err := cfg.ResolveSession(sessionArg, workDir)
+	if err != nil && err != config.ErrSessionNotFound {
+		return err
+	}
+
+	out := resolvedConfig{
+		ConfigFile: cfg.Path(),
+		Telegram:   shown.Telegram,
+		Session:    sess,
+	}
+	if repo != nil {
+		out.RepoConfig = repo.Path
+	}
+	return printYAML(out)
+}
+
+func printYAML(v any) error {
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("encoding yaml: %w", err)
+	}
+	return enc.Close()
+}
+
+// redactSecrets hides every credential in cfg, so the output can be pasted
+// into a bug report.
+func redactSecrets(cfg config.Config) config.Config {
+	cfg.Telegram.BotToken = redact(cfg.Telegram.BotToken)
+	cfg.Slack.BotToken = redact(cfg.Slack.BotToken)
+	cfg.Slack.SigningSecret = redact(cfg.Slack.SigningSecret)
+	cfg.Webhook.Secret = redact(cfg.Webhook.Secret)
+	cfg.Dashboard.Token = redact(cfg.Dashboard.Token)
+	cfg.Matrix.AccessToken = redact(cfg.Matrix.AccessToken)
+	if len(cfg.Remote.Tokens) > 0 {
+		tokens := make([]string, len(cfg.Remote.Tokens))
+		for i, token := range cfg.Remote.Tokens {
+			tokens[i] = redact(token)
+		}
+		cfg.Remote.Tokens = tokens
+	}
+	return cfg
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "<redacted>"
+}
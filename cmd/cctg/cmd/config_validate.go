@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+)
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration for mistakes",
+	Long: `Check the configuration for mistakes and print each problem with its file
+and line.
+
+Errors stop "cctg serve" from starting. Warnings point at things that load
+but probably don't do what you meant, like a misspelled key.
+
+The .cctg.yaml for the current directory is checked too, if there is one.
+
+Example:
+  cctg config validate`,
+	RunE: runConfigValidate,
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) error {
+	diags := config.ValidateFile(config.ResolvePath(cfgFile))
+
+	workDir, _ := os.Getwd()
+	if repo, err := config.FindRepoConfig(workDir); err != nil {
+		diags = append(diags, config.Diagnostic{Severity: config.SeverityError, File: workDir, Message: err.Error()})
+	} else if repo != nil {
+		diags = append(diags, config.ValidateRepoFile(repo.Path)...)
+	}
+
+	for _, d := range diags {
+		fmt.Println(d)
+	}
+
+	errs, warns := diags.Count(config.SeverityError), diags.Count(config.SeverityWarning)
+	if errs > 0 {
+		return fmt.Errorf("%d error(s), %d warning(s)", errs, warns)
+	}
+	fmt.Printf("config ok (%d warning(s))\n", warns)
+	return nil
+}
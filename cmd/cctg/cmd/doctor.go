@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/spf13/cobra"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/ipc"
+	"github.com/bupd/go-claude-code-telegram/internal/telegram"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+	"github.com/bupd/go-claude-code-telegram/internal/version"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose setup problems",
+	Long: `Check the whole setup step by step and suggest a fix for each problem:
+
+  - config parses and validates
+  - the bot token is accepted by Telegram (getMe)
+  - the socket path, owner and permissions
+  - the daemon is reachable, runs this version and is connected
+  - each session's chat is reachable and the bot can post there
+  - group privacy mode won't hide messages from the bot
+
+Set telegram.api_endpoint to run the checks against a local Bot API server
+or stub.`,
+	RunE: runDoctor,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+type doctor struct {
+	failures int
+	warnings int
+}
+
+func (d *doctor) ok(format string, args ...any) {
+	fmt.Printf("[ok]   %s\n", fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) warn(hint, format string, args ...any) {
+	d.warnings++
+	d.report("[warn]", hint, format, args...)
+}
+
+func (d *doctor) fail(hint, format string, args ...any) {
+	d.failures++
+	d.report("[fail]", hint, format, args...)
+}
+
+func (d *doctor) report(tag, hint, format string, args ...any) {
+	fmt.Printf("%-6s %s\n", tag, fmt.Sprintf(format, args...))
+	if hint != "" {
+		fmt.Printf("       fix: %s\n", hint)
+	}
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	d := &doctor{}
+
+	cfg := d.checkConfig()
+
+	var api *tgbotapi.BotAPI
+	if cfg != nil && usesTelegram(cfg) {
+		api = d.checkToken(cfg)
+	}
+
+	d.checkSocket(config.GetSocketPath())
+	d.checkDaemon(config.GetSocketPath())
+
+	if cfg != nil && api != nil {
+		for _, sess := range cfg.Sessions {
+			if sess.TransportName() == transport.Telegram {
+				d.checkSession(api, sess)
+			}
+		}
+	}
+
+	fmt.Println()
+	if d.failures > 0 {
+		return fmt.Errorf("%d check(s) failed, %d warning(s)", d.failures, d.warnings)
+	}
+	fmt.Printf("all checks passed (%d warning(s))\n", d.warnings)
+	return nil
+}
+
+func (d *doctor) checkConfig() *config.Config {
+	path := config.ResolvePath(cfgFile)
+
+	diags := config.ValidateFile(path)
+	for _, diag := range diags {
+		if diag.Severity == config.SeverityError {
+			d.fail("edit the config, then run 'cctg config validate'", "config: %s", diag)
+		} else {
+			d.warn("", "config: %s", diag)
+		}
+	}
+
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		d.fail("run 'cctg init' to create a config, or pass --config", "config: %v", err)
+		return nil
+	}
+	if !diags.HasErrors() {
+		d.ok("config: %s (%d session(s))", cfg.Path(), len(cfg.Sessions))
+	}
+	return cfg
+}
+
+// usesTelegram reports whether any session, or the first one still to be
+// created, goes through Telegram.
+func usesTelegram(cfg *config.Config) bool {
+	if len(cfg.Sessions) == 0 {
+		return true
+	}
+	for _, sess := range cfg.Sessions {
+		if sess.TransportName() == transport.Telegram {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *doctor) checkToken(cfg *config.Config) *tgbotapi.BotAPI {
+	token, err := cfg.Telegram.Token()
+	if err != nil {
+		d.fail("set telegram.bot_token, TELEGRAM_BOT_TOKEN, token_file or token_command", "token: %v", err)
+		return nil
+	}
+
+	endpoint := cfg.Telegram.APIEndpoint
+	if endpoint == "" {
+		endpoint = telegram.DefaultAPIEndpoint
+	}
+
+	api, err := telegram.NewAPI(cfg.Telegram, token)
+	if err != nil {
+		err = telegram.ScrubError(err, token)
+		if telegram.IsUnauthorized(err) {
+			d.fail("get the current token from @BotFather (/token) and update "+cfg.Telegram.TokenSource(), "token: rejected by Telegram: %v", err)
+		} else {
+			d.fail("check your network or proxy, or telegram.api_endpoint", "token: cannot reach %s: %v", endpoint, err)
+		}
+		return nil
+	}
+
+	d.ok("token: accepted, bot is @%s (from %s)", api.Self.UserName, cfg.Telegram.TokenSource())
+	return api
+}
+
+func (d *doctor) checkSocket(socketPath string) {
+	dir := filepath.Dir(socketPath)
+	info, err := os.Stat(dir)
+	if err != nil {
+		d.warn("it is created by 'cctg serve'", "socket: directory %s: %v", dir, err)
+		return
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		d.warn("chmod 700 "+dir, "socket: directory %s is writable by other users (mode %04o)", dir, info.Mode().Perm())
+	}
+
+	info, err = os.Stat(socketPath)
+	if os.IsNotExist(err) {
+		d.warn("start the daemon with 'cctg serve' or 'systemctl --user start cctg'", "socket: %s does not exist", socketPath)
+		return
+	}
+	if err != nil {
+		d.fail("", "socket: %v", err)
+		return
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		d.fail("remove "+socketPath+" and restart the daemon", "socket: %s is not a socket", socketPath)
+		return
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		d.fail("run the daemon as the same user as cctg send", "socket: %s is owned by uid %d, not you (uid %d)", socketPath, st.Uid, os.Getuid())
+		return
+	}
+	if info.Mode().Perm() != 0600 {
+		d.warn("restart the daemon; it sets mode 0600", "socket: %s has mode %04o", socketPath, info.Mode().Perm())
+		return
+	}
+	d.ok("socket: %s", socketPath)
+}
+
+func (d *doctor) checkDaemon(socketPath string) {
+	client := ipc.NewClient(socketPath)
+	if !client.IsRunning() {
+		d.fail("start the daemon with 'cctg serve' or 'systemctl --user start cctg'; until then cctg send prints the fallback message",
+			"daemon: not reachable on %s", socketPath)
+		return
+	}
+
+	resp, err := client.Send(&ipc.Request{Type: ipc.RequestTypeStatus})
+	if err != nil {
+		d.fail("restart the daemon with 'cctg serve --replace'", "daemon: status request failed: %v", err)
+		return
+	}
+	if !resp.Success || resp.Status == nil {
+		d.warn("restart it with 'cctg serve --replace'", "daemon: running but too old to report status")
+		return
+	}
+	st := resp.Status
+
+	if st.Version != version.String() {
+		d.warn("restart it with 'cctg serve --replace' to run this binary", "daemon: running %s, this binary is %s", st.Version, version.String())
+	} else {
+		d.ok("daemon: running %s (pid %d)", st.Version, st.PID)
+	}
+
+	tg := st.Telegram
+	switch tg.State {
+	case telegram.StateConnected:
+		d.ok("daemon: connected to telegram")
+	case telegram.StateConflict:
+		d.fail("another process is polling with this bot token; stop the other cctg (another machine, container or systemd unit) or revoke the token",
+			"daemon: telegram getUpdates conflict since %s", tg.ConflictSince.Format("15:04:05"))
+	default:
+		d.warn("check the daemon log; messages wait in the outbox until it connects", "daemon: telegram %s: %s", tg.State, tg.LastError)
+	}
+}
+
+func (d *doctor) checkSession(api *tgbotapi.BotAPI, sess config.SessionConfig) {
+	label := fmt.Sprintf("session %s (chat %d)", sess.Name, sess.ChatID)
+
+	chat, err := api.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: sess.ChatID}})
+	if err != nil {
+		d.fail(fmt.Sprintf("for a private chat send /start to @%s; for a group add the bot to it; or fix the ID with 'cctg session edit %s'", api.Self.UserName, sess.Name),
+			"%s: chat not reachable: %v", label, err)
+		return
+	}
+
+	if chat.IsPrivate() {
+		// getChat still works after the user blocks the bot; only sending
+		// fails. A typing action is the quietest way to try.
+		if _, err := api.Request(tgbotapi.NewChatAction(sess.ChatID, tgbotapi.ChatTyping)); err != nil {
+			d.fail(fmt.Sprintf("unblock @%s in the chat with %s and send it /start", api.Self.UserName, chatName(chat)),
+				"%s: bot cannot post in the private chat with %s: %v", label, chatName(chat), err)
+			return
+		}
+		d.ok("%s: bot can post in the private chat with %s", label, chatName(chat))
+		return
+	}
+
+	member, err := api.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: sess.ChatID, UserID: api.Self.ID},
+	})
+	if err != nil {
+		d.fail("add the bot to "+chatName(chat), "%s: cannot check bot membership: %v", label, err)
+		return
+	}
+
+	admin := member.IsAdministrator() || member.IsCreator()
+	switch {
+	case member.HasLeft() || member.WasKicked():
+		d.fail("add the bot to "+chatName(chat)+" again", "%s: bot is not a member (%s)", label, member.Status)
+		return
+	case member.Status == "restricted" && !member.CanSendMessages:
+		d.fail("allow the bot to send messages in "+chatName(chat), "%s: bot is not allowed to send messages", label)
+		return
+	case chat.IsChannel() && !(admin && member.CanPostMessages):
+		d.fail("make the bot a channel admin with permission to post", "%s: bot cannot post in channel", label)
+		return
+	}
+
+	if (chat.IsGroup() || chat.IsSuperGroup()) && !api.Self.CanReadAllGroupMessages && !admin {
+		d.warn("in @BotFather use /setprivacy -> Disable, then remove and re-add the bot; or make it a group admin",
+			"%s: privacy mode is on, the bot only sees replies to its own messages in %s; other messages are never queued", label, chatName(chat))
+		return
+	}
+
+	d.ok("%s: bot can post in %s", label, chatName(chat))
+}
+
+func chatName(chat tgbotapi.Chat) string {
+	switch {
+	case chat.Title != "":
+		return chat.Title
+	case chat.UserName != "":
+		return "@" + chat.UserName
+	default:
+		return chat.FirstName
+	}
+}
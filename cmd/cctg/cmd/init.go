@@ -2,18 +2,18 @@ package cmd
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/spf13/cobra"
 
 	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/telegram"
 )
 
 var (
@@ -24,13 +24,23 @@ var (
 	initChatID      int64
 	initWorkingDir  string
 	initTimeout     int
+	initTokenStore  string
+	initAPIEndpoint string
+	initProxy       string
 )
 
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize configuration",
-	Long:  "Create config directory and files interactively or via flags.",
-	RunE:  runInit,
+	Long: `Create config directory and files interactively or via flags.
+
+The bot token is stored according to --token-store:
+  env            ~/.config/cctg/.env (default)
+  file           ~/.config/cctg/token, referenced by telegram.token_file
+  pass           the pass password store, read back with telegram.token_command
+  secret-tool    the desktop keyring via libsecret, read back with telegram.token_command
+  systemd-creds  an encrypted systemd credential for LoadCredentialEncrypted=`,
+	RunE: runInit,
 }
 
 func init() {
@@ -42,6 +52,9 @@ func init() {
 	initCmd.Flags().Int64Var(&initChatID, "chat-id", 0, "Telegram chat ID")
 	initCmd.Flags().StringVar(&initWorkingDir, "working-dir", "", "Working directory for session")
 	initCmd.Flags().IntVar(&initTimeout, "timeout", 0, "Timeout in seconds (default 300)")
+	initCmd.Flags().StringVar(&initTokenStore, "token-store", "env", "where to store the bot token: env, file, pass, secret-tool or systemd-creds")
+	initCmd.Flags().StringVar(&initAPIEndpoint, "api-endpoint", "", "Bot API base URL, for a self-hosted Bot API server (default https://api.telegram.org)")
+	initCmd.Flags().StringVar(&initProxy, "proxy", "", "http://, https:// or socks5:// proxy for Bot API requests")
 }
 
 func runInit(cmd *cobra.Command, args []string) error {
@@ -55,10 +68,12 @@ func runInit(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("bot token is required")
 	}
 
+	tgConfig := config.TelegramConfig{APIEndpoint: initAPIEndpoint, Proxy: initProxy}
+
 	userID := initUserID
 	if userID == 0 && initUsername != "" {
 		var err error
-		userID, err = resolveUsername(token, initUsername)
+		userID, err = resolveUsername(tgConfig, token, initUsername)
 		if err != nil {
 			return err
 		}
@@ -70,7 +85,7 @@ func runInit(cmd *cobra.Command, args []string) error {
 			fmt.Println("\nTo resolve username, send /start to your bot first.")
 			prompt(reader, "Press Enter after sending /start to the bot")
 			var err error
-			userID, err = resolveUsername(token, input)
+			userID, err = resolveUsername(tgConfig, token, input)
 			if err != nil {
 				return err
 			}
@@ -107,7 +122,7 @@ func runInit(cmd *cobra.Command, args []string) error {
 			chatID = userID
 		} else if strings.ToLower(input) == "group" {
 			var err error
-			chatID, err = detectGroupChat(token)
+			chatID, err = detectGroupChat(tgConfig, token)
 			if err != nil {
 				return err
 			}
@@ -149,27 +164,23 @@ func runInit(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("creating config directory: %w", err)
 	}
 
-	envPath := filepath.Join(configDir, ".env")
-	envContent := fmt.Sprintf("TELEGRAM_BOT_TOKEN=%s\n", token)
-	if err := os.WriteFile(envPath, []byte(envContent), 0600); err != nil {
-		return fmt.Errorf("writing .env: %w", err)
+	tgConfig.AllowedUsers = []int64{userID}
+	if err := storeToken(configDir, initTokenStore, token, &tgConfig); err != nil {
+		return err
 	}
-	fmt.Printf("Created %s\n", envPath)
 
 	configPath := filepath.Join(configDir, "config.yaml")
-	configContent := fmt.Sprintf(`telegram:
-  allowed_users:
-    - %d
-
-timeout: %d
-
-sessions:
-  - name: "%s"
-    chat_id: %d
-    working_dir: "%s"
-`, userID, timeout, sessionName, chatID, workingDir)
+	cfg := &config.Config{
+		Telegram: tgConfig,
+		Timeout:  timeout,
+		Sessions: []config.SessionConfig{{
+			Name:       sessionName,
+			ChatID:     chatID,
+			WorkingDir: workingDir,
+		}},
+	}
 
-	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+	if err := cfg.Save(configPath); err != nil {
 		return fmt.Errorf("writing config.yaml: %w", err)
 	}
 	fmt.Printf("Created %s\n", configPath)
@@ -178,87 +189,117 @@ sessions:
 	return nil
 }
 
-func resolveUsername(token, username string) (int64, error) {
-	username = strings.TrimPrefix(username, "@")
+const (
+	passEntry        = "cctg/telegram-bot-token"
+	secretToolLookup = "service cctg key bot_token"
+)
 
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates", token)
-	client := &http.Client{Timeout: 10 * time.Second}
+// storeToken saves the bot token in the chosen store and points tg at it.
+func storeToken(configDir, store, token string, tg *config.TelegramConfig) error {
+	switch store {
+	case "env":
+		envPath := filepath.Join(configDir, config.DefaultEnvFile)
+		envContent := fmt.Sprintf("TELEGRAM_BOT_TOKEN=%s\n", token)
+		if err := os.WriteFile(envPath, []byte(envContent), 0600); err != nil {
+			return fmt.Errorf("writing .env: %w", err)
+		}
+		fmt.Printf("Created %s\n", envPath)
 
-	resp, err := client.Get(url)
-	if err != nil {
-		return 0, fmt.Errorf("calling telegram API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		OK     bool `json:"ok"`
-		Result []struct {
-			Message struct {
-				From struct {
-					ID       int64  `json:"id"`
-					Username string `json:"username"`
-				} `json:"from"`
-			} `json:"message"`
-		} `json:"result"`
-	}
+	case "file":
+		tokenPath := filepath.Join(configDir, config.DefaultTokenFile)
+		if err := os.WriteFile(tokenPath, []byte(token+"\n"), 0600); err != nil {
+			return fmt.Errorf("writing token file: %w", err)
+		}
+		tg.TokenFile = tokenPath
+		fmt.Printf("Created %s\n", tokenPath)
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return 0, fmt.Errorf("parsing response: %w", err)
-	}
+	case "pass":
+		if err := runWithStdin(token+"\n", "pass", "insert", "--multiline", "--force", passEntry); err != nil {
+			return fmt.Errorf("storing token in pass: %w", err)
+		}
+		tg.TokenCommand = "pass show " + passEntry
+		fmt.Printf("Stored token in pass as %s\n", passEntry)
 
-	if !result.OK {
-		return 0, fmt.Errorf("telegram API error")
-	}
+	case "secret-tool":
+		args := append([]string{"store", "--label=cctg Telegram bot token"}, strings.Fields(secretToolLookup)...)
+		if err := runWithStdin(token, "secret-tool", args...); err != nil {
+			return fmt.Errorf("storing token with secret-tool: %w", err)
+		}
+		tg.TokenCommand = "secret-tool lookup " + secretToolLookup
+		fmt.Println("Stored token in the keyring via secret-tool")
 
-	for _, update := range result.Result {
-		if strings.EqualFold(update.Message.From.Username, username) {
-			return update.Message.From.ID, nil
+	case "systemd-creds":
+		credPath := filepath.Join(configDir, config.CredentialName+".cred")
+		if err := runWithStdin(token, "systemd-creds", "encrypt", "--user", "--name="+config.CredentialName, "-", credPath); err != nil {
+			return fmt.Errorf("encrypting token with systemd-creds: %w", err)
 		}
+		fmt.Printf("Created %s\n", credPath)
+		fmt.Printf("Add this to the [Service] section of cctg.service:\n  LoadCredentialEncrypted=%s:%s\n", config.CredentialName, credPath)
+
+	default:
+		return fmt.Errorf("unknown token store %q (use env, file, pass, secret-tool or systemd-creds)", store)
 	}
+	return nil
+}
 
-	return 0, fmt.Errorf("username @%s not found in recent messages - send /start to the bot first", username)
+func runWithStdin(input, name string, args ...string) error {
+	c := exec.Command(name, args...)
+	c.Stdin = strings.NewReader(input)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return c.Run()
 }
 
-func detectGroupChat(token string) (int64, error) {
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates", token)
-	client := &http.Client{Timeout: 10 * time.Second}
+func resolveUsername(tg config.TelegramConfig, token, username string) (int64, error) {
+	username = strings.TrimPrefix(username, "@")
 
-	resp, err := client.Get(url)
+	updates, err := recentUpdates(tg, token)
 	if err != nil {
-		return 0, fmt.Errorf("calling telegram API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		OK     bool `json:"ok"`
-		Result []struct {
-			Message struct {
-				Chat struct {
-					ID   int64  `json:"id"`
-					Type string `json:"type"`
-				} `json:"chat"`
-			} `json:"message"`
-		} `json:"result"`
+		return 0, err
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return 0, fmt.Errorf("parsing response: %w", err)
+	for _, update := range updates {
+		if update.Message != nil && update.Message.From != nil && strings.EqualFold(update.Message.From.UserName, username) {
+			return update.Message.From.ID, nil
+		}
 	}
 
-	if !result.OK {
-		return 0, fmt.Errorf("telegram API error")
+	return 0, fmt.Errorf("username @%s not found in recent messages - send /start to the bot first", username)
+}
+
+func detectGroupChat(tg config.TelegramConfig, token string) (int64, error) {
+	updates, err := recentUpdates(tg, token)
+	if err != nil {
+		return 0, err
 	}
 
-	for _, update := range result.Result {
-		chatType := update.Message.Chat.Type
-		if chatType == "group" || chatType == "supergroup" {
-			return update.Message.Chat.ID, nil
+	for _, update := range updates {
+		if update.Message == nil {
+			continue
+		}
+		chat := update.Message.Chat
+		if chat.IsGroup() || chat.IsSuperGroup() {
+			return chat.ID, nil
 		}
 	}
 
 	return 0, fmt.Errorf("no group chat found - add bot to a group and send a message first")
 }
 
+// recentUpdates fetches the updates Telegram is holding for the bot, through
+// the same endpoint, proxy and timeouts the daemon uses.
+func recentUpdates(tg config.TelegramConfig, token string) ([]tgbotapi.Update, error) {
+	api, err := telegram.NewAPI(tg, token)
+	if err != nil {
+		return nil, fmt.Errorf("calling telegram API: %w", telegram.ScrubError(err, token))
+	}
+	updates, err := api.GetUpdates(tgbotapi.UpdateConfig{})
+	if err != nil {
+		return nil, fmt.Errorf("calling telegram API: %w", telegram.ScrubError(err, token))
+	}
+	return updates, nil
+}
+
 func prompt(reader *bufio.Reader, question string) string {
 	fmt.Printf("%s: ", question)
 	input, _ := reader.ReadString('\n')
@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/ipc"
+	"github.com/bupd/go-claude-code-telegram/internal/session"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+// outcomeTTL is how long a settled question is remembered for wait.
+const outcomeTTL = 10 * time.Minute
+
+// question follows an asked question until it is answered, expires or is
+// cancelled. resp and state are set before done is closed.
+type question struct {
+	pm     *session.PendingMessage
+	sess   *config.SessionConfig
+	queued []string
+	done   chan struct{}
+	state  string
+	resp   *ipc.Response
+}
+
+// ask sends the question in req and starts following it. It returns a
+// response instead when the question can't be asked or wasn't delivered in
+// time.
+func (d *daemon) ask(req *ipc.Request) (*question, *ipc.Response) {
+	if d.ctx.Err() != nil {
+		return nil, ipc.Errorf(ipc.CodeShuttingDown, ipc.ErrShuttingDown)
+	}
+
+	cfg := d.sessions.Config()
+
+	sess, err := cfg.ResolveSession(req.Session, req.WorkDir)
+	if err != nil {
+		return nil, ipc.Errorf(ipc.CodeNoSession, "%v", err)
+	}
+
+	addr := sess.Address()
+	tr, ok := d.transports[addr.Transport]
+	if !ok {
+		return nil, ipc.Errorf(ipc.CodeTransportUnavailable, "session %q uses transport %q, which is not running", sess.Name, addr.Transport)
+	}
+
+	msg := transport.Message{Session: sess.Name, Text: req.Message, Format: sess.Format}
+	for _, path := range req.Attachments {
+		msg.Attachments = append(msg.Attachments, transport.Attachment{Path: path})
+	}
+	if len(msg.Attachments) > 0 && !tr.Capabilities().Attachments {
+		return nil, ipc.Errorf(ipc.CodeUnsupported, "transport %q does not support attachments", addr.Transport)
+	}
+	if len(req.Choices) > 0 {
+		if tr.Capabilities().Choices {
+			msg.Choices = req.Choices
+		} else {
+			msg.Text = withChoices(msg.Text, req.Choices)
+		}
+	}
+
+	timeout := sess.Timeout
+	if req.Timeout > 0 {
+		timeout = req.Timeout
+	}
+
+	// The timeout covers both waiting in the outbox while Telegram is
+	// unreachable and waiting for the reply.
+	ctx, cancel := context.WithTimeout(d.ctx, time.Duration(timeout)*time.Second)
+
+	msgID, err := tr.Send(ctx, addr.Chat, msg)
+	if err != nil {
+		cancel()
+		if d.ctx.Err() != nil {
+			return nil, ipc.Errorf(ipc.CodeShuttingDown, ipc.ErrShuttingDown)
+		}
+		if ctx.Err() != nil {
+			log.Printf("session %s: message not delivered before timeout: %v", sess.Name, err)
+			resp := noReplyResponse(sess, d.sessions.PopQueuedMessages(addr))
+			resp.State = ipc.StateExpired
+			return nil, resp
+		}
+		return nil, ipc.Errorf(ipc.CodeDeliveryFailed, "%v", err)
+	}
+
+	q := &question{sess: sess, queued: d.sessions.PopQueuedMessages(addr), done: make(chan struct{})}
+	deadline, _ := ctx.Deadline()
+	q.pm = d.sessions.AddPending(addr, msgID, session.Question{Session: sess.Name, Content: req.Message, Choices: req.Choices}, deadline)
+
+	d.questionsMu.Lock()
+	d.questions[q.pm.ID] = q
+	d.questionsMu.Unlock()
+
+	go d.follow(ctx, cancel, q)
+	return q, nil
+}
+
+// follow settles q when it is answered, cancelled or times out, and
+// forgets it outcomeTTL later.
+func (d *daemon) follow(ctx context.Context, cancel context.CancelFunc, q *question) {
+	defer cancel()
+	addr := q.pm.Addr
+
+	select {
+	case reply, ok := <-q.pm.ResponseCh:
+		q.settle(reply, ok)
+		d.closeChoices(q, reply)
+	case <-ctx.Done():
+		switch {
+		case d.ctx.Err() != nil:
+			// Leave the question persisted so the next daemon can route
+			// a late reply to it.
+			q.state = ipc.StatePending
+			q.resp = ipc.Errorf(ipc.CodeShuttingDown, ipc.ErrShuttingDown)
+		case d.sessions.RemovePending(addr, q.pm):
+			d.notify(addr, "timeout: no reply received")
+			q.resp = noReplyResponse(q.sess, q.queued)
+			q.state = ipc.StateExpired
+			if len(q.queued) > 0 {
+				q.state = ipc.StateAnswered
+			}
+			d.closeChoices(q, "")
+		default:
+			// Answered or cancelled just as it timed out.
+			reply, ok := <-q.pm.ResponseCh
+			q.settle(reply, ok)
+			d.closeChoices(q, reply)
+		}
+	}
+	q.resp.ID = q.pm.ID
+	q.resp.State = q.state
+	close(q.done)
+
+	time.AfterFunc(outcomeTTL, func() {
+		d.questionsMu.Lock()
+		delete(d.questions, q.pm.ID)
+		d.questionsMu.Unlock()
+	})
+}
+
+// closeChoices removes the buttons from a settled question, so a late press
+// can't be taken for an answer. Cancelled questions and answers from the
+// dashboard are edited where they are settled.
+func (d *daemon) closeChoices(q *question, reply string) {
+	pm := q.pm
+	if len(pm.Choices) == 0 || q.state == ipc.StateCancelled || pm.Source == session.SourceDashboard {
+		return
+	}
+	tr, ok := d.transports[pm.Addr.Transport]
+	if !ok || !tr.Capabilities().Choices || !tr.Capabilities().Edit {
+		return
+	}
+	text := pm.Content + "\n\n(expired)"
+	if pm.Source != "" {
+		text = pm.Content + "\n\nAnswered: " + reply
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(d.ctx, 30*time.Second)
+		defer cancel()
+		if err := tr.Edit(ctx, pm.Addr.Chat, pm.MsgID, text); err != nil {
+			log.Printf("failed to remove the choices from question %s in %s: %v", pm.ID, pm.Addr, err)
+		}
+	}()
+}
+
+func (q *question) settle(reply string, answered bool) {
+	if !answered {
+		q.state = ipc.StateCancelled
+		q.resp = ipc.Errorf(ipc.CodeCancelled, "question %s was cancelled", q.pm.ID)
+		return
+	}
+	q.state = ipc.StateAnswered
+	q.resp = &ipc.Response{Success: true, Reply: combineMessages(q.queued, reply)}
+}
+
+// outcome returns a copy of q's final response, once done.
+func (q *question) outcome() *ipc.Response {
+	resp := *q.resp
+	return &resp
+}
+
+func (d *daemon) question(id string) *question {
+	d.questionsMu.Lock()
+	defer d.questionsMu.Unlock()
+	return d.questions[id]
+}
+
+func (d *daemon) handleSend(req *ipc.Request) *ipc.Response {
+	q, resp := d.ask(req)
+	if resp != nil {
+		resp.State = ""
+		return resp
+	}
+	<-q.done
+	resp = q.outcome()
+	resp.ID, resp.State = "", ""
+	return resp
+}
+
+func (d *daemon) handleAsk(req *ipc.Request) *ipc.Response {
+	q, resp := d.ask(req)
+	if resp != nil {
+		return resp
+	}
+	return &ipc.Response{Success: true, ID: q.pm.ID, Deadline: &q.pm.Deadline, State: ipc.StatePending}
+}
+
+// handleWait returns the outcome of a question, or that it is still
+// pending if req.Timeout seconds pass first.
+func (d *daemon) handleWait(req *ipc.Request) *ipc.Response {
+	q := d.question(req.ID)
+	if q == nil {
+		return ipc.Errorf(ipc.CodeNotFound, "no question with id %q", req.ID)
+	}
+
+	var timeout <-chan time.Time
+	if req.Timeout > 0 {
+		timer := time.NewTimer(time.Duration(req.Timeout) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-q.done:
+		return q.outcome()
+	case <-timeout:
+		return &ipc.Response{Success: true, ID: q.pm.ID, Deadline: &q.pm.Deadline, State: ipc.StatePending}
+	case <-d.ctx.Done():
+		return ipc.Errorf(ipc.CodeShuttingDown, ipc.ErrShuttingDown)
+	}
+}
+
+// handleCancel withdraws a pending question and marks it cancelled in its
+// chat.
+func (d *daemon) handleCancel(req *ipc.Request) *ipc.Response {
+	pm, ok := d.sessions.Cancel(req.ID)
+	if !ok {
+		if q := d.question(req.ID); q != nil {
+			<-q.done
+			resp := ipc.Errorf(ipc.CodeNotFound, "question %s is no longer pending", req.ID)
+			resp.ID, resp.State = req.ID, q.state
+			return resp
+		}
+		return ipc.Errorf(ipc.CodeNotFound, "no question with id %q", req.ID)
+	}
+	log.Printf("session %s: question %s cancelled", pm.Session, pm.ID)
+
+	if tr, ok := d.transports[pm.Addr.Transport]; ok && tr.Capabilities().Edit {
+		go func() {
+			ctx, cancel := context.WithTimeout(d.ctx, 30*time.Second)
+			defer cancel()
+			if err := tr.Edit(ctx, pm.Addr.Chat, pm.MsgID, pm.Content+"\n\n(cancelled)"); err != nil {
+				log.Printf("failed to mark question %s as cancelled in %s: %v", pm.ID, pm.Addr, err)
+			}
+		}()
+	}
+	return &ipc.Response{Success: true, ID: pm.ID, State: ipc.StateCancelled}
+}
+
+// handleNotify delivers a message that expects no reply.
+func (d *daemon) handleNotify(req *ipc.Request) *ipc.Response {
+	if d.ctx.Err() != nil {
+		return ipc.Errorf(ipc.CodeShuttingDown, ipc.ErrShuttingDown)
+	}
+
+	sess, err := d.sessions.Config().ResolveSession(req.Session, req.WorkDir)
+	if err != nil {
+		return ipc.Errorf(ipc.CodeNoSession, "%v", err)
+	}
+	addr := sess.Address()
+	tr, ok := d.transports[addr.Transport]
+	if !ok {
+		return ipc.Errorf(ipc.CodeTransportUnavailable, "session %q uses transport %q, which is not running", sess.Name, addr.Transport)
+	}
+
+	timeout := sess.Timeout
+	if req.Timeout > 0 {
+		timeout = req.Timeout
+	}
+	ctx, cancel := context.WithTimeout(d.ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	if _, err := tr.Send(ctx, addr.Chat, transport.Message{Session: sess.Name, Text: req.Message, Format: sess.Format, Notice: true}); err != nil {
+		if d.ctx.Err() != nil {
+			return ipc.Errorf(ipc.CodeShuttingDown, ipc.ErrShuttingDown)
+		}
+		if ctx.Err() != nil {
+			return ipc.Errorf(ipc.CodeTimeout, "message not delivered before timeout: %v", err)
+		}
+		return ipc.Errorf(ipc.CodeDeliveryFailed, "%v", err)
+	}
+	return &ipc.Response{Success: true}
+}
+
+// handleSubscribe streams events until the client goes away or the daemon
+// stops. With req.Session, chat events are limited to that session's chat;
+// connection changes are always sent.
+func (d *daemon) handleSubscribe(req *ipc.Request) *ipc.Response {
+	events, unsubscribe := d.sessions.Subscribe()
+	out := make(chan ipc.Event)
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				if req.Session != "" && ev.Session != req.Session && ev.Type != session.EventConnection {
+					continue
+				}
+				select {
+				case out <- ipc.Event(ev):
+				case <-stop:
+					return
+				}
+			case <-stop:
+				return
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return &ipc.Response{Success: true, Events: out, Unsubscribe: func() {
+		unsubscribe()
+		close(stop)
+	}}
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bupd/go-claude-code-telegram/internal/ipc"
+)
+
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Make the daemon reload its config",
+	Long: `Make the running daemon re-read its config file and print what changed.
+
+The new config is validated first; if it has errors the daemon keeps the
+previous one. The daemon also reloads on SIGHUP and when the file changes.
+
+Changes marked "restart to apply", such as a session on a transport the
+daemon wasn't started with or a Matrix room it hasn't joined, need a
+restart with 'cctg serve --replace'.`,
+	RunE: runReload,
+}
+
+func init() {
+	rootCmd.AddCommand(reloadCmd)
+}
+
+func runReload(cmd *cobra.Command, args []string) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	if !client.IsRunning() {
+		return fmt.Errorf("daemon not running. start with: cctg serve")
+	}
+
+	resp, err := client.Send(&ipc.Request{Type: ipc.RequestTypeReload})
+	if err != nil {
+		return fmt.Errorf("reloading: %w", err)
+	}
+	if !resp.Success {
+		return fmt.Errorf("reload failed: %s", resp.Error)
+	}
+
+	fmt.Println(resp.Reply)
+	return nil
+}
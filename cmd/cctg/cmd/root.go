@@ -4,18 +4,22 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/bupd/go-claude-code-telegram/internal/version"
 )
 
 var (
 	cfgFile    string
 	sessionArg string
 	timeoutArg int
+	daemonArg  string
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "cctg",
-	Short: "Claude Code Telegram Bot",
-	Long:  "A Telegram bot that bridges Claude Code CLI with Telegram users.",
+	Use:     "cctg",
+	Short:   "Claude Code Telegram Bot",
+	Long:    "A Telegram bot that bridges Claude Code CLI with Telegram users.",
+	Version: version.String(),
 }
 
 func Execute() {
@@ -28,4 +32,5 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file path")
 	rootCmd.PersistentFlags().StringVar(&sessionArg, "session", "", "session name")
 	rootCmd.PersistentFlags().IntVar(&timeoutArg, "timeout", 0, "timeout in seconds")
+	rootCmd.PersistentFlags().StringVar(&daemonArg, "daemon", "", "daemon address, tcp://host:port or https://host:port (default $CCTG_DAEMON, else the local socket)")
 }
@@ -2,9 +2,12 @@ package cmd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -23,17 +26,31 @@ Message can be provided as:
 
 Session selection:
   --session <name>  Specify session by name (recommended)
+  .cctg.yaml        Repo config found by walking up from the working directory
   (fallback)        Auto-detect from working directory`,
 	Example: `  # Recommended: use --session flag
   cctg send --session myproject "Deploy to production?"
 
   # Or via stdin
-  echo "Review this change?" | cctg send --session myproject`,
+  echo "Review this change?" | cctg send --session myproject
+
+  # Attach files (Telegram sends them as documents)
+  cctg send --session myproject --attach plan.md "Does this plan look right?"
+
+  # Offer answers as buttons
+  cctg send --session myproject --choice yes --choice no "Merge now?"`,
 	RunE: runSend,
 }
 
+var (
+	sendAttach  []string
+	sendChoices []string
+)
+
 func init() {
 	rootCmd.AddCommand(sendCmd)
+	sendCmd.Flags().StringArrayVar(&sendAttach, "attach", nil, "file to send with the message (repeatable)")
+	sendCmd.Flags().StringArrayVar(&sendChoices, "choice", nil, "answer offered as a button (repeatable)")
 }
 
 func runSend(cmd *cobra.Command, args []string) error {
@@ -60,27 +77,54 @@ func runSend(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("message required: cctg send \"your message\" or echo \"message\" | cctg send")
 	}
 
-	client := ipc.NewClient(config.GetSocketPath())
+	attachments, err := resolveAttachments(sendAttach)
+	if err != nil {
+		return err
+	}
+
+	workDir, _ := os.Getwd()
+	fallback := resolveFallback(workDir)
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	if len(attachments) > 0 && client.Remote() {
+		return fmt.Errorf("attachments need a daemon on this machine; --attach does not work with a remote daemon")
+	}
 
 	if !client.IsRunning() {
-		fmt.Println("user didn't reply go ahead with caution, don't make huge refactor, check what you are doing")
-		return nil
+		return noReply(fallback)
 	}
 
-	workDir, _ := os.Getwd()
+	var needs []string
+	if len(attachments) > 0 {
+		needs = append(needs, ipc.CapAttachments)
+	}
+	if len(sendChoices) > 0 {
+		needs = append(needs, ipc.CapChoices)
+	}
+	if err := client.Require(needs...); err != nil {
+		var mismatch *ipc.MismatchError
+		if errors.As(err, &mismatch) {
+			return err
+		}
+		return noReply(fallback)
+	}
 
 	req := &ipc.Request{
-		Type:    ipc.RequestTypeSend,
-		Session: sessionArg,
-		Message: message,
-		Timeout: timeoutArg,
-		WorkDir: workDir,
+		Type:        ipc.RequestTypeSend,
+		Session:     sessionArg,
+		Message:     message,
+		Timeout:     timeoutArg,
+		WorkDir:     workDir,
+		Attachments: attachments,
+		Choices:     sendChoices,
 	}
 
-	resp, err := client.Send(req)
+	resp, err := sendRetryingHandover(client, req)
 	if err != nil {
-		fmt.Println("user didn't reply go ahead with caution, don't make huge refactor, check what you are doing")
-		return nil
+		return noReply(fallback)
 	}
 
 	if !resp.Success {
@@ -90,3 +134,77 @@ func runSend(cmd *cobra.Command, args []string) error {
 	fmt.Println(resp.Reply)
 	return nil
 }
+
+// resolveAttachments makes attachment paths absolute, since the daemon
+// doesn't share our working directory, and checks that they are files.
+func resolveAttachments(paths []string) ([]string, error) {
+	var abs []string
+	for _, p := range paths {
+		a, err := filepath.Abs(p)
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s: %w", p, err)
+		}
+		info, err := os.Stat(a)
+		if err != nil {
+			return nil, fmt.Errorf("attachment: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("attachment %s is not a regular file", p)
+		}
+		abs = append(abs, a)
+	}
+	return abs, nil
+}
+
+// sendRetryingHandover resends req while the daemon answers that it is
+// shutting down, so a question asked during "cctg serve --replace" reaches
+// the new daemon.
+func sendRetryingHandover(client *ipc.Client, req *ipc.Request) (*ipc.Response, error) {
+	deadline := time.Now().Add(replaceTimeout)
+	handover := false
+	for {
+		resp, err := client.Send(req)
+		switch {
+		case err != nil && !handover:
+			return nil, err
+		case err == nil && !shuttingDown(resp):
+			return resp, nil
+		}
+
+		// The old daemon is going away; the socket may be briefly missing
+		// until its replacement is listening.
+		handover = true
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("daemon did not come back after shutting down")
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// shuttingDown reports whether resp says the daemon is going away. Daemons
+// from before error codes only sent the message.
+func shuttingDown(resp *ipc.Response) bool {
+	return !resp.Success && (resp.Code == ipc.CodeShuttingDown || resp.Error == ipc.ErrShuttingDown)
+}
+
+// resolveFallback determines the fallback policy used when the daemon can't
+// be reached, preferring the resolved session and then the repo config.
+func resolveFallback(workDir string) string {
+	if cfg, err := config.Load(cfgFile); err == nil {
+		if sess, err := cfg.ResolveSession(sessionArg, workDir); err == nil {
+			return sess.Fallback
+		}
+	}
+	if repo, err := config.FindRepoConfig(workDir); err == nil && repo != nil && repo.Fallback != "" {
+		return repo.Fallback
+	}
+	return config.FallbackContinue
+}
+
+func noReply(fallback string) error {
+	if fallback == config.FallbackFail {
+		return fmt.Errorf("no reply from user")
+	}
+	fmt.Println(config.DefaultFallbackMessage)
+	return nil
+}
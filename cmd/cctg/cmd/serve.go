@@ -2,90 +2,498 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/console"
+	"github.com/bupd/go-claude-code-telegram/internal/dashboard"
 	"github.com/bupd/go-claude-code-telegram/internal/ipc"
+	"github.com/bupd/go-claude-code-telegram/internal/matrix"
 	"github.com/bupd/go-claude-code-telegram/internal/session"
+	"github.com/bupd/go-claude-code-telegram/internal/slack"
+	"github.com/bupd/go-claude-code-telegram/internal/state"
+	"github.com/bupd/go-claude-code-telegram/internal/systemd"
 	"github.com/bupd/go-claude-code-telegram/internal/telegram"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+	"github.com/bupd/go-claude-code-telegram/internal/version"
+	"github.com/bupd/go-claude-code-telegram/internal/webhook"
 )
 
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Run the Telegram bot daemon",
-	RunE:  runServe,
+	Long: `Run the Telegram bot daemon.
+
+The config file is reloaded without a restart when it changes on disk, on
+SIGHUP, or on "cctg reload". Pending questions survive a reload.
+
+Only one daemon runs per socket. Use --replace to ask a running daemon to
+hand over and exit, for example when switching from a manual run to the
+systemd unit.
+
+With --console every session asks its questions on this terminal instead,
+and answers are typed into it. No bot token or network is needed.`,
+	RunE: runServe,
 }
 
+var (
+	serveReplace bool
+	serveConsole bool
+)
+
+// replaceTimeout bounds how long --replace waits for the old daemon to exit.
+const replaceTimeout = 30 * time.Second
+
+const (
+	// noticeTimeout bounds how long a notice may wait for its transport.
+	noticeTimeout = time.Minute
+	noticeQueue   = 64
+)
+
 func init() {
 	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().BoolVar(&serveReplace, "replace", false, "ask a running daemon to exit and take over")
+	serveCmd.Flags().BoolVar(&serveConsole, "console", false, "ask questions on this terminal instead of the configured transports")
+}
+
+type daemon struct {
+	started    time.Time
+	ctx        context.Context
+	shutdown   context.CancelFunc
+	sessions   *session.Manager
+	bot        *telegram.Bot
+	transports map[string]transport.Transport
+	reloadMu   sync.Mutex
+
+	questionsMu sync.Mutex
+	questions   map[string]*question
+
+	notices chan notice
+}
+
+// notice is a message nobody waits on, queued for sendNotices.
+type notice struct {
+	addr transport.Address
+	text string
 }
 
 func runServe(cmd *cobra.Command, args []string) error {
-	cfg, err := config.Load(cfgFile)
+	cfg, err := loadValidConfig(cfgFile)
 	if err != nil {
-		return fmt.Errorf("loading config: %w", err)
+		return err
 	}
 
-	sessions := session.NewManager(cfg)
-
-	bot, err := telegram.NewBot(cfg, sessions)
+	store, err := state.Open(config.GetStatePath())
 	if err != nil {
-		return fmt.Errorf("creating bot: %w", err)
+		log.Printf("starting with empty state: %v", err)
+		store = state.New(config.GetStatePath())
 	}
 
-	server := ipc.NewServer(config.GetSocketPath(), func(req *ipc.Request) *ipc.Response {
-		return handleIPCRequest(req, cfg, sessions, bot)
-	})
+	sessions := session.NewManager(cfg)
+	sessions.SetStore(store)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if err := server.Start(ctx); err != nil {
-		return fmt.Errorf("starting ipc server: %w", err)
+	d := &daemon{
+		started:    time.Now(),
+		ctx:        ctx,
+		shutdown:   cancel,
+		sessions:   sessions,
+		transports: make(map[string]transport.Transport),
+		questions:  make(map[string]*question),
+		notices:    make(chan notice, noticeQueue),
+	}
+	if err := d.newTransports(cfg, store); err != nil {
+		return err
+	}
+
+	server := ipc.NewServer(config.GetSocketPath(), d.handleIPCRequest)
+	server.AllowPeers(cfg.Socket)
+
+	if err := startServer(ctx, server); err != nil {
+		return err
 	}
 	defer server.Stop()
 
-	log.Printf("daemon started, socket: %s", server.SocketPath())
+	if server.Activated() {
+		log.Printf("daemon started, socket: %s (from systemd)", server.SocketPath())
+	} else {
+		log.Printf("daemon started, socket: %s", server.SocketPath())
+	}
+
+	if cfg.Remote.Listen != "" {
+		remote, err := ipc.NewRemoteServer(cfg.Remote, d.handleIPCRequest)
+		if err != nil {
+			return err
+		}
+		if err := remote.Start(ctx); err != nil {
+			return err
+		}
+		defer remote.Stop()
+		log.Printf("remote listener on %s", remote.URL())
+	}
+
+	if err := config.Watch(ctx, cfg.Path(), func() { d.reload("config file changed") }); err != nil {
+		log.Printf("not watching config for changes: %v", err)
+	}
 
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	errCh := make(chan error, 1)
-	go func() {
-		errCh <- bot.Start(ctx)
-	}()
+	errCh := make(chan error, len(d.transports)+1)
+	for _, tr := range d.transports {
+		go func() {
+			errCh <- tr.Start(ctx)
+		}()
+		go d.receive(tr)
+	}
+	go d.sendNotices()
 
-	select {
-	case sig := <-sigCh:
-		log.Printf("received signal: %s", sig)
-		cancel()
+	if cfg.Dashboard.Listen != "" {
+		dash := dashboard.New(cfg.Dashboard, sessions, d.status, d.answerFromDashboard)
+		go func() {
+			errCh <- dash.Start(ctx)
+		}()
+		if cfg.Dashboard.Token == "" {
+			showDashboardLogin(cfg.Dashboard.Listen, dash.Token())
+		}
+	}
+
+	d.notifyReady()
+	defer sdNotify(systemd.Stopping)
+
+	for {
+		select {
+		case sig := <-sigCh:
+			if sig == syscall.SIGHUP {
+				d.reload("received SIGHUP")
+				sdNotify(systemd.Status(d.statusLine()))
+				continue
+			}
+			log.Printf("received signal: %s", sig)
+			cancel()
+			return nil
+		case err := <-errCh:
+			return err
+		case <-ctx.Done():
+			log.Printf("shutdown requested")
+			return nil
+		}
+	}
+}
+
+// newTransports creates the transports the configured sessions use. Telegram
+// is also started when there are no sessions yet, since creating the first
+// one captures its chat through the bot.
+func (d *daemon) newTransports(cfg *config.Config, store *state.Store) error {
+	needed := make(map[string]bool)
+	for _, sess := range cfg.Sessions {
+		needed[sess.TransportName()] = true
+	}
+	if len(cfg.Sessions) == 0 {
+		if serveConsole {
+			needed[transport.Console] = true
+		} else {
+			needed[transport.Telegram] = true
+		}
+	}
+
+	for name := range needed {
+		switch name {
+		case transport.Telegram:
+			bot, err := telegram.NewBot(cfg, d.sessions, store)
+			if err != nil {
+				return fmt.Errorf("creating bot: %w", err)
+			}
+			d.bot = bot
+			d.transports[name] = bot
+		case transport.Matrix:
+			mx, err := matrix.New(cfg, d.sessions, store)
+			if err != nil {
+				return fmt.Errorf("creating matrix transport: %w", err)
+			}
+			d.transports[name] = mx
+		case transport.Slack:
+			sl, err := slack.New(cfg, d.sessions)
+			if err != nil {
+				return fmt.Errorf("creating slack transport: %w", err)
+			}
+			d.transports[name] = sl
+		case transport.Webhook:
+			hook, err := webhook.New(cfg, d.sessions)
+			if err != nil {
+				return fmt.Errorf("creating webhook transport: %w", err)
+			}
+			d.transports[name] = hook
+		case transport.Console:
+			d.transports[name] = console.New(os.Stdin, os.Stdout, d.sessions)
+		}
+	}
+	return nil
+}
+
+// startServer starts the IPC server. With --replace, a running daemon is
+// asked to shut down and the start is retried until it has released the
+// socket.
+func startServer(ctx context.Context, server *ipc.Server) error {
+	err := server.Start(ctx)
+	if err == nil || !errors.Is(err, ipc.ErrAlreadyRunning) {
+		if err != nil {
+			return fmt.Errorf("starting ipc server: %w", err)
+		}
 		return nil
-	case err := <-errCh:
+	}
+	if !serveReplace {
+		return fmt.Errorf("%w; stop it first or use --replace", err)
+	}
+
+	log.Printf("%v, asking it to hand over", err)
+	client := ipc.NewClient(server.SocketPath())
+	if resp, err := client.Send(&ipc.Request{Type: ipc.RequestTypeShutdown}); err != nil {
+		log.Printf("shutdown request failed: %v", err)
+	} else if !resp.Success {
+		return fmt.Errorf("running daemon refused to shut down: %s", resp.Error)
+	}
+
+	deadline := time.Now().Add(replaceTimeout)
+	for {
+		err := server.Start(ctx)
+		if err == nil {
+			log.Printf("took over from previous daemon")
+			return nil
+		}
+		if !errors.Is(err, ipc.ErrAlreadyRunning) || time.Now().After(deadline) {
+			return fmt.Errorf("starting ipc server: %w", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// loadValidConfig loads the config and refuses it if validation finds
+// errors. Warnings are logged. With --console all sessions are switched to
+// the console transport.
+func loadValidConfig(path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+
+	var diags config.Diagnostics
+	if serveConsole {
+		cfg.UseTransport(transport.Console)
+		diags = cfg.ValidateAs(transport.Console)
+	} else {
+		diags = cfg.Validate()
+	}
+	for _, d := range diags {
+		if d.Severity == config.SeverityWarning {
+			log.Printf("config %s", d)
+		}
+	}
+	if err := diags.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// reload re-reads the config file the daemon was started with and swaps it
+// in if it is valid. The old config stays active on any error.
+func (d *daemon) reload(reason string) ([]string, error) {
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+
+	old := d.sessions.Config()
+	log.Printf("reloading config (%s)", reason)
+
+	cfg, err := loadValidConfig(old.Path())
+	if err != nil {
+		log.Printf("config reload failed, keeping previous config: %v", err)
+		return nil, err
+	}
+
+	changes := append(config.Diff(old, cfg), d.stoppedTransports(cfg)...)
+	d.sessions.SetConfig(cfg)
+
+	if len(changes) == 0 {
+		log.Printf("config reloaded, no changes")
+	}
+	for _, c := range changes {
+		log.Printf("config reloaded: %s", c)
+	}
+	return changes, nil
+}
+
+// stoppedTransports reports the sessions of cfg whose transport the daemon
+// didn't start. Transports are only created at startup.
+func (d *daemon) stoppedTransports(cfg *config.Config) []string {
+	var changes []string
+	for _, sess := range cfg.Sessions {
+		if _, ok := d.transports[sess.TransportName()]; !ok {
+			changes = append(changes, fmt.Sprintf("session %q uses transport %s, which is not running (restart to apply)", sess.Name, sess.TransportName()))
+		}
+	}
+	return changes
+}
+
+// receive routes messages from tr to the sessions until the daemon stops.
+func (d *daemon) receive(tr transport.Transport) {
+	for {
+		select {
+		case in := <-tr.Incoming():
+			d.sessions.Receive(in)
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// notify queues text for addr, for messages nobody waits on. Notices are
+// sent in order; if too many are waiting the new one is dropped.
+func (d *daemon) notify(addr transport.Address, text string) {
+	select {
+	case d.notices <- notice{addr: addr, text: text}:
+	default:
+		log.Printf("dropping notice to %s: too many waiting", addr)
+	}
+}
+
+// sendNotices delivers queued notices until the daemon stops.
+func (d *daemon) sendNotices() {
+	for {
+		select {
+		case n := <-d.notices:
+			d.sendNotice(n)
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *daemon) sendNotice(n notice) {
+	tr, ok := d.transports[n.addr.Transport]
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(d.ctx, noticeTimeout)
+	defer cancel()
+	if _, err := tr.Send(ctx, n.addr.Chat, transport.Message{Text: n.text, Notice: true}); err != nil {
+		log.Printf("failed to notify %s: %v", n.addr, err)
+	}
+}
+
+// showDashboardLogin hands out the login link for a generated dashboard
+// token. It goes to a private file and, when run by hand, the terminal, but
+// never to the log, which journald keeps readable to other admins.
+func showDashboardLogin(listen, token string) {
+	link := fmt.Sprintf("http://%s/?token=%s", listen, token)
+	if stat, err := os.Stderr.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprintf(os.Stderr, "dashboard: %s\n", link)
+	}
+
+	path := config.DashboardTokenPath()
+	if err := writePrivateFile(path, link+"\n"); err != nil {
+		log.Printf("dashboard token generated, but writing the login link failed: %v", err)
+		return
+	}
+	log.Printf("dashboard token generated, login link written to %s", path)
+}
+
+// writePrivateFile replaces path with data readable only by the owner, also
+// when an older copy had looser permissions.
+func writePrivateFile(path, data string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
 		return err
 	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteString(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// answerFromDashboard answers a pending question the way a reply in its
+// chat would, then edits the question to say it was answered elsewhere.
+func (d *daemon) answerFromDashboard(id, text string) error {
+	pm, ok := d.sessions.Answer(id, text, session.SourceDashboard)
+	if !ok {
+		return dashboard.ErrNotPending
+	}
+	log.Printf("session %s: question %s answered from the dashboard", pm.Session, pm.ID)
+
+	tr, ok := d.transports[pm.Addr.Transport]
+	if !ok || !tr.Capabilities().Edit {
+		return nil
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(d.ctx, 30*time.Second)
+		defer cancel()
+		edited := pm.Content + "\n\nAnswered from the dashboard: " + text
+		if err := tr.Edit(ctx, pm.Addr.Chat, pm.MsgID, edited); err != nil {
+			log.Printf("failed to mark question %s as answered in %s: %v", pm.ID, pm.Addr, err)
+		}
+	}()
+	return nil
 }
 
-func handleIPCRequest(req *ipc.Request, cfg *config.Config, sessions *session.Manager, bot *telegram.Bot) *ipc.Response {
+func (d *daemon) handleIPCRequest(req *ipc.Request) *ipc.Response {
 	switch req.Type {
 	case ipc.RequestTypeGetChatID:
-		return handleGetChatID(req, sessions)
+		return d.handleGetChatID(req)
 	case ipc.RequestTypeSend:
-		return handleSend(req, cfg, sessions, bot)
+		return d.handleSend(req)
+	case ipc.RequestTypeAsk:
+		return d.handleAsk(req)
+	case ipc.RequestTypeWait:
+		return d.handleWait(req)
+	case ipc.RequestTypeCancel:
+		return d.handleCancel(req)
+	case ipc.RequestTypeNotify:
+		return d.handleNotify(req)
+	case ipc.RequestTypeSubscribe:
+		return d.handleSubscribe(req)
+	case ipc.RequestTypeReload:
+		return d.handleReload(req)
+	case ipc.RequestTypeStatus:
+		return d.handleStatus(req)
+	case ipc.RequestTypeShutdown:
+		log.Printf("shutdown requested over ipc")
+		d.shutdown()
+		return &ipc.Response{Success: true}
+	case ipc.RequestTypeHello:
+		return &ipc.Response{Success: true, Hello: &ipc.Hello{
+			Version:       ipc.ProtocolVersion,
+			MinVersion:    ipc.MinProtocolVersion,
+			DaemonVersion: version.String(),
+			Capabilities:  []string{ipc.CapAttachments, ipc.CapChoices, ipc.CapAsync, ipc.CapNotify, ipc.CapEvents},
+		}}
 	default:
-		return &ipc.Response{Success: false, Error: "unknown request type"}
+		return ipc.Errorf(ipc.CodeUnknownRequest, "unknown request type %q", req.Type)
 	}
 }
 
-func handleGetChatID(req *ipc.Request, sessions *session.Manager) *ipc.Response {
-	capture := sessions.StartChatIDCapture()
+func (d *daemon) handleGetChatID(req *ipc.Request) *ipc.Response {
+	capture := d.sessions.StartChatIDCapture()
 
 	timeout := 60
 	if req.Timeout > 0 {
@@ -93,55 +501,112 @@ func handleGetChatID(req *ipc.Request, sessions *session.Manager) *ipc.Response
 	}
 
 	select {
-	case chatID := <-capture.ResponseCh:
-		return &ipc.Response{Success: true, ChatID: chatID}
+	case addr := <-capture.ResponseCh:
+		resp := &ipc.Response{Success: true, Chat: addr.String()}
+		if addr.Transport == transport.Telegram {
+			resp.ChatID, _ = strconv.ParseInt(addr.Chat, 10, 64)
+		}
+		return resp
 	case <-time.After(time.Duration(timeout) * time.Second):
-		sessions.CancelChatIDCapture()
-		return &ipc.Response{Success: false, Error: "timeout waiting for message"}
+		d.sessions.CancelChatIDCapture()
+		return ipc.Errorf(ipc.CodeTimeout, "timeout waiting for message")
+	case <-d.ctx.Done():
+		d.sessions.CancelChatIDCapture()
+		return ipc.Errorf(ipc.CodeShuttingDown, ipc.ErrShuttingDown)
 	}
 }
 
-func handleSend(req *ipc.Request, cfg *config.Config, sessions *session.Manager, bot *telegram.Bot) *ipc.Response {
+func (d *daemon) handleStatus(req *ipc.Request) *ipc.Response {
+	return &ipc.Response{Success: true, Status: d.status()}
+}
+
+func (d *daemon) status() *ipc.Status {
+	cfg := d.sessions.Config()
+	now := time.Now()
 
-	var sess *config.SessionConfig
-	if req.Session != "" {
-		sess = cfg.FindSessionByName(req.Session)
-	} else if req.WorkDir != "" {
-		sess = cfg.FindSessionByWorkDir(req.WorkDir)
+	status := &ipc.Status{
+		Version:       version.String(),
+		PID:           os.Getpid(),
+		StartedAt:     d.started,
+		UptimeSeconds: int64(now.Sub(d.started).Seconds()),
+		ConfigPath:    cfg.Path(),
+		Telegram:      ipc.TelegramStatus{State: telegram.StateDisabled},
+	}
+	if d.bot != nil {
+		h := d.bot.Health()
+		status.BotUsername = d.bot.Username()
+		status.Telegram = ipc.TelegramStatus{
+			State:         h.State,
+			LastError:     h.LastError,
+			LastErrorAt:   h.LastErrorAt,
+			ConflictSince: h.ConflictSince,
+			LastUpdateAt:  h.LastUpdateAt,
+		}
 	}
 
-	if sess == nil {
-		return &ipc.Response{Success: false, Error: "session not found"}
+	// Sessions sharing a chat report the same counts, since questions are
+	// tracked per chat.
+	stats := d.sessions.Stats()
+	known := make(map[transport.Address]bool)
+	for _, sess := range cfg.Sessions {
+		addr := sess.Address()
+		known[addr] = true
+		status.Sessions = append(status.Sessions, sessionStatus(sess.Name, addr, stats[addr], now))
 	}
+	// Chats of sessions removed by a reload while questions were pending.
+	for addr, st := range stats {
+		if !known[addr] {
+			status.Sessions = append(status.Sessions, sessionStatus("", addr, st, now))
+		}
+	}
+	return status
+}
 
-	queued := sessions.PopQueuedMessages(sess.ChatID)
+func sessionStatus(name string, addr transport.Address, st session.ChatStats, now time.Time) ipc.SessionStatus {
+	ss := ipc.SessionStatus{Name: name, Transport: addr.Transport, Chat: addr.Chat, Pending: st.Pending, Queued: st.Queued}
+	if addr.Transport == transport.Telegram {
+		ss.ChatID, _ = strconv.ParseInt(addr.Chat, 10, 64)
+	}
+	if !st.OldestPending.IsZero() {
+		ss.OldestPendingAgeSeconds = int64(now.Sub(st.OldestPending).Seconds())
+	}
+	return ss
+}
 
-	msgID, err := bot.SendMessage(sess.ChatID, req.Message)
+func (d *daemon) handleReload(req *ipc.Request) *ipc.Response {
+	changes, err := d.reload("reload requested")
 	if err != nil {
-		return &ipc.Response{Success: false, Error: err.Error()}
+		return ipc.Errorf(ipc.CodeInvalidConfig, "%v", err)
 	}
+	if len(changes) == 0 {
+		return &ipc.Response{Success: true, Reply: "no changes"}
+	}
+	return &ipc.Response{Success: true, Reply: strings.Join(changes, "\n")}
+}
 
-	pending := sessions.AddPending(sess.ChatID, msgID, req.Message)
-
-	timeout := cfg.Timeout
-	if req.Timeout > 0 {
-		timeout = req.Timeout
+// withChoices lists choices under text for transports without buttons.
+func withChoices(text string, choices []string) string {
+	var b strings.Builder
+	b.WriteString(text)
+	b.WriteString("\n")
+	for _, c := range choices {
+		b.WriteString("\n- " + c)
 	}
+	return b.String()
+}
 
-	select {
-	case reply := <-pending.ResponseCh:
-		finalReply := combineMessages(queued, reply)
-		return &ipc.Response{Success: true, Reply: finalReply}
-	case <-time.After(time.Duration(timeout) * time.Second):
-		sessions.RemovePending(sess.ChatID, pending)
-		bot.NotifyTimeout(sess.ChatID)
-		if len(queued) > 0 {
-			return &ipc.Response{Success: true, Reply: strings.Join(queued, "\n")}
-		}
-		return &ipc.Response{
-			Success: true,
-			Reply:   "user didn't reply go ahead with caution, don't make huge refactor, check what you are doing",
-		}
+// noReplyResponse answers a send that timed out, returning any messages the
+// user sent unprompted or else applying the session's fallback policy.
+func noReplyResponse(sess *config.SessionConfig, queued []string) *ipc.Response {
+	if len(queued) > 0 {
+		return &ipc.Response{Success: true, Reply: strings.Join(queued, "\n")}
+	}
+	if sess.Fallback == config.FallbackFail {
+		return ipc.Errorf(ipc.CodeTimeout, "no reply before timeout")
+	}
+	return &ipc.Response{
+		Success: true,
+		Reply:   config.DefaultFallbackMessage,
 	}
 }
 
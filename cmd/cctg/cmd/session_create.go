@@ -77,7 +77,10 @@ func runSessionCreate(cmd *cobra.Command, args []string) error {
 
 	chatID := createChatID
 	if chatID == 0 {
-		client := ipc.NewClient(config.GetSocketPath())
+		client, err := newClient()
+		if err != nil {
+			return err
+		}
 		if !client.IsRunning() {
 			return fmt.Errorf("daemon not running. start with: cctg serve")
 		}
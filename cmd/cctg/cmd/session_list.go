@@ -39,7 +39,7 @@ func runSessionList(cmd *cobra.Command, args []string) error {
 	}
 
 	for _, sess := range cfg.Sessions {
-		fmt.Printf("%s\n  chat_id: %d\n  working_dir: %s\n", sess.Name, sess.ChatID, sess.WorkingDir)
+		fmt.Printf("%s\n  transport: %s\n  chat_id: %d\n  working_dir: %s\n", sess.Name, sess.TransportName(), sess.ChatID, sess.WorkingDir)
 	}
 	return nil
 }
@@ -1,31 +1,122 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 
-	"github.com/bupd/go-claude-code-telegram/internal/config"
 	"github.com/bupd/go-claude-code-telegram/internal/ipc"
+	"github.com/bupd/go-claude-code-telegram/internal/telegram"
+)
+
+var (
+	statusVerbose bool
+	statusJSON    bool
 )
 
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Check if daemon is running",
-	RunE:  runStatus,
+	Long: `Check if the daemon is running and whether it is connected to Telegram.
+
+With --verbose, also show the daemon version, uptime, bot, config file and
+per-session pending questions and queued messages. --json prints the same
+information for scripts.`,
+	RunE: runStatus,
 }
 
 func init() {
 	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().BoolVarP(&statusVerbose, "verbose", "v", false, "show details and per-session counts")
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "print status as JSON")
 }
 
 func runStatus(cmd *cobra.Command, args []string) error {
-	client := ipc.NewClient(config.GetSocketPath())
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
 
-	if client.IsRunning() {
-		fmt.Println("daemon is running")
-	} else {
+	if !client.IsRunning() {
+		if statusJSON {
+			return printJSON(map[string]bool{"running": false})
+		}
 		fmt.Println("daemon is not running")
+		return nil
+	}
+
+	resp, err := client.Send(&ipc.Request{Type: ipc.RequestTypeStatus})
+	if err != nil || !resp.Success || resp.Status == nil {
+		if statusJSON {
+			return printJSON(map[string]bool{"running": true})
+		}
+		fmt.Println("daemon is running")
+		return nil
+	}
+	st := resp.Status
+
+	if statusJSON {
+		return printJSON(struct {
+			Running bool `json:"running"`
+			*ipc.Status
+		}{true, st})
+	}
+
+	fmt.Println("daemon is running")
+
+	tg := st.Telegram
+	fmt.Printf("telegram: %s\n", tg.State)
+	if tg.State != telegram.StateConnected && tg.LastError != "" {
+		fmt.Printf("last error (%s): %s\n", tg.LastErrorAt.Format(time.RFC3339), tg.LastError)
+	}
+	if tg.State == telegram.StateConflict {
+		fmt.Printf("warning: telegram getUpdates conflict since %s: another process is polling with this bot token, updates are being split\n",
+			tg.ConflictSince.Format(time.RFC3339))
+	}
+
+	if statusVerbose {
+		printVerboseStatus(st)
 	}
 	return nil
 }
+
+func printVerboseStatus(st *ipc.Status) {
+	fmt.Printf("version: %s (pid %d)\n", st.Version, st.PID)
+	fmt.Printf("uptime: %s\n", formatAge(st.UptimeSeconds))
+	if st.BotUsername != "" {
+		fmt.Printf("bot: @%s\n", st.BotUsername)
+	}
+	if !st.Telegram.LastUpdateAt.IsZero() {
+		fmt.Printf("last update: %s ago\n", formatAge(int64(time.Since(st.Telegram.LastUpdateAt).Seconds())))
+	}
+	fmt.Printf("config: %s\n\n", st.ConfigPath)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tTRANSPORT\tCHAT\tPENDING\tQUEUED\tOLDEST PENDING")
+	for _, s := range st.Sessions {
+		name, oldest := s.Name, "-"
+		if name == "" {
+			// A session removed by a reload while questions were pending.
+			name = "(removed session)"
+		}
+		if s.Pending > 0 {
+			oldest = formatAge(s.OldestPendingAgeSeconds)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", name, s.Transport, s.Chat, s.Pending, s.Queued, oldest)
+	}
+	w.Flush()
+}
+
+func formatAge(seconds int64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/systemd"
+)
+
+// sdNotify sends states to systemd when running as a Type=notify service.
+func sdNotify(states ...string) {
+	for _, state := range states {
+		if _, err := systemd.Notify(state); err != nil {
+			log.Printf("systemd notify: %v", err)
+			return
+		}
+	}
+}
+
+// notifyReady tells systemd the daemon is up, then keeps its status line
+// current and pings the watchdog until the daemon stops.
+func (d *daemon) notifyReady() {
+	sdNotify(systemd.Ready, systemd.Status(d.statusLine()))
+
+	events, unsubscribe := d.sessions.Subscribe()
+	go func() {
+		defer unsubscribe()
+		for {
+			select {
+			case <-events:
+				sdNotify(systemd.Status(d.statusLine()))
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		go d.watchdog(interval / 2)
+	}
+}
+
+// watchdog pings systemd every interval while the daemon can still build
+// its status, which takes the locks a stuck daemon would be holding.
+func (d *daemon) watchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.status()
+			sdNotify(systemd.Watchdog)
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// statusLine summarizes the daemon for systemctl status.
+func (d *daemon) statusLine() string {
+	st := d.status()
+	// Sessions sharing a chat report the same count.
+	seen := make(map[string]bool)
+	pending := 0
+	for _, s := range st.Sessions {
+		key := s.Transport + ":" + s.Chat
+		if !seen[key] {
+			seen[key] = true
+			pending += s.Pending
+		}
+	}
+	line := fmt.Sprintf("%d sessions, %d pending questions", len(d.sessions.Config().Sessions), pending)
+	if d.bot != nil {
+		line += ", telegram " + st.Telegram.State
+	}
+	return line
+}
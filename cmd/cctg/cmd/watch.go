@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bupd/go-claude-code-telegram/internal/ipc"
+	"github.com/bupd/go-claude-code-telegram/internal/session"
+)
+
+var (
+	watchSession string
+	watchJSON    bool
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Print questions, messages and connection changes as they happen",
+	Long: `Print events from the daemon until interrupted: questions asked, answered,
+expired or cancelled, messages queued for the next question, commands (messages
+starting with "/" that aren't replies) and connection state changes.
+
+With --session, only that session's events are shown. --json prints one JSON
+object per line for scripts.`,
+	Args: cobra.NoArgs,
+	RunE: runWatch,
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().StringVarP(&watchSession, "session", "s", "", "only show events for this session")
+	watchCmd.Flags().BoolVar(&watchJSON, "json", false, "print events as JSON lines")
+}
+
+func runWatch(cmd *cobra.Command, args []string) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	if !client.IsRunning() {
+		return fmt.Errorf("daemon is not running")
+	}
+	if err := client.Require(ipc.CapEvents); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	events, err := client.Subscribe(ctx, &ipc.Request{Session: watchSession})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for ev := range events {
+		if watchJSON {
+			if err := enc.Encode(ev); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Println(formatEvent(ev))
+	}
+	if ctx.Err() == nil {
+		return fmt.Errorf("daemon closed the event stream")
+	}
+	return nil
+}
+
+// formatEvent renders ev as one line for a terminal.
+func formatEvent(ev ipc.Event) string {
+	where := ev.Session
+	if where == "" {
+		where = ev.Transport + ":" + ev.Chat
+	}
+	line := func(s string) string {
+		return strings.ReplaceAll(s, "\n", " ")
+	}
+
+	var what string
+	switch ev.Type {
+	case session.EventAsked:
+		what = fmt.Sprintf("asked #%s: %s", ev.QuestionID, line(ev.Question))
+	case session.EventAnswered:
+		what = fmt.Sprintf("answered #%s via %s: %s", ev.QuestionID, ev.Source, line(ev.Text))
+	case session.EventExpired, session.EventCancelled:
+		what = fmt.Sprintf("%s #%s: %s", ev.Type, ev.QuestionID, line(ev.Question))
+	case session.EventQueued:
+		what = "queued: " + line(ev.Text)
+	case session.EventCommand:
+		what = fmt.Sprintf("command from %s: %s", ev.Source, line(ev.Text))
+	case session.EventConnection:
+		where = ev.Transport
+		what = ev.State
+		if ev.Text != "" {
+			what += ": " + line(ev.Text)
+		}
+	default:
+		what = ev.Type
+	}
+	return fmt.Sprintf("%s %s %s", ev.Time.Local().Format(time.TimeOnly), where, what)
+}
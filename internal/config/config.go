@@ -4,35 +4,198 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/joho/godotenv"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/spf13/viper"
+
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
 )
 
 type Config struct {
-	Telegram TelegramConfig  `mapstructure:"telegram"`
-	Timeout  int             `mapstructure:"timeout"`
-	Sessions []SessionConfig `mapstructure:"sessions"`
+	Telegram  TelegramConfig  `mapstructure:"telegram" yaml:"telegram"`
+	Matrix    MatrixConfig    `mapstructure:"matrix" yaml:"matrix,omitempty"`
+	Slack     SlackConfig     `mapstructure:"slack" yaml:"slack,omitempty"`
+	Webhook   WebhookConfig   `mapstructure:"webhook" yaml:"webhook,omitempty"`
+	Dashboard DashboardConfig `mapstructure:"dashboard" yaml:"dashboard,omitempty"`
+	Remote    RemoteConfig    `mapstructure:"remote" yaml:"remote,omitempty"`
+	Socket    SocketConfig    `mapstructure:"socket" yaml:"socket,omitempty"`
+	Timeout   int             `mapstructure:"timeout" yaml:"timeout"`
+	Sessions  []SessionConfig `mapstructure:"sessions" yaml:"sessions"`
+
+	path string
 }
 
 type TelegramConfig struct {
-	BotToken     string  `mapstructure:"bot_token"`
-	AllowedUsers []int64 `mapstructure:"allowed_users"`
+	BotToken     string  `mapstructure:"bot_token" yaml:"bot_token,omitempty"`
+	TokenFile    string  `mapstructure:"token_file" yaml:"token_file,omitempty"`
+	TokenCommand string  `mapstructure:"token_command" yaml:"token_command,omitempty"`
+	AllowedUsers []int64 `mapstructure:"allowed_users" yaml:"allowed_users"`
+	// APIEndpoint is the Bot API base URL, for a self-hosted Bot API server
+	// or a local stub. Defaults to https://api.telegram.org.
+	APIEndpoint string `mapstructure:"api_endpoint" yaml:"api_endpoint,omitempty"`
+	// Proxy is an http://, https:// or socks5:// URL for Bot API requests.
+	Proxy string `mapstructure:"proxy" yaml:"proxy,omitempty"`
+	// RequestTimeout is the per-request timeout in seconds. getUpdates gets
+	// the long-poll duration on top.
+	RequestTimeout int    `mapstructure:"request_timeout" yaml:"request_timeout,omitempty"`
+	OnConflict     string `mapstructure:"on_conflict" yaml:"on_conflict,omitempty"`
+	// DiscardBacklogMinutes drops incoming messages older than this many
+	// minutes, e.g. ones that piled up while the daemon was down. 0 keeps
+	// everything Telegram still has.
+	DiscardBacklogMinutes int `mapstructure:"discard_backlog_minutes" yaml:"discard_backlog_minutes,omitempty"`
+}
+
+// MatrixConfig configures the Matrix transport, for sessions with
+// transport: matrix.
+type MatrixConfig struct {
+	Homeserver  string `mapstructure:"homeserver" yaml:"homeserver"`
+	AccessToken string `mapstructure:"access_token" yaml:"access_token,omitempty"`
+	// AllowedUsers are the MXIDs, like @alice:example.org, whose messages
+	// count as answers.
+	AllowedUsers []string `mapstructure:"allowed_users" yaml:"allowed_users"`
+	// Proxy and RequestTimeout work as they do for Telegram; /sync long
+	// polls get their own 30 seconds on top.
+	Proxy          string `mapstructure:"proxy" yaml:"proxy,omitempty"`
+	RequestTimeout int    `mapstructure:"request_timeout" yaml:"request_timeout,omitempty"`
+}
+
+// SlackConfig configures the Slack transport, for sessions with
+// transport: slack.
+type SlackConfig struct {
+	BotToken string `mapstructure:"bot_token" yaml:"bot_token,omitempty"`
+	// SigningSecret verifies that requests to the events endpoint come from
+	// Slack.
+	SigningSecret string `mapstructure:"signing_secret" yaml:"signing_secret,omitempty"`
+	// Listen is the address of the HTTP endpoint Slack delivers events and
+	// button presses to. Defaults to 127.0.0.1:8377.
+	Listen string `mapstructure:"listen" yaml:"listen,omitempty"`
+	// APIEndpoint is the Web API base URL. Defaults to https://slack.com/api.
+	APIEndpoint string `mapstructure:"api_endpoint" yaml:"api_endpoint,omitempty"`
+	// AllowedUsers are the Slack user IDs, like U0123ABCD, whose messages
+	// count as answers.
+	AllowedUsers []string `mapstructure:"allowed_users" yaml:"allowed_users"`
+}
+
+// WebhookConfig configures the webhook transport, for sessions with
+// transport: webhook.
+type WebhookConfig struct {
+	// URL receives every question as a signed JSON POST.
+	URL string `mapstructure:"url" yaml:"url"`
+	// Secret keys the HMAC signatures on deliveries and answer callbacks.
+	Secret string `mapstructure:"secret" yaml:"secret,omitempty"`
+	// Listen is the address of the answer callback endpoint. Defaults to
+	// 127.0.0.1:8378.
+	Listen string `mapstructure:"listen" yaml:"listen,omitempty"`
+	// CallbackURL is the base URL answers are posted to, for when the
+	// endpoint is reached through a proxy. Defaults to http://<listen>.
+	CallbackURL string `mapstructure:"callback_url" yaml:"callback_url,omitempty"`
+	// MaxAttempts is how often a delivery is tried before it is written to
+	// the dead-letter file. Defaults to 5.
+	MaxAttempts int `mapstructure:"max_attempts" yaml:"max_attempts,omitempty"`
+	// DeadLetterFile collects failed deliveries as JSON lines. Defaults to
+	// webhook-dead-letter.jsonl in the config directory.
+	DeadLetterFile string `mapstructure:"dead_letter_file" yaml:"dead_letter_file,omitempty"`
+}
+
+// DashboardConfig configures the web dashboard served by the daemon.
+type DashboardConfig struct {
+	// Listen is the dashboard's address, such as 127.0.0.1:8380. The
+	// dashboard is off unless it is set.
+	Listen string `mapstructure:"listen" yaml:"listen,omitempty"`
+	// Token must be presented to use the dashboard. If empty, a random one
+	// is generated at startup and its login link written to
+	// DashboardTokenPath.
+	Token string `mapstructure:"token" yaml:"token,omitempty"`
+}
+
+// RemoteConfig configures a TCP listener for clients that can't reach the
+// Unix socket, such as agents in containers or on other machines.
+type RemoteConfig struct {
+	// Listen is tcp://host:port for newline-delimited JSON, as on the
+	// socket, or https://host:port for a REST API. Off unless set.
+	Listen string `mapstructure:"listen" yaml:"listen,omitempty"`
+	// Tokens are the bearer tokens clients may present.
+	Tokens []string `mapstructure:"tokens" yaml:"tokens,omitempty"`
+	// TLSCert and TLSKey are the server certificate for https.
+	TLSCert string `mapstructure:"tls_cert" yaml:"tls_cert,omitempty"`
+	TLSKey  string `mapstructure:"tls_key" yaml:"tls_key,omitempty"`
+	// ClientCA enables mTLS for https: clients presenting a certificate
+	// signed by it need no token.
+	ClientCA string `mapstructure:"client_ca" yaml:"client_ca,omitempty"`
+}
+
+// SocketConfig restricts who may use the Unix socket beyond its file
+// permissions, using the peer credentials of each connection (Linux only).
+// A peer matching any rule is allowed; with no rules, anyone who can open
+// the socket is.
+type SocketConfig struct {
+	AllowedUIDs []uint32 `mapstructure:"allowed_uids" yaml:"allowed_uids,omitempty"`
+	// AllowedGIDs match the peer's primary or supplementary groups.
+	AllowedGIDs []uint32 `mapstructure:"allowed_gids" yaml:"allowed_gids,omitempty"`
+	// AllowedExecutables are absolute paths of programs allowed to connect.
+	// They only match peers whose /proc/<pid>/exe the daemon can read.  A
+	// process can hand its connection to another, so this is a hint about
+	// the caller, weaker than the UID and GID rules.
+	AllowedExecutables []string `mapstructure:"allowed_executables" yaml:"allowed_executables,omitempty"`
+}
+
+// Restricted reports whether any allow rule is set.
+func (s *SocketConfig) Restricted() bool {
+	return len(s.AllowedUIDs) > 0 || len(s.AllowedGIDs) > 0 || len(s.AllowedExecutables) > 0
 }
 
 type SessionConfig struct {
-	Name       string `mapstructure:"name"`
-	ChatID     int64  `mapstructure:"chat_id"`
-	WorkingDir string `mapstructure:"working_dir"`
+	Name string `mapstructure:"name" yaml:"name"`
+	// Transport is the messenger the session talks through. Defaults to
+	// telegram.
+	Transport string `mapstructure:"transport" yaml:"transport,omitempty"`
+	ChatID    int64  `mapstructure:"chat_id" yaml:"chat_id"`
+	// Room is the Matrix room ID for matrix sessions.
+	Room string `mapstructure:"room" yaml:"room,omitempty"`
+	// Channel is the Slack channel ID for slack sessions.
+	Channel    string `mapstructure:"channel" yaml:"channel,omitempty"`
+	WorkingDir string `mapstructure:"working_dir" yaml:"working_dir"`
+	Timeout    int    `mapstructure:"timeout" yaml:"timeout,omitempty"`
+	Fallback   string `mapstructure:"fallback" yaml:"fallback,omitempty"`
+	Format     string `mapstructure:"format" yaml:"format,omitempty"`
+
+	// key is the name the session had when loaded, used by Save to find
+	// its node in the file after a rename.
+	key string
 }
 
+// Transports lists the values sessions[].transport accepts.
+var Transports = []string{transport.Telegram, transport.Matrix, transport.Slack, transport.Webhook, transport.Console}
+
 const (
 	DefaultTimeout    = 300
 	DefaultConfigDir  = ".config/cctg"
 	DefaultConfigFile = "config.yaml"
 	DefaultEnvFile    = ".env"
+	DefaultTokenFile  = "token"
 	DefaultSocketFile = "cctg.sock"
+	DefaultStateFile  = "state.json"
+
+	DefaultDeadLetterFile     = "webhook-dead-letter.jsonl"
+	DefaultDashboardTokenFile = "dashboard-token"
+)
+
+const (
+	FallbackContinue = "continue"
+	FallbackFail     = "fail"
+
+	ConflictRetry = "retry"
+	ConflictExit  = "exit"
+
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+
+	// DefaultFallbackMessage is printed when no reply arrives and the
+	// fallback policy is "continue".
+	DefaultFallbackMessage = "user didn't reply go ahead with caution, don't make huge refactor, check what you are doing"
 )
 
 func Load(configPath string) (*Config, error) {
@@ -55,6 +218,11 @@ func Load(configPath string) (*Config, error) {
 
 	v.AutomaticEnv()
 	v.BindEnv("telegram.bot_token", "TELEGRAM_BOT_TOKEN")
+	v.BindEnv("matrix.access_token", "MATRIX_ACCESS_TOKEN")
+	v.BindEnv("slack.bot_token", "SLACK_BOT_TOKEN")
+	v.BindEnv("slack.signing_secret", "SLACK_SIGNING_SECRET")
+	v.BindEnv("webhook.secret", "CCTG_WEBHOOK_SECRET")
+	v.BindEnv("dashboard.token", "CCTG_DASHBOARD_TOKEN")
 
 	if err := v.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
@@ -67,9 +235,9 @@ func Load(configPath string) (*Config, error) {
 	if err := v.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("unmarshaling config: %w", err)
 	}
-
-	if cfg.Telegram.BotToken == "" {
-		return nil, fmt.Errorf("telegram.bot_token is required")
+	cfg.path = v.ConfigFileUsed()
+	for i := range cfg.Sessions {
+		cfg.Sessions[i].key = cfg.Sessions[i].Name
 	}
 
 	return &cfg, nil
@@ -83,6 +251,20 @@ func loadEnvFile() error {
 	return nil
 }
 
+// getenv looks key up in the environment, then in the env file. Unlike
+// loadEnvFile it leaves the environment alone, so checking a config has no
+// side effects.
+func getenv(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	vars, err := godotenv.Read(filepath.Join(getConfigDir(), DefaultEnvFile))
+	if err != nil {
+		return ""
+	}
+	return vars[key]
+}
+
 func getConfigDir() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -91,49 +273,52 @@ func getConfigDir() string {
 	return filepath.Join(home, DefaultConfigDir)
 }
 
+func GetConfigDir() string {
+	return getConfigDir()
+}
+
 func GetSocketPath() string {
 	return filepath.Join(getConfigDir(), DefaultSocketFile)
 }
 
-func GetConfigPath() string {
-	return filepath.Join(getConfigDir(), DefaultConfigFile)
+func GetStatePath() string {
+	return filepath.Join(getConfigDir(), DefaultStateFile)
 }
 
-func (c *Config) Save(path string) error {
-	if path == "" {
-		path = GetConfigPath()
-	}
-
-	var usersYaml string
-	for _, u := range c.Telegram.AllowedUsers {
-		usersYaml += fmt.Sprintf("    - %d\n", u)
-	}
+// DashboardTokenPath returns the file holding the login link for a
+// generated dashboard token.
+func DashboardTokenPath() string {
+	return filepath.Join(getConfigDir(), DefaultDashboardTokenFile)
+}
 
-	var sessionsYaml string
-	for _, s := range c.Sessions {
-		sessionsYaml += fmt.Sprintf(`  - name: "%s"
-    chat_id: %d
-    working_dir: "%s"
-`, s.Name, s.ChatID, s.WorkingDir)
+// DeadLetterPath returns the webhook dead-letter file.
+func (w *WebhookConfig) DeadLetterPath() string {
+	if w.DeadLetterFile != "" {
+		return expandHome(w.DeadLetterFile)
 	}
+	return filepath.Join(getConfigDir(), DefaultDeadLetterFile)
+}
 
-	content := fmt.Sprintf(`telegram:
-  allowed_users:
-%s
-timeout: %d
-
-sessions:
-%s`, usersYaml, c.Timeout, sessionsYaml)
+func GetConfigPath() string {
+	return filepath.Join(getConfigDir(), DefaultConfigFile)
+}
 
-	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
-		return fmt.Errorf("creating config directory: %w", err)
+// ResolvePath returns the config file Load would read for configPath.
+func ResolvePath(configPath string) string {
+	if configPath != "" {
+		return configPath
 	}
-
-	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
-		return fmt.Errorf("writing config: %w", err)
+	if _, err := os.Stat(DefaultConfigFile); err == nil {
+		if abs, err := filepath.Abs(DefaultConfigFile); err == nil {
+			return abs
+		}
 	}
+	return GetConfigPath()
+}
 
-	return nil
+// Path returns the file the config was loaded from.
+func (c *Config) Path() string {
+	return c.path
 }
 
 func (c *Config) FindSessionByName(name string) *SessionConfig {
@@ -153,3 +338,33 @@ func (c *Config) FindSessionByWorkDir(workDir string) *SessionConfig {
 	}
 	return nil
 }
+
+// TransportName returns the session's transport, defaulting to telegram.
+func (s *SessionConfig) TransportName() string {
+	if s.Transport == "" {
+		return transport.Telegram
+	}
+	return s.Transport
+}
+
+// Address returns the chat the session's questions go to.
+func (s *SessionConfig) Address() transport.Address {
+	switch s.TransportName() {
+	case transport.Console:
+		return transport.Address{Transport: transport.Console, Chat: transport.ConsoleChat}
+	case transport.Matrix:
+		return transport.Address{Transport: transport.Matrix, Chat: s.Room}
+	case transport.Slack:
+		return transport.Address{Transport: transport.Slack, Chat: s.Channel}
+	case transport.Webhook:
+		return transport.Address{Transport: transport.Webhook, Chat: transport.WebhookChat}
+	}
+	return transport.Address{Transport: s.TransportName(), Chat: strconv.FormatInt(s.ChatID, 10)}
+}
+
+// UseTransport switches every session to the named transport.
+func (c *Config) UseTransport(name string) {
+	for i := range c.Sessions {
+		c.Sessions[i].Transport = name
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+// Diff describes what changed between two configs, one line per change.
+func Diff(old, new *Config) []string {
+	var changes []string
+
+	if old.Telegram.BotToken != new.Telegram.BotToken ||
+		old.Telegram.TokenFile != new.Telegram.TokenFile ||
+		old.Telegram.TokenCommand != new.Telegram.TokenCommand {
+		changes = append(changes, "telegram bot token source changed (restart to apply)")
+	}
+	if old.Telegram.APIEndpoint != new.Telegram.APIEndpoint {
+		changes = append(changes, fmt.Sprintf("telegram.api_endpoint: %q -> %q (restart to apply)", old.Telegram.APIEndpoint, new.Telegram.APIEndpoint))
+	}
+	if !slices.Equal(old.Telegram.AllowedUsers, new.Telegram.AllowedUsers) {
+		changes = append(changes, fmt.Sprintf("telegram.allowed_users: %v -> %v", old.Telegram.AllowedUsers, new.Telegram.AllowedUsers))
+	}
+	if old.Telegram.Proxy != new.Telegram.Proxy || old.Telegram.RequestTimeout != new.Telegram.RequestTimeout {
+		changes = append(changes, "telegram.proxy or telegram.request_timeout changed (restart to apply)")
+	}
+	if old.Matrix.Homeserver != new.Matrix.Homeserver || old.Matrix.AccessToken != new.Matrix.AccessToken {
+		changes = append(changes, "matrix.homeserver or matrix.access_token changed (restart to apply)")
+	}
+	if old.Matrix.Proxy != new.Matrix.Proxy || old.Matrix.RequestTimeout != new.Matrix.RequestTimeout {
+		changes = append(changes, "matrix.proxy or matrix.request_timeout changed (restart to apply)")
+	}
+	if !slices.Equal(old.Matrix.AllowedUsers, new.Matrix.AllowedUsers) {
+		changes = append(changes, fmt.Sprintf("matrix.allowed_users: %v -> %v", old.Matrix.AllowedUsers, new.Matrix.AllowedUsers))
+	}
+	if old.Slack.BotToken != new.Slack.BotToken || old.Slack.SigningSecret != new.Slack.SigningSecret || old.Slack.Listen != new.Slack.Listen || old.Slack.APIEndpoint != new.Slack.APIEndpoint {
+		changes = append(changes, "slack.bot_token, signing_secret, listen or api_endpoint changed (restart to apply)")
+	}
+	if !slices.Equal(old.Slack.AllowedUsers, new.Slack.AllowedUsers) {
+		changes = append(changes, fmt.Sprintf("slack.allowed_users: %v -> %v", old.Slack.AllowedUsers, new.Slack.AllowedUsers))
+	}
+	if old.Webhook != new.Webhook {
+		changes = append(changes, "webhook settings changed (restart to apply)")
+	}
+	if old.Remote.Listen != new.Remote.Listen || old.Remote.TLSCert != new.Remote.TLSCert || old.Remote.TLSKey != new.Remote.TLSKey ||
+		old.Remote.ClientCA != new.Remote.ClientCA || !slices.Equal(old.Remote.Tokens, new.Remote.Tokens) {
+		changes = append(changes, "remote settings changed (restart to apply)")
+	}
+	if !slices.Equal(old.Socket.AllowedUIDs, new.Socket.AllowedUIDs) || !slices.Equal(old.Socket.AllowedGIDs, new.Socket.AllowedGIDs) ||
+		!slices.Equal(old.Socket.AllowedExecutables, new.Socket.AllowedExecutables) {
+		changes = append(changes, "socket allow rules changed (restart to apply)")
+	}
+	if old.Dashboard != new.Dashboard {
+		changes = append(changes, "dashboard settings changed (restart to apply)")
+	}
+	if old.Telegram.OnConflict != new.Telegram.OnConflict {
+		changes = append(changes, fmt.Sprintf("telegram.on_conflict: %q -> %q", old.Telegram.OnConflict, new.Telegram.OnConflict))
+	}
+	if old.Telegram.DiscardBacklogMinutes != new.Telegram.DiscardBacklogMinutes {
+		changes = append(changes, fmt.Sprintf("telegram.discard_backlog_minutes: %d -> %d", old.Telegram.DiscardBacklogMinutes, new.Telegram.DiscardBacklogMinutes))
+	}
+	if old.Timeout != new.Timeout {
+		changes = append(changes, fmt.Sprintf("timeout: %d -> %d", old.Timeout, new.Timeout))
+	}
+
+	// Matrix joins the session rooms when it starts.
+	var joined []string
+	for _, s := range old.Sessions {
+		if s.TransportName() == transport.Matrix {
+			joined = append(joined, s.Room)
+		}
+	}
+	for _, s := range new.Sessions {
+		if s.TransportName() == transport.Matrix && !slices.Contains(joined, s.Room) {
+			changes = append(changes, fmt.Sprintf("session %q: matrix room %s is not joined yet (restart to apply)", s.Name, s.Room))
+		}
+	}
+
+	for _, s := range old.Sessions {
+		if new.FindSessionByName(s.Name) == nil {
+			changes = append(changes, fmt.Sprintf("session %q removed", s.Name))
+		}
+	}
+	for _, s := range new.Sessions {
+		prev := old.FindSessionByName(s.Name)
+		if prev == nil {
+			changes = append(changes, fmt.Sprintf("session %q added (%s)", s.Name, s.Address()))
+			continue
+		}
+		if prev.TransportName() != s.TransportName() {
+			changes = append(changes, fmt.Sprintf("session %q transport: %s -> %s", s.Name, prev.TransportName(), s.TransportName()))
+		}
+		if prev.ChatID != s.ChatID {
+			changes = append(changes, fmt.Sprintf("session %q chat_id: %d -> %d", s.Name, prev.ChatID, s.ChatID))
+		}
+		if prev.Room != s.Room {
+			changes = append(changes, fmt.Sprintf("session %q room: %s -> %s", s.Name, prev.Room, s.Room))
+		}
+		if prev.Channel != s.Channel {
+			changes = append(changes, fmt.Sprintf("session %q channel: %s -> %s", s.Name, prev.Channel, s.Channel))
+		}
+		if prev.WorkingDir != s.WorkingDir {
+			changes = append(changes, fmt.Sprintf("session %q working_dir: %s -> %s", s.Name, prev.WorkingDir, s.WorkingDir))
+		}
+		if prev.Timeout != s.Timeout {
+			changes = append(changes, fmt.Sprintf("session %q timeout: %d -> %d", s.Name, prev.Timeout, s.Timeout))
+		}
+		if prev.Fallback != s.Fallback {
+			changes = append(changes, fmt.Sprintf("session %q fallback: %q -> %q", s.Name, prev.Fallback, s.Fallback))
+		}
+		if prev.Format != s.Format {
+			changes = append(changes, fmt.Sprintf("session %q format: %q -> %q", s.Name, prev.Format, s.Format))
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+func TestDiff(t *testing.T) {
+	api := SessionConfig{Name: "api", ChatID: 100}
+	ops := SessionConfig{Name: "ops", Transport: transport.Matrix, Room: "!ops:example.org"}
+
+	tests := []struct {
+		name     string
+		old, new []SessionConfig
+		want     []string
+	}{
+		{
+			name: "no changes",
+			old:  []SessionConfig{api, ops},
+			new:  []SessionConfig{api, ops},
+		},
+		{
+			name: "session added",
+			old:  []SessionConfig{ops},
+			new:  []SessionConfig{ops, api},
+			want: []string{`session "api" added (telegram:100)`},
+		},
+		{
+			name: "session removed",
+			old:  []SessionConfig{api, ops},
+			new:  []SessionConfig{api},
+			want: []string{`session "ops" removed`},
+		},
+		{
+			name: "matrix session in a new room",
+			old:  []SessionConfig{api},
+			new:  []SessionConfig{api, ops},
+			want: []string{
+				`session "ops": matrix room !ops:example.org is not joined yet (restart to apply)`,
+				`session "ops" added (matrix:!ops:example.org)`,
+			},
+		},
+		{
+			name: "matrix session in a joined room",
+			old:  []SessionConfig{ops},
+			new:  []SessionConfig{ops, {Name: "ops2", Transport: transport.Matrix, Room: ops.Room}},
+			want: []string{`session "ops2" added (matrix:!ops:example.org)`},
+		},
+		{
+			name: "matrix room changed",
+			old:  []SessionConfig{ops},
+			new:  []SessionConfig{{Name: "ops", Transport: transport.Matrix, Room: "!new:example.org"}},
+			want: []string{
+				`session "ops": matrix room !new:example.org is not joined yet (restart to apply)`,
+				`session "ops" room: !ops:example.org -> !new:example.org`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(&Config{Sessions: tt.old}, &Config{Sessions: tt.new})
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Diff =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
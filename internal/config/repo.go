@@ -0,0 +1,138 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// RepoConfigFile is the per-repository config file discovered by walking up
+// from the working directory of a send request.
+const RepoConfigFile = ".cctg.yaml"
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// RepoConfig selects or overrides a session for a single repository. The bot
+// token, allowed users and chats always come from the user's private config,
+// so a repository can only pick among the configured sessions.
+type RepoConfig struct {
+	Path     string `mapstructure:"-" yaml:"-"`
+	Session  string `mapstructure:"session" yaml:"session,omitempty"`
+	Timeout  int    `mapstructure:"timeout" yaml:"timeout,omitempty"`
+	Fallback string `mapstructure:"fallback" yaml:"fallback,omitempty"`
+	Format   string `mapstructure:"format" yaml:"format,omitempty"`
+}
+
+// FindRepoConfig walks up from workDir looking for a .cctg.yaml, stopping at
+// the git root or the filesystem root. It returns nil if none is found.
+func FindRepoConfig(workDir string) (*RepoConfig, error) {
+	dir, err := filepath.Abs(workDir)
+	if err != nil {
+		return nil, fmt.Errorf("resolving working directory: %w", err)
+	}
+
+	for {
+		path := filepath.Join(dir, RepoConfigFile)
+		if _, err := os.Stat(path); err == nil {
+			return LoadRepoConfig(path)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return nil, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+func LoadRepoConfig(path string) (*RepoConfig, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	v.SetConfigFile(path)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var repo RepoConfig
+	if err := v.Unmarshal(&repo); err != nil {
+		return nil, fmt.Errorf("unmarshaling %s: %w", path, err)
+	}
+	repo.Path = path
+
+	return &repo, nil
+}
+
+// Dir returns the directory containing the repo config file.
+func (r *RepoConfig) Dir() string {
+	return filepath.Dir(r.Path)
+}
+
+func (r *RepoConfig) apply(s *SessionConfig) {
+	if r.Timeout > 0 {
+		s.Timeout = r.Timeout
+	}
+	if r.Fallback != "" {
+		s.Fallback = r.Fallback
+	}
+	if r.Format != "" {
+		s.Format = r.Format
+	}
+}
+
+// ResolveSession picks the session for a request and merges in any
+// .cctg.yaml found above workDir. An explicit name wins over the repo file,
+// which wins over matching on working_dir. The returned session is a copy
+// with defaults filled in.
+func (c *Config) ResolveSession(name, workDir string) (*SessionConfig, error) {
+	var repo *RepoConfig
+	if workDir != "" {
+		var err error
+		repo, err = FindRepoConfig(workDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if name == "" && repo != nil {
+		name = repo.Session
+	}
+
+	var base *SessionConfig
+	if name != "" {
+		base = c.FindSessionByName(name)
+	} else if workDir != "" {
+		base = c.FindSessionByWorkDir(workDir)
+		if base == nil && repo != nil {
+			base = c.FindSessionByWorkDir(repo.Dir())
+		}
+	}
+
+	if base == nil {
+		return nil, ErrSessionNotFound
+	}
+
+	resolved := *base
+	if repo != nil && (repo.Session == "" || repo.Session == resolved.Name) {
+		repo.apply(&resolved)
+	}
+
+	if resolved.Timeout <= 0 {
+		resolved.Timeout = c.Timeout
+	}
+	if resolved.Fallback == "" {
+		resolved.Fallback = FallbackContinue
+	}
+	if resolved.Format == "" {
+		resolved.Format = FormatPlain
+	}
+
+	return &resolved, nil
+}
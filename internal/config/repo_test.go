@@ -0,0 +1,135 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoConfigCannotChooseChat(t *testing.T) {
+	repo := t.TempDir()
+	writeFile(t, filepath.Join(repo, RepoConfigFile), "session: elsewhere\nchat_id: 666\n")
+	cfg := &Config{Timeout: 60, Sessions: []SessionConfig{{Name: "api", ChatID: 1, WorkingDir: repo}}}
+
+	if _, err := cfg.ResolveSession("", repo); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("ResolveSession for an unconfigured repo session = %v, want ErrSessionNotFound", err)
+	}
+
+	writeFile(t, filepath.Join(repo, RepoConfigFile), "session: api\nchat_id: 666\n")
+	sess, err := cfg.ResolveSession("", repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.ChatID != 1 {
+		t.Fatalf("chat = %d, want the configured 1", sess.ChatID)
+	}
+	if diags := ValidateRepoFile(filepath.Join(repo, RepoConfigFile)); !diags.HasErrors() {
+		t.Fatalf("validating a repo file with chat_id = %v, want an error", diags)
+	}
+}
+
+func TestFindRepoConfig(t *testing.T) {
+	// root/
+	//   .cctg.yaml          session: outer
+	//   plain/deep/
+	//   repo/.git
+	//   repo/src/
+	//   nested/.cctg.yaml   session: nested
+	//   nested/.git
+	//   nested/a/b/
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, RepoConfigFile), "session: outer\n")
+	writeFile(t, filepath.Join(root, "plain", "deep", "file"), "")
+	writeFile(t, filepath.Join(root, "repo", ".git", "HEAD"), "")
+	writeFile(t, filepath.Join(root, "repo", "src", "file"), "")
+	writeFile(t, filepath.Join(root, "nested", RepoConfigFile), "session: nested\ntimeout: 90\n")
+	writeFile(t, filepath.Join(root, "nested", ".git"), "gitdir: elsewhere\n")
+	writeFile(t, filepath.Join(root, "nested", "a", "b", "file"), "")
+
+	tests := []struct {
+		name    string
+		workDir string
+		want    string
+	}{
+		{"in the directory", root, "outer"},
+		{"walks up", filepath.Join(root, "plain", "deep"), "outer"},
+		{"stops at the git root", filepath.Join(root, "repo", "src"), ""},
+		{"git root itself", filepath.Join(root, "repo"), ""},
+		{"found at the git root", filepath.Join(root, "nested", "a", "b"), "nested"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, err := FindRepoConfig(tt.workDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if repo != nil {
+				got = repo.Session
+			}
+			if got != tt.want {
+				t.Fatalf("found session %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSession(t *testing.T) {
+	root := t.TempDir()
+	api := filepath.Join(root, "api")
+	web := filepath.Join(root, "web")
+	writeFile(t, filepath.Join(api, ".git", "HEAD"), "")
+	writeFile(t, filepath.Join(api, RepoConfigFile), "timeout: 90\nformat: markdown\n")
+	writeFile(t, filepath.Join(web, ".git", "HEAD"), "")
+	writeFile(t, filepath.Join(web, RepoConfigFile), "session: api\nfallback: fail\n")
+
+	cfg := &Config{Timeout: 60, Sessions: []SessionConfig{
+		{Name: "api", ChatID: 1, WorkingDir: api},
+		{Name: "web", ChatID: 2, WorkingDir: web, Timeout: 30},
+	}}
+
+	tests := []struct {
+		name     string
+		session  string
+		workDir  string
+		want     string
+		timeout  int
+		fallback string
+		format   string
+	}{
+		{"by working_dir with repo overrides", "", filepath.Join(api, "cmd"), "api", 90, FallbackContinue, FormatMarkdown},
+		{"repo file picks the session", "", web, "api", 60, FallbackFail, FormatPlain},
+		{"explicit name wins over the repo file", "web", web, "web", 30, FallbackContinue, FormatPlain},
+		{"by name only", "api", "", "api", 60, FallbackContinue, FormatPlain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, err := cfg.ResolveSession(tt.session, tt.workDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sess.Name != tt.want || sess.Timeout != tt.timeout || sess.Fallback != tt.fallback || sess.Format != tt.format {
+				t.Fatalf("resolved %s timeout=%d fallback=%s format=%s, want %s %d %s %s",
+					sess.Name, sess.Timeout, sess.Fallback, sess.Format, tt.want, tt.timeout, tt.fallback, tt.format)
+			}
+		})
+	}
+
+	if _, err := cfg.ResolveSession("", root); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("ResolveSession outside any session = %v, want ErrSessionNotFound", err)
+	}
+	if cfg.Sessions[0].Timeout != 0 {
+		t.Fatal("ResolveSession modified the configured session")
+	}
+}
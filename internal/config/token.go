@@ -0,0 +1,116 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CredentialName is the systemd credential cctg reads the bot token from
+// when run with LoadCredential= or LoadCredentialEncrypted=.
+const CredentialName = "telegram_bot_token"
+
+const tokenCommandTimeout = 30 * time.Second
+
+var ErrNoToken = errors.New("telegram bot token is not set (use telegram.bot_token, TELEGRAM_BOT_TOKEN, telegram.token_file, telegram.token_command or a systemd credential)")
+
+// Token returns the bot token from the first source that is configured:
+// bot_token (or TELEGRAM_BOT_TOKEN), token_file, token_command, then the
+// systemd credential in $CREDENTIALS_DIRECTORY. It is resolved on demand so
+// that commands which don't talk to Telegram never run token_command.
+func (t *TelegramConfig) Token() (string, error) {
+	switch {
+	case t.BotToken != "":
+		return t.BotToken, nil
+	case t.TokenFile != "":
+		data, err := os.ReadFile(expandHome(t.TokenFile))
+		if err != nil {
+			return "", fmt.Errorf("reading token_file: %w", err)
+		}
+		return nonEmpty(string(data), "token_file")
+	case t.TokenCommand != "":
+		return runTokenCommand(t.TokenCommand)
+	}
+
+	if path := credentialPath(); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading systemd credential: %w", err)
+		}
+		return nonEmpty(string(data), "systemd credential "+CredentialName)
+	}
+
+	return "", ErrNoToken
+}
+
+// TokenSource names where Token would read the token from, or "" if no
+// source is configured.
+func (t *TelegramConfig) TokenSource() string {
+	switch {
+	case t.BotToken != "":
+		return "bot_token"
+	case t.TokenFile != "":
+		return "token_file"
+	case t.TokenCommand != "":
+		return "token_command"
+	case credentialPath() != "":
+		return "systemd credential"
+	}
+	return ""
+}
+
+func runTokenCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenCommandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("running token_command: %w: %s", err, msg)
+		}
+		return "", fmt.Errorf("running token_command: %w", err)
+	}
+
+	// Tools like pass print extra lines after the secret.
+	first, _, _ := strings.Cut(stdout.String(), "\n")
+	return nonEmpty(first, "token_command")
+}
+
+func credentialPath() string {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return ""
+	}
+	path := filepath.Join(dir, CredentialName)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+func nonEmpty(token, source string) (string, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", source)
+	}
+	return token, nil
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestToken(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	writeFile(t, tokenFile, "from-file\n")
+	emptyFile := filepath.Join(dir, "empty")
+	writeFile(t, emptyFile, "\n")
+	credentials := filepath.Join(dir, "credentials")
+	writeFile(t, filepath.Join(credentials, CredentialName), "from-credential\n")
+
+	tests := []struct {
+		name        string
+		tg          TelegramConfig
+		credentials string
+		want        string
+		source      string
+		err         string
+	}{
+		{
+			name:        "bot_token first",
+			tg:          TelegramConfig{BotToken: "inline", TokenFile: tokenFile, TokenCommand: "echo from-command"},
+			credentials: credentials,
+			want:        "inline",
+			source:      "bot_token",
+		},
+		{
+			name:        "then token_file",
+			tg:          TelegramConfig{TokenFile: tokenFile, TokenCommand: "echo from-command"},
+			credentials: credentials,
+			want:        "from-file",
+			source:      "token_file",
+		},
+		{
+			name:        "then token_command",
+			tg:          TelegramConfig{TokenCommand: "echo from-command; echo second line"},
+			credentials: credentials,
+			want:        "from-command",
+			source:      "token_command",
+		},
+		{
+			name:        "then the systemd credential",
+			credentials: credentials,
+			want:        "from-credential",
+			source:      "systemd credential",
+		},
+		{
+			name:   "token_file under home",
+			tg:     TelegramConfig{TokenFile: "~/token"},
+			want:   "from-file",
+			source: "token_file",
+		},
+		{
+			name:   "empty token_file",
+			tg:     TelegramConfig{TokenFile: emptyFile},
+			source: "token_file",
+			err:    "token_file is empty",
+		},
+		{
+			name:   "missing token_file",
+			tg:     TelegramConfig{TokenFile: filepath.Join(dir, "missing")},
+			source: "token_file",
+			err:    "reading token_file",
+		},
+		{
+			name:   "failing token_command",
+			tg:     TelegramConfig{TokenCommand: "echo locked >&2; exit 1"},
+			source: "token_command",
+			err:    "running token_command: exit status 1: locked",
+		},
+		{
+			name:        "credential directory without the credential",
+			credentials: dir,
+			err:         ErrNoToken.Error(),
+		},
+		{
+			name: "nothing configured",
+			err:  ErrNoToken.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", dir)
+			t.Setenv("CREDENTIALS_DIRECTORY", tt.credentials)
+
+			if got := tt.tg.TokenSource(); got != tt.source {
+				t.Errorf("TokenSource() = %q, want %q", got, tt.source)
+			}
+			token, err := tt.tg.Token()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Token() = %q, %v; want error containing %q", token, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.want {
+				t.Fatalf("Token() = %q, want %q", token, tt.want)
+			}
+		})
+	}
+}
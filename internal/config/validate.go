@@ -0,0 +1,792 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a single validation finding. Line and Column are zero when
+// the problem isn't tied to a position in the file.
+type Diagnostic struct {
+	Severity Severity
+	File     string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	if d.Line > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.File, d.Severity, d.Message)
+}
+
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (ds Diagnostics) Count(sev Severity) int {
+	n := 0
+	for _, d := range ds {
+		if d.Severity == sev {
+			n++
+		}
+	}
+	return n
+}
+
+// Err summarizes the errors in ds, or returns nil if there are none.
+func (ds Diagnostics) Err() error {
+	var msgs []string
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			msgs = append(msgs, d.String())
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config:\n  %s", strings.Join(msgs, "\n  "))
+}
+
+var (
+	rootKeys      = []string{"telegram", "matrix", "slack", "webhook", "dashboard", "remote", "socket", "timeout", "sessions"}
+	telegramKeys  = []string{"bot_token", "token_file", "token_command", "allowed_users", "api_endpoint", "proxy", "request_timeout", "on_conflict", "discard_backlog_minutes"}
+	matrixKeys    = []string{"homeserver", "access_token", "allowed_users", "proxy", "request_timeout"}
+	slackKeys     = []string{"bot_token", "signing_secret", "listen", "api_endpoint", "allowed_users"}
+	webhookKeys   = []string{"url", "secret", "listen", "callback_url", "max_attempts", "dead_letter_file"}
+	dashboardKeys = []string{"listen", "token"}
+	remoteKeys    = []string{"listen", "tokens", "tls_cert", "tls_key", "client_ca"}
+	socketKeys    = []string{"allowed_uids", "allowed_gids", "allowed_executables"}
+	sessionKeys   = []string{"name", "transport", "chat_id", "room", "channel", "working_dir", "timeout", "fallback", "format"}
+	repoKeys      = []string{"session", "timeout", "fallback", "format"}
+)
+
+type validator struct {
+	file  string
+	diags Diagnostics
+	// transport, if set, overrides every session's transport.
+	transport string
+}
+
+func (v *validator) add(sev Severity, n *yaml.Node, format string, args ...any) {
+	d := Diagnostic{Severity: sev, File: v.file, Message: fmt.Sprintf(format, args...)}
+	if n != nil {
+		d.Line, d.Column = n.Line, n.Column
+	}
+	v.diags = append(v.diags, d)
+}
+
+func (v *validator) errorf(n *yaml.Node, format string, args ...any) {
+	v.add(SeverityError, n, format, args...)
+}
+
+func (v *validator) warnf(n *yaml.Node, format string, args ...any) {
+	v.add(SeverityWarning, n, format, args...)
+}
+
+// Validate checks the file the config was loaded from.
+func (c *Config) Validate() Diagnostics {
+	return ValidateFile(c.path)
+}
+
+// ValidateAs checks the file the config was loaded from as if every session
+// used the named transport, as "cctg serve --console" runs it.
+func (c *Config) ValidateAs(transport string) Diagnostics {
+	v := &validator{file: c.path, transport: transport}
+	v.validate()
+	return v.diags
+}
+
+// ValidateFile checks a config file for mistakes that would otherwise load
+// quietly and misbehave later: unknown keys, duplicate sessions, missing chat
+// IDs, nobody allowed to reply, bad timeouts and so on.
+func ValidateFile(path string) Diagnostics {
+	v := &validator{file: path}
+	v.validate()
+	return v.diags
+}
+
+func (v *validator) validate() {
+	root, ok := v.parse(v.file)
+	if !ok {
+		return
+	}
+
+	v.checkKeys(root, "", rootKeys)
+
+	key, sessions := entry(root, "sessions")
+
+	// A transport's section only matters if a session uses it. With no
+	// sessions yet Telegram is still needed to create one.
+	used := make(map[string]bool)
+	if sessions != nil && sessions.Kind == yaml.SequenceNode && len(sessions.Content) > 0 {
+		for _, item := range sessions.Content {
+			used[v.sessionTransport(item)] = true
+		}
+	} else if v.transport != "" {
+		used[v.transport] = true
+	} else {
+		used[transport.Telegram] = true
+	}
+
+	tgKey, tg := v.section(root, "telegram", used[transport.Telegram])
+	v.checkTelegram(tgKey, tg, used[transport.Telegram])
+
+	mxKey, mx := v.section(root, "matrix", used[transport.Matrix])
+	v.checkMatrix(mxKey, mx, used[transport.Matrix])
+
+	slackKey, slack := v.section(root, "slack", used[transport.Slack])
+	v.checkSlack(slackKey, slack, used[transport.Slack])
+
+	hookKey, hook := v.section(root, "webhook", used[transport.Webhook])
+	v.checkWebhook(hookKey, hook, used[transport.Webhook])
+
+	if _, dash := v.section(root, "dashboard", false); dash != nil {
+		v.checkDashboard(dash)
+	}
+	if key, remote := v.section(root, "remote", false); remote != nil {
+		v.checkRemote(key, remote)
+	}
+	if key, sock := v.section(root, "socket", false); sock != nil {
+		v.checkSocket(key, sock)
+	}
+
+	if key, n := entry(root, "timeout"); n != nil {
+		v.checkTimeout(key, n, "timeout")
+	}
+
+	switch {
+	case sessions == nil || (sessions.Kind == yaml.ScalarNode && sessions.Tag == "!!null"):
+		v.warnf(key, "no sessions configured")
+	case sessions.Kind != yaml.SequenceNode:
+		v.errorf(key, "sessions must be a list")
+	default:
+		v.checkSessions(sessions)
+	}
+}
+
+// section returns a top-level mapping, reporting it if it is missing but
+// required or isn't a mapping.
+func (v *validator) section(root *yaml.Node, name string, required bool) (*yaml.Node, *yaml.Node) {
+	key, n := entry(root, name)
+	if n == nil {
+		if required {
+			v.errorf(nil, "%s section is missing", name)
+		}
+		return key, nil
+	}
+	if n.Kind != yaml.MappingNode {
+		v.errorf(key, "%s must be a mapping", name)
+		return key, nil
+	}
+	return key, n
+}
+
+// sessionTransport returns the transport a session node will run with.
+func (v *validator) sessionTransport(item *yaml.Node) string {
+	if v.transport != "" {
+		return v.transport
+	}
+	if _, n := entry(item, "transport"); n != nil && n.Value != "" {
+		return n.Value
+	}
+	return transport.Telegram
+}
+
+// ValidateRepoFile checks a per-repository .cctg.yaml.
+func ValidateRepoFile(path string) Diagnostics {
+	v := &validator{file: path}
+
+	root, ok := v.parse(path)
+	if !ok {
+		return v.diags
+	}
+
+	v.checkKeys(root, "", repoKeys)
+	// Where questions go is the private config's decision: a cloned
+	// repository mustn't redirect them to another chat.
+	for _, key := range []string{"telegram", "bot_token", "allowed_users", "chat_id"} {
+		if k, _ := entry(root, key); k != nil {
+			v.errorf(k, "%s belongs in the private config, not %s", key, RepoConfigFile)
+		}
+	}
+	v.checkSessionOptions(root)
+
+	return v.diags
+}
+
+func (v *validator) parse(path string) (*yaml.Node, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		v.errorf(nil, "%v", err)
+		return nil, false
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		v.errorf(nil, "%v", err)
+		return nil, false
+	}
+	if doc.Kind == 0 || len(doc.Content) == 0 {
+		v.errorf(nil, "file is empty")
+		return nil, false
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		v.errorf(root, "top level must be a mapping")
+		return nil, false
+	}
+	return root, true
+}
+
+// checkTelegram checks the telegram section. Unless required, a missing
+// token or empty allow list is fine.
+func (v *validator) checkTelegram(key, tg *yaml.Node, required bool) {
+	if tg != nil {
+		v.checkKeys(tg, "telegram.", telegramKeys)
+	}
+
+	sources := 0
+	if getenv("TELEGRAM_BOT_TOKEN") != "" || credentialPath() != "" {
+		sources++
+	}
+	if _, n := entry(tg, "bot_token"); n != nil && n.Value != "" {
+		sources++
+	}
+	if _, n := entry(tg, "token_command"); n != nil && n.Value != "" {
+		sources++
+	}
+	if k, n := entry(tg, "token_file"); n != nil && n.Value != "" {
+		sources++
+		if info, err := os.Stat(expandHome(n.Value)); err != nil {
+			v.errorf(k, "token_file: %v", err)
+		} else if info.Mode().Perm()&0077 != 0 {
+			v.warnf(k, "token_file %s is readable by other users (mode %04o); chmod 600 it", n.Value, info.Mode().Perm())
+		}
+	}
+	if sources == 0 && required {
+		v.errorf(key, "no bot token: set telegram.bot_token, TELEGRAM_BOT_TOKEN, telegram.token_file, telegram.token_command or the %s systemd credential", CredentialName)
+	}
+
+	if k, n := entry(tg, "on_conflict"); n != nil {
+		switch n.Value {
+		case ConflictRetry, ConflictExit:
+		default:
+			v.errorf(k, "telegram.on_conflict must be %q or %q, got %q", ConflictRetry, ConflictExit, n.Value)
+		}
+	}
+
+	if k, n := entry(tg, "api_endpoint"); n != nil && n.Value != "" {
+		if u, err := url.Parse(n.Value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.errorf(k, "telegram.api_endpoint must be an http(s) URL, got %q", n.Value)
+		}
+	}
+
+	v.checkNetwork(tg, "telegram.")
+
+	if k, n := entry(tg, "discard_backlog_minutes"); n != nil {
+		if m, ok := v.int(n, "discard_backlog_minutes"); ok && m < 0 {
+			v.errorf(k, "telegram.discard_backlog_minutes must not be negative")
+		}
+	}
+
+	usersKey, users := entry(tg, "allowed_users")
+	if users == nil || users.Kind != yaml.SequenceNode || len(users.Content) == 0 {
+		if !required {
+			return
+		}
+		if usersKey == nil {
+			usersKey = key
+		}
+		v.errorf(usersKey, "telegram.allowed_users is empty; nobody will be able to reply")
+		return
+	}
+
+	seen := make(map[int64]bool)
+	for _, n := range users.Content {
+		id, ok := v.int(n, "allowed user")
+		if !ok {
+			continue
+		}
+		if id <= 0 {
+			v.errorf(n, "allowed user ID %d is not a valid Telegram user ID", id)
+		}
+		if seen[id] {
+			v.warnf(n, "allowed user %d is listed twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+// checkMatrix checks the matrix section. Unless required, missing settings
+// are fine.
+func (v *validator) checkMatrix(key, mx *yaml.Node, required bool) {
+	if mx != nil {
+		v.checkKeys(mx, "matrix.", matrixKeys)
+	}
+
+	k, n := entry(mx, "homeserver")
+	switch {
+	case n == nil || n.Value == "":
+		if required {
+			v.errorf(key, "matrix.homeserver is not set")
+		}
+	default:
+		if u, err := url.Parse(n.Value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.errorf(k, "matrix.homeserver must be an http(s) URL, got %q", n.Value)
+		}
+	}
+
+	v.checkNetwork(mx, "matrix.")
+
+	if _, n := entry(mx, "access_token"); (n == nil || n.Value == "") && getenv("MATRIX_ACCESS_TOKEN") == "" && required {
+		v.errorf(key, "no matrix access token: set matrix.access_token or MATRIX_ACCESS_TOKEN")
+	}
+
+	usersKey, users := entry(mx, "allowed_users")
+	if users == nil || users.Kind != yaml.SequenceNode || len(users.Content) == 0 {
+		if required {
+			if usersKey == nil {
+				usersKey = key
+			}
+			v.errorf(usersKey, "matrix.allowed_users is empty; nobody will be able to reply")
+		}
+		return
+	}
+	for _, n := range users.Content {
+		if !isMXID(n.Value) {
+			v.errorf(n, "matrix allowed user %q is not a user ID like @alice:example.org", n.Value)
+		}
+	}
+}
+
+// checkNetwork checks the proxy and request_timeout of a transport section.
+func (v *validator) checkNetwork(m *yaml.Node, prefix string) {
+	if k, n := entry(m, "proxy"); n != nil && n.Value != "" {
+		u, err := url.Parse(n.Value)
+		if err != nil || u.Host == "" || !slices.Contains([]string{"http", "https", "socks5", "socks5h"}, u.Scheme) {
+			v.errorf(k, "%sproxy must be an http://, https:// or socks5:// URL, got %q", prefix, n.Value)
+		}
+	}
+	if k, n := entry(m, "request_timeout"); n != nil {
+		v.checkTimeout(k, n, prefix+"request_timeout")
+	}
+}
+
+func isMXID(s string) bool {
+	local, server, ok := strings.Cut(strings.TrimPrefix(s, "@"), ":")
+	return strings.HasPrefix(s, "@") && ok && local != "" && server != ""
+}
+
+// checkSlack checks the slack section. Unless required, missing settings
+// are fine.
+func (v *validator) checkSlack(key, slack *yaml.Node, required bool) {
+	if slack != nil {
+		v.checkKeys(slack, "slack.", slackKeys)
+	}
+
+	if _, n := entry(slack, "bot_token"); (n == nil || n.Value == "") && getenv("SLACK_BOT_TOKEN") == "" && required {
+		v.errorf(key, "no slack bot token: set slack.bot_token or SLACK_BOT_TOKEN")
+	}
+	if _, n := entry(slack, "signing_secret"); (n == nil || n.Value == "") && getenv("SLACK_SIGNING_SECRET") == "" && required {
+		v.errorf(key, "no slack signing secret: set slack.signing_secret or SLACK_SIGNING_SECRET")
+	}
+
+	if k, n := entry(slack, "listen"); n != nil && n.Value != "" {
+		if _, _, err := net.SplitHostPort(n.Value); err != nil {
+			v.errorf(k, "slack.listen must be host:port, got %q", n.Value)
+		}
+	}
+	if k, n := entry(slack, "api_endpoint"); n != nil && n.Value != "" {
+		if u, err := url.Parse(n.Value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.errorf(k, "slack.api_endpoint must be an http(s) URL, got %q", n.Value)
+		}
+	}
+
+	usersKey, users := entry(slack, "allowed_users")
+	if users == nil || users.Kind != yaml.SequenceNode || len(users.Content) == 0 {
+		if required {
+			if usersKey == nil {
+				usersKey = key
+			}
+			v.errorf(usersKey, "slack.allowed_users is empty; nobody will be able to reply")
+		}
+		return
+	}
+	for _, n := range users.Content {
+		if !isSlackID(n.Value, "UW") {
+			v.errorf(n, "slack allowed user %q is not a user ID like U0123ABCD", n.Value)
+		}
+	}
+}
+
+// checkWebhook checks the webhook section. Unless required, missing settings
+// are fine.
+func (v *validator) checkWebhook(key, hook *yaml.Node, required bool) {
+	if hook != nil {
+		v.checkKeys(hook, "webhook.", webhookKeys)
+	}
+
+	k, n := entry(hook, "url")
+	switch {
+	case n == nil || n.Value == "":
+		if required {
+			v.errorf(key, "webhook.url is not set")
+		}
+	case !isHTTPURL(n.Value):
+		v.errorf(k, "webhook.url must be an http(s) URL, got %q", n.Value)
+	}
+
+	k, n = entry(hook, "secret")
+	switch {
+	case (n == nil || n.Value == "") && getenv("CCTG_WEBHOOK_SECRET") == "":
+		if required {
+			v.errorf(key, "no webhook secret: set webhook.secret or CCTG_WEBHOOK_SECRET")
+		}
+	case n != nil && n.Value != "" && len(n.Value) < 16:
+		v.warnf(k, "webhook.secret is shorter than 16 characters")
+	}
+
+	if k, n := entry(hook, "listen"); n != nil && n.Value != "" {
+		if _, _, err := net.SplitHostPort(n.Value); err != nil {
+			v.errorf(k, "webhook.listen must be host:port, got %q", n.Value)
+		}
+	}
+	if k, n := entry(hook, "callback_url"); n != nil && n.Value != "" && !isHTTPURL(n.Value) {
+		v.errorf(k, "webhook.callback_url must be an http(s) URL, got %q", n.Value)
+	}
+	if k, n := entry(hook, "max_attempts"); n != nil {
+		if attempts, ok := v.int(n, "webhook.max_attempts"); ok && attempts < 1 {
+			v.errorf(k, "webhook.max_attempts must be at least 1, got %d", attempts)
+		}
+	}
+}
+
+// checkDashboard checks the dashboard section. Listening beyond loopback is
+// allowed, but the token then travels in clear text.
+func (v *validator) checkDashboard(dash *yaml.Node) {
+	v.checkKeys(dash, "dashboard.", dashboardKeys)
+
+	if k, n := entry(dash, "listen"); n != nil && n.Value != "" {
+		host, _, err := net.SplitHostPort(n.Value)
+		if err != nil {
+			v.errorf(k, "dashboard.listen must be host:port, got %q", n.Value)
+		} else if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			v.warnf(k, "dashboard.listen %q is not a loopback address; the dashboard has no TLS", n.Value)
+		}
+	}
+	if k, n := entry(dash, "token"); n != nil && n.Value != "" && len(n.Value) < 16 {
+		v.warnf(k, "dashboard.token is shorter than 16 characters")
+	}
+}
+
+// checkRemote checks the remote listener section.
+func (v *validator) checkRemote(key, remote *yaml.Node) {
+	v.checkKeys(remote, "remote.", remoteKeys)
+
+	k, n := entry(remote, "listen")
+	if n == nil || n.Value == "" {
+		return
+	}
+	u, err := url.Parse(n.Value)
+	if err != nil || (u.Scheme != "tcp" && u.Scheme != "https") || u.Port() == "" {
+		v.errorf(k, "remote.listen must be tcp://host:port or https://host:port, got %q", n.Value)
+		return
+	}
+
+	tokens := 0
+	if _, n := entry(remote, "tokens"); n != nil {
+		if n.Kind != yaml.SequenceNode {
+			v.errorf(n, "remote.tokens must be a list")
+		} else {
+			for _, t := range n.Content {
+				if len(t.Value) < 16 {
+					v.warnf(t, "remote token is shorter than 16 characters")
+				}
+			}
+			tokens = len(n.Content)
+		}
+	}
+	_, ca := entry(remote, "client_ca")
+	hasCA := ca != nil && ca.Value != ""
+
+	switch u.Scheme {
+	case "tcp":
+		if hasCA {
+			v.errorf(ca, "remote.client_ca needs an https:// listener")
+		}
+		if tokens == 0 {
+			v.errorf(key, "remote.tokens is empty: a tcp:// listener needs at least one token")
+		}
+		if ip := net.ParseIP(u.Hostname()); u.Hostname() != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			v.warnf(k, "remote.listen %q is not a loopback address and tcp:// is unencrypted; consider https://", n.Value)
+		}
+	case "https":
+		for _, name := range []string{"tls_cert", "tls_key"} {
+			if _, n := entry(remote, name); n == nil || n.Value == "" {
+				v.errorf(key, "remote.%s is required for an https:// listener", name)
+			}
+		}
+		if tokens == 0 && !hasCA {
+			v.errorf(key, "remote listener has no way to authenticate clients: set remote.tokens or remote.client_ca")
+		}
+	}
+}
+
+// checkSocket checks the socket allow rules.
+func (v *validator) checkSocket(key, sock *yaml.Node) {
+	v.checkKeys(sock, "socket.", socketKeys)
+
+	rules := 0
+	for _, name := range []string{"allowed_uids", "allowed_gids"} {
+		_, n := entry(sock, name)
+		if n == nil {
+			continue
+		}
+		if n.Kind != yaml.SequenceNode {
+			v.errorf(n, "socket.%s must be a list", name)
+			continue
+		}
+		for _, id := range n.Content {
+			if i, ok := v.int(id, "socket."+name+" entry"); ok && (i < 0 || i > math.MaxUint32) {
+				v.errorf(id, "socket.%s entry %d is out of range", name, i)
+			}
+		}
+		rules += len(n.Content)
+	}
+	if _, n := entry(sock, "allowed_executables"); n != nil {
+		if n.Kind != yaml.SequenceNode {
+			v.errorf(n, "socket.allowed_executables must be a list")
+		} else {
+			for _, exe := range n.Content {
+				if !filepath.IsAbs(exe.Value) {
+					v.errorf(exe, "socket.allowed_executables entry %q must be an absolute path", exe.Value)
+				}
+			}
+			rules += len(n.Content)
+		}
+	}
+
+	if rules > 0 && runtime.GOOS != "linux" {
+		v.errorf(key, "socket allow rules need peer credentials, which are only supported on Linux")
+	}
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isSlackID reports whether s looks like a Slack ID starting with one of
+// prefixes, e.g. U0123ABCD for a user.
+func isSlackID(s, prefixes string) bool {
+	if len(s) < 2 || !strings.ContainsRune(prefixes, rune(s[0])) {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *validator) checkSessions(seq *yaml.Node) {
+	names := make(map[string]*yaml.Node)
+	dirs := make(map[string]string)
+
+	for _, item := range seq.Content {
+		if item.Kind != yaml.MappingNode {
+			v.errorf(item, "session must be a mapping")
+			continue
+		}
+		v.checkKeys(item, "sessions[].", sessionKeys)
+
+		nameKey, nameNode := entry(item, "name")
+		name := ""
+		if nameNode != nil {
+			name = nameNode.Value
+		}
+		if name == "" {
+			v.errorf(item, "session has no name")
+		} else if first, dup := names[name]; dup {
+			v.errorf(nameKey, "duplicate session name %q (first defined on line %d)", name, first.Line)
+		} else {
+			names[name] = nameKey
+		}
+
+		if key, n := entry(item, "transport"); n != nil && !slices.Contains(Transports, n.Value) {
+			v.errorf(key, "session %q: unknown transport %q (known: %s)", name, n.Value, strings.Join(Transports, ", "))
+		}
+
+		if v.sessionTransport(item) == transport.Matrix {
+			roomKey, room := entry(item, "room")
+			if room == nil || room.Value == "" {
+				v.errorf(item, "session %q uses matrix but has no room", name)
+			} else if !strings.HasPrefix(room.Value, "!") || !strings.Contains(room.Value, ":") {
+				v.errorf(roomKey, "session %q: room must be a room ID like !abc123:example.org, got %q", name, room.Value)
+			}
+		}
+
+		if v.sessionTransport(item) == transport.Slack {
+			channelKey, channel := entry(item, "channel")
+			if channel == nil || channel.Value == "" {
+				v.errorf(item, "session %q uses slack but has no channel", name)
+			} else if !isSlackID(channel.Value, "CGD") {
+				v.errorf(channelKey, "session %q: channel must be a channel ID like C0123ABCD, got %q", name, channel.Value)
+			}
+		}
+
+		chatKey, chat := entry(item, "chat_id")
+		if v.sessionTransport(item) != transport.Telegram {
+			if chat != nil {
+				v.int(chat, "chat_id")
+			}
+		} else if chat == nil {
+			v.errorf(item, "session %q has no chat_id", name)
+		} else if id, ok := v.int(chat, "chat_id"); ok && id == 0 {
+			v.errorf(chatKey, "session %q has chat_id 0", name)
+		}
+
+		if dirKey, dir := entry(item, "working_dir"); dir != nil && dir.Value != "" {
+			clean := filepath.Clean(dir.Value)
+			if other, dup := dirs[clean]; dup {
+				v.warnf(dirKey, "sessions %q and %q share working_dir %s; auto-detection always picks %q", other, name, dir.Value, other)
+			} else {
+				dirs[clean] = name
+			}
+			if !filepath.IsAbs(dir.Value) {
+				v.warnf(dir, "working_dir %s is not absolute and will never match", dir.Value)
+			} else if _, err := os.Stat(dir.Value); err != nil {
+				v.warnf(dir, "working_dir %s does not exist", dir.Value)
+			}
+		}
+
+		v.checkSessionOptions(item)
+	}
+}
+
+func (v *validator) checkSessionOptions(m *yaml.Node) {
+	if key, n := entry(m, "timeout"); n != nil {
+		v.checkTimeout(key, n, "timeout")
+	}
+	if key, n := entry(m, "fallback"); n != nil {
+		switch n.Value {
+		case FallbackContinue, FallbackFail:
+		default:
+			v.errorf(key, "fallback must be %q or %q, got %q", FallbackContinue, FallbackFail, n.Value)
+		}
+	}
+	if key, n := entry(m, "format"); n != nil {
+		switch n.Value {
+		case FormatPlain, FormatMarkdown, FormatHTML:
+		default:
+			v.errorf(key, "format must be %q, %q or %q, got %q", FormatPlain, FormatMarkdown, FormatHTML, n.Value)
+		}
+	}
+}
+
+func (v *validator) checkTimeout(key, n *yaml.Node, name string) {
+	t, ok := v.int(n, name)
+	if !ok {
+		return
+	}
+	if t <= 0 {
+		v.errorf(key, "%s must be a positive number of seconds, got %d", name, t)
+	}
+}
+
+func (v *validator) int(n *yaml.Node, name string) (int64, bool) {
+	i, err := strconv.ParseInt(n.Value, 0, 64)
+	if n.Kind != yaml.ScalarNode || err != nil {
+		v.errorf(n, "%s must be an integer, got %q", name, n.Value)
+		return 0, false
+	}
+	return i, true
+}
+
+func (v *validator) checkKeys(m *yaml.Node, prefix string, known []string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key := m.Content[i]
+		if slices.Contains(known, key.Value) {
+			continue
+		}
+		if s := suggest(key.Value, known); s != "" {
+			v.warnf(key, "unknown key %s%s (did you mean %q?)", prefix, key.Value, s)
+		} else {
+			v.warnf(key, "unknown key %s%s", prefix, key.Value)
+		}
+	}
+}
+
+// entry returns the key and value nodes for key in mapping m.
+func entry(m *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if m == nil {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i], m.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+func suggest(key string, known []string) string {
+	best, bestDist := "", 3
+	for _, k := range known {
+		if d := editDistance(key, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
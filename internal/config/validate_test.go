@@ -0,0 +1,261 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// want is a diagnostic a config must produce: its severity, line and part of
+// its message.
+type want struct {
+	sev  Severity
+	line int
+	msg  string
+}
+
+func TestValidateFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []want
+	}{
+		{
+			name: "valid",
+			config: `telegram:
+  bot_token: "123:abc"
+  allowed_users: [42]
+sessions:
+  - name: api
+    chat_id: 100
+    working_dir: /
+`,
+		},
+		{
+			name: "misspelled root key",
+			config: `telegram:
+  bot_token: "123:abc"
+  allowed_users: [42]
+timout: 60
+sessions: []
+`,
+			want: []want{{SeverityWarning, 4, `unknown key timout (did you mean "timeout"?)`}},
+		},
+		{
+			name: "misspelled nested key",
+			config: `telegram:
+  bot_token: "123:abc"
+  alowed_users: [42]
+sessions: []
+`,
+			want: []want{
+				{SeverityWarning, 3, `unknown key telegram.alowed_users (did you mean "allowed_users"?)`},
+				{SeverityError, 1, "telegram.allowed_users is empty"},
+			},
+		},
+		{
+			name: "misspelled session key",
+			config: `telegram:
+  bot_token: "123:abc"
+  allowed_users: [42]
+sessions:
+  - name: api
+    chat_id: 100
+    workng_dir: /
+`,
+			want: []want{{SeverityWarning, 7, `unknown key sessions[].workng_dir (did you mean "working_dir"?)`}},
+		},
+		{
+			name: "unknown key without suggestion",
+			config: `telegram:
+  bot_token: "123:abc"
+  allowed_users: [42]
+colour: blue
+sessions: []
+`,
+			want: []want{{SeverityWarning, 4, "unknown key colour"}},
+		},
+		{
+			name: "duplicate session",
+			config: `telegram:
+  bot_token: "123:abc"
+  allowed_users: [42]
+sessions:
+  - name: api
+    chat_id: 100
+  - name: api
+    chat_id: 200
+`,
+			want: []want{{SeverityError, 7, `duplicate session name "api" (first defined on line 5)`}},
+		},
+		{
+			name: "missing and zero chat IDs",
+			config: `telegram:
+  bot_token: "123:abc"
+  allowed_users: [42]
+sessions:
+  - name: api
+  - name: web
+    chat_id: 0
+`,
+			want: []want{
+				{SeverityError, 5, `session "api" has no chat_id`},
+				{SeverityError, 7, `session "web" has chat_id 0`},
+			},
+		},
+		{
+			name: "bad options",
+			config: `telegram:
+  bot_token: "123:abc"
+  allowed_users: [42, 42]
+timeout: -5
+sessions:
+  - name: api
+    chat_id: 100
+    fallback: panic
+    format: rtf
+`,
+			want: []want{
+				{SeverityWarning, 3, "allowed user 42 is listed twice"},
+				{SeverityError, 4, "timeout must be a positive number of seconds, got -5"},
+				{SeverityError, 8, `fallback must be "continue" or "fail", got "panic"`},
+				{SeverityError, 9, `format must be "plain", "markdown" or "html", got "rtf"`},
+			},
+		},
+		{
+			name: "shared working directory",
+			config: `telegram:
+  bot_token: "123:abc"
+  allowed_users: [42]
+sessions:
+  - name: api
+    chat_id: 100
+    working_dir: /
+  - name: web
+    chat_id: 200
+    working_dir: /
+`,
+			want: []want{{SeverityWarning, 10, `sessions "api" and "web" share working_dir /`}},
+		},
+		{
+			name: "matrix network settings",
+			config: `matrix:
+  homeserver: https://matrix.example.org
+  access_token: syt_abc
+  allowed_users: ["@alice:example.org"]
+  proxy: ftp://proxy.example.org
+  request_timeout: 0
+sessions:
+  - name: api
+    transport: matrix
+    room: "!abc:example.org"
+`,
+			want: []want{
+				{SeverityError, 5, `matrix.proxy must be an http://, https:// or socks5:// URL, got "ftp://proxy.example.org"`},
+				{SeverityError, 6, "matrix.request_timeout must be a positive number of seconds"},
+			},
+		},
+		{
+			name:   "not a mapping",
+			config: "- just\n- a list\n",
+			want:   []want{{SeverityError, 1, ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("TELEGRAM_BOT_TOKEN", "")
+			t.Setenv("CREDENTIALS_DIRECTORY", "")
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			writeFile(t, path, tt.config)
+
+			diags := ValidateFile(path)
+			if len(diags) != len(tt.want) {
+				t.Fatalf("got %d diagnostics, want %d:\n%v", len(diags), len(tt.want), diags)
+			}
+			for _, w := range tt.want {
+				if !hasDiagnostic(diags, w) {
+					t.Errorf("missing %s on line %d containing %q in:\n%v", w.sev, w.line, w.msg, diags)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateReadsEnvFileWithoutLoadingIt(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("TELEGRAM_BOT_TOKEN", "")
+	os.Unsetenv("TELEGRAM_BOT_TOKEN")
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	writeFile(t, filepath.Join(home, DefaultConfigDir, DefaultEnvFile), "TELEGRAM_BOT_TOKEN=123:from-env-file\n")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, `telegram:
+  allowed_users: [42]
+sessions:
+  - name: api
+    chat_id: 100
+    working_dir: /
+`)
+
+	if diags := ValidateFile(path); len(diags) != 0 {
+		t.Fatalf("the token in the env file was not found: %v", diags)
+	}
+	if token, ok := os.LookupEnv("TELEGRAM_BOT_TOKEN"); ok {
+		t.Fatalf("validating set TELEGRAM_BOT_TOKEN=%q", token)
+	}
+}
+
+func hasDiagnostic(diags Diagnostics, w want) bool {
+	for _, d := range diags {
+		if d.Severity == w.sev && d.Line == w.line && strings.Contains(d.Message, w.msg) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSuggest(t *testing.T) {
+	known := []string{"timeout", "sessions", "allowed_users", "working_dir"}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"timout", "timeout"},
+		{"timeuot", "timeout"},
+		{"session", "sessions"},
+		{"alowed_users", "allowed_users"},
+		{"allowed-users", "allowed_users"},
+		{"working_dirs", "working_dir"},
+		{"workdir", ""},
+		{"colour", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := suggest(tt.key, known); got != tt.want {
+			t.Errorf("suggest(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"timeout", "timeout", 0},
+		{"timout", "timeout", 1},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
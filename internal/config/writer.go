@@ -0,0 +1,259 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Save writes the config back to disk through a YAML node round-trip so that
+// comments, key order and fields cctg doesn't know about survive. Only the
+// fields cctg manages are touched; bot_token itself is never written. The write
+// is atomic and the previous file is kept as <path>.bak.
+func (c *Config) Save(path string) error {
+	if path == "" {
+		path = c.path
+	}
+	if path == "" {
+		path = GetConfigPath()
+	}
+
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	previous, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	var doc yaml.Node
+	if len(previous) > 0 {
+		if err := yaml.Unmarshal(previous, &doc); err != nil {
+			return fmt.Errorf("parsing config: %w", err)
+		}
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config root is not a mapping")
+	}
+	c.encodeInto(root)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return fmt.Errorf("encoding config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("encoding config: %w", err)
+	}
+
+	if err := writeFileAtomic(path, buf.Bytes(), previous); err != nil {
+		return err
+	}
+	c.path = path
+	for i := range c.Sessions {
+		c.Sessions[i].key = c.Sessions[i].Name
+	}
+
+	return nil
+}
+
+func (c *Config) encodeInto(root *yaml.Node) {
+	tg := mappingValue(root, "telegram")
+	setOptionalString(tg, "token_file", c.Telegram.TokenFile)
+	setOptionalString(tg, "token_command", c.Telegram.TokenCommand)
+	setOptionalString(tg, "api_endpoint", c.Telegram.APIEndpoint)
+	setOptionalString(tg, "proxy", c.Telegram.Proxy)
+	setIntSequence(tg, "allowed_users", c.Telegram.AllowedUsers)
+
+	setScalar(root, "timeout", intNode(c.Timeout))
+
+	seq := mappingValue(root, "sessions")
+	if seq.Kind != yaml.SequenceNode {
+		*seq = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	}
+
+	existing := make(map[string][]*yaml.Node)
+	for _, item := range seq.Content {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		if name := lookup(item, "name"); name != nil {
+			existing[name.Value] = append(existing[name.Value], item)
+		}
+	}
+
+	content := make([]*yaml.Node, 0, len(c.Sessions))
+	for _, s := range c.Sessions {
+		var item *yaml.Node
+		if s.key != "" && len(existing[s.key]) > 0 {
+			item = existing[s.key][0]
+			existing[s.key] = existing[s.key][1:]
+		} else {
+			item = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		encodeSession(item, s)
+		content = append(content, item)
+	}
+	seq.Content = content
+}
+
+func encodeSession(item *yaml.Node, s SessionConfig) {
+	setScalar(item, "name", stringNode(s.Name))
+	setOptionalString(item, "transport", s.Transport)
+	setScalar(item, "chat_id", int64Node(s.ChatID))
+	setOptionalString(item, "room", s.Room)
+	setOptionalString(item, "channel", s.Channel)
+	setScalar(item, "working_dir", stringNode(s.WorkingDir))
+
+	if s.Timeout > 0 {
+		setScalar(item, "timeout", intNode(s.Timeout))
+	} else {
+		deleteKey(item, "timeout")
+	}
+	setOptionalString(item, "fallback", s.Fallback)
+	setOptionalString(item, "format", s.Format)
+}
+
+func lookup(m *yaml.Node, key string) *yaml.Node {
+	_, v := entry(m, key)
+	return v
+}
+
+// mappingValue returns the value node for key, appending an empty mapping if
+// the key is missing.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if v := lookup(m, key); v != nil {
+		if v.Kind == yaml.ScalarNode && v.Tag == "!!null" {
+			*v = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		return v
+	}
+	v := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+	return v
+}
+
+// setScalar replaces the value for key, keeping the existing node's style and
+// comments when the kind and tag are unchanged.
+func setScalar(m *yaml.Node, key string, value *yaml.Node) {
+	if v := lookup(m, key); v != nil {
+		if v.Kind == yaml.ScalarNode && v.Tag == value.Tag {
+			v.Value = value.Value
+			return
+		}
+		value.HeadComment, value.LineComment, value.FootComment = v.HeadComment, v.LineComment, v.FootComment
+		*v = *value
+		return
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// setOptionalString sets key to value, or removes it if value is empty.
+func setOptionalString(m *yaml.Node, key, value string) {
+	if value == "" {
+		deleteKey(m, key)
+		return
+	}
+	setScalar(m, key, stringNode(value))
+}
+
+func setIntSequence(m *yaml.Node, key string, values []int64) {
+	seq := lookup(m, key)
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		seq = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		setScalar(m, key, seq)
+		seq = lookup(m, key)
+	}
+
+	existing := make(map[string]*yaml.Node)
+	for _, item := range seq.Content {
+		existing[item.Value] = item
+	}
+
+	content := make([]*yaml.Node, 0, len(values))
+	for _, v := range values {
+		item, ok := existing[strconv.FormatInt(v, 10)]
+		if !ok {
+			item = int64Node(v)
+		}
+		content = append(content, item)
+	}
+	seq.Content = content
+}
+
+func deleteKey(m *yaml.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+func stringNode(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s, Style: yaml.DoubleQuotedStyle}
+}
+
+func intNode(i int) *yaml.Node {
+	return int64Node(int64(i))
+}
+
+func int64Node(i int64) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(i, 10)}
+}
+
+// writeFileAtomic writes data to a temp file in the same directory and
+// renames it over path, keeping previous as path.bak.
+func writeFileAtomic(path string, data, previous []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+
+	if previous != nil {
+		if err := os.WriteFile(path+".bak", previous, 0600); err != nil {
+			return fmt.Errorf("writing backup: %w", err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("setting config permissions: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing config: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing config: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing config: %w", err)
+	}
+	return nil
+}
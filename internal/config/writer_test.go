@@ -0,0 +1,214 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const savedConfig = `# cctg config
+telegram:
+  bot_token: "keep-me" # set by hand
+  allowed_users: [42]
+  future_option: true
+
+timeout: 300 # five minutes
+
+sessions:
+  # the main project
+  - name: "api"
+    chat_id: 100
+    working_dir: "/src/api"
+    notes: "unknown to cctg"
+  - name: "web"
+    chat_id: 200
+    working_dir: "/src/web"
+
+plugins:
+  - name: something-else
+`
+
+func TestSaveRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(*Config)
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "unchanged",
+			edit: func(*Config) {},
+			want: []string{
+				"# cctg config",
+				`bot_token: "keep-me" # set by hand`,
+				"future_option: true",
+				"timeout: 300 # five minutes",
+				"# the main project",
+				`notes: "unknown to cctg"`,
+				"plugins:\n  - name: something-else",
+			},
+		},
+		{
+			name: "add session",
+			edit: func(c *Config) {
+				c.Sessions = append(c.Sessions, SessionConfig{Name: "docs", ChatID: 300, WorkingDir: "/src/docs"})
+			},
+			want: []string{"# the main project", `name: "docs"`, "chat_id: 300", `notes: "unknown to cctg"`},
+		},
+		{
+			name: "remove session",
+			edit: func(c *Config) { c.Sessions = c.Sessions[1:] },
+			want: []string{`name: "web"`, "plugins:"},
+			// The comment belongs to the removed item.
+			notWant: []string{`name: "api"`, "notes:", "# the main project"},
+		},
+		{
+			name: "rename keeps unknown keys",
+			edit: func(c *Config) { c.Sessions[0].Name = "backend" },
+			want: []string{`name: "backend"`, `notes: "unknown to cctg"`},
+		},
+		{
+			name: "change timeout keeps comment",
+			edit: func(c *Config) { c.Timeout = 60 },
+			want: []string{"timeout: 60 # five minutes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			writeFile(t, path, savedConfig)
+
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.edit(cfg)
+			if err := cfg.Save(""); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := string(data)
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("saved config lacks %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(out, s) {
+					t.Errorf("saved config still has %q:\n%s", s, out)
+				}
+			}
+
+			// What was written loads back as what was saved.
+			again, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(again.Sessions) != len(cfg.Sessions) || again.Timeout != cfg.Timeout {
+				t.Fatalf("reloaded %d sessions, timeout %d; saved %d, %d", len(again.Sessions), again.Timeout, len(cfg.Sessions), cfg.Timeout)
+			}
+			for i, s := range cfg.Sessions {
+				if got := again.Sessions[i]; got.Name != s.Name || got.ChatID != s.ChatID || got.WorkingDir != s.WorkingDir {
+					t.Errorf("session %d = %+v, want %+v", i, got, s)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveNeverWritesToken(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("TELEGRAM_BOT_TOKEN", "from-the-environment")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, "telegram:\n  allowed_users: [42]\nsessions: []\n")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Sessions = append(cfg.Sessions, SessionConfig{Name: "api", ChatID: 1, WorkingDir: "/src/api"})
+	if err := cfg.Save(""); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "from-the-environment") || strings.Contains(string(data), "bot_token") {
+		t.Fatalf("token written to the config:\n%s", data)
+	}
+}
+
+func TestSaveIsAtomicWithBackup(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, savedConfig)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Timeout = 60
+	if err := cfg.Save(""); err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := os.ReadFile(path + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(backup) != savedConfig {
+		t.Fatalf("backup = %q, want the previous config", backup)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("config mode = %o, want 600", perm)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Fatalf("temp file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestSaveThroughSymlink(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	target := filepath.Join(dir, "real.yaml")
+	link := filepath.Join(dir, "config.yaml")
+	writeFile(t, target, savedConfig)
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Timeout = 60
+	if err := cfg.Save(""); err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("symlink replaced by a file: %v", err)
+	}
+	data, _ := os.ReadFile(target)
+	if !strings.Contains(string(data), "timeout: 60") {
+		t.Fatalf("target not updated:\n%s", data)
+	}
+}
@@ -0,0 +1,183 @@
+// Package console is a transport that asks questions on the daemon's
+// terminal and reads the answers from its standard input, so cctg can be
+// used and developed without a network.
+package console
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/session"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+var _ transport.Transport = (*Console)(nil)
+
+// Console is the console transport. Every session shares the one chat, so
+// plain answers go to the oldest open question and "#<id> answer" picks one.
+// The prompt lists the open questions' IDs.
+type Console struct {
+	in       io.Reader
+	sessions *session.Manager
+	incoming chan transport.Incoming
+
+	mu     sync.Mutex
+	out    io.Writer
+	lastID int
+}
+
+func New(in io.Reader, out io.Writer, sessions *session.Manager) *Console {
+	return &Console{
+		in:       in,
+		out:      out,
+		sessions: sessions,
+		incoming: make(chan transport.Incoming, 16),
+	}
+}
+
+func (c *Console) Name() string {
+	return transport.Console
+}
+
+func (c *Console) Capabilities() transport.Capabilities {
+	return transport.Capabilities{Edit: true, Attachments: true, Replies: true}
+}
+
+func (c *Console) Incoming() <-chan transport.Incoming {
+	return c.incoming
+}
+
+// Start reads answers from the input until ctx is done. The daemon keeps
+// running if the input is closed.
+func (c *Console) Start(ctx context.Context) error {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(c.in)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	c.printf("cctg console: questions appear below. Type an answer for the oldest one, or \"#<id> answer\" for a specific one.\n%s", c.prompt(""))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case line, ok := <-lines:
+			if !ok {
+				log.Printf("console input closed, no more answers can be read")
+				lines = nil
+				continue
+			}
+			replyTo, text := parseAnswer(line)
+			if text == "" {
+				c.printf("%s", c.prompt(""))
+				continue
+			}
+			in := transport.Incoming{
+				Chat:    transport.Address{Transport: transport.Console, Chat: transport.ConsoleChat},
+				ID:      c.nextID(),
+				User:    "console",
+				Text:    text,
+				ReplyTo: replyTo,
+				Time:    time.Now(),
+			}
+			select {
+			case c.incoming <- in:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// parseAnswer splits "#<id> text" into the question ID and the answer.
+// Anything else is an answer without an ID.
+func parseAnswer(line string) (replyTo, text string) {
+	line = strings.TrimSpace(line)
+	if rest, ok := strings.CutPrefix(line, "#"); ok {
+		id, text, _ := strings.Cut(rest, " ")
+		if _, err := strconv.Atoi(id); err == nil {
+			return id, strings.TrimSpace(text)
+		}
+	}
+	return "", line
+}
+
+// Send prints msg as question #<id>.
+func (c *Console) Send(ctx context.Context, chat string, msg transport.Message) (string, error) {
+	id := c.nextID()
+
+	var b strings.Builder
+	header := "[#" + id + "]"
+	if msg.Session != "" {
+		header += " " + msg.Session + ":"
+	}
+	fmt.Fprintf(&b, "\n%s %s\n", header, indent(msg.Text))
+	for _, a := range msg.Attachments {
+		fmt.Fprintf(&b, "    attached: %s\n", a.Path)
+	}
+	if msg.Notice {
+		b.WriteString(c.prompt(""))
+	} else {
+		// The question isn't pending until Send returns.
+		b.WriteString(c.prompt(id))
+	}
+
+	c.printf("%s", b.String())
+	return id, nil
+}
+
+func (c *Console) Edit(ctx context.Context, chat, id, text string) error {
+	c.printf("\n[#%s] updated: %s\n%s", id, indent(text), c.prompt(""))
+	return nil
+}
+
+// prompt shows the IDs of the open questions, plus asking if it is being
+// sent, like "[#1 #3]> ".
+func (c *Console) prompt(asking string) string {
+	var ids []string
+	for _, pm := range c.sessions.Questions() {
+		if pm.Addr.Transport == transport.Console {
+			ids = append(ids, "#"+pm.MsgID)
+		}
+	}
+	if asking != "" {
+		ids = append(ids, "#"+asking)
+	}
+	if len(ids) == 0 {
+		return "> "
+	}
+	return "[" + strings.Join(ids, " ") + "]> "
+}
+
+func (c *Console) nextID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastID++
+	return strconv.Itoa(c.lastID)
+}
+
+func (c *Console) printf(format string, args ...any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.out, format, args...)
+}
+
+// indent lines up continuation lines of a multi-line message.
+func indent(text string) string {
+	return strings.ReplaceAll(text, "\n", "\n    ")
+}
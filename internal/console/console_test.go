@@ -0,0 +1,70 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/session"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+func TestPromptShowsOpenQuestions(t *testing.T) {
+	cfg := &config.Config{Sessions: []config.SessionConfig{{Name: "dev", Transport: transport.Console}}}
+	sessions := session.NewManager(cfg)
+	var out bytes.Buffer
+	c := New(strings.NewReader(""), &out, sessions)
+	addr := transport.Address{Transport: transport.Console, Chat: transport.ConsoleChat}
+	ctx := context.Background()
+
+	// ask sends a question and registers it the way the daemon does.
+	ask := func(text string) *session.PendingMessage {
+		id, err := c.Send(ctx, addr.Chat, transport.Message{Session: "dev", Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sessions.AddPending(addr, id, session.Question{Session: "dev", Content: text}, time.Now().Add(time.Minute))
+	}
+	expectPrompt := func(want string) {
+		t.Helper()
+		if got := out.String(); !strings.HasSuffix(got, "\n"+want) {
+			t.Fatalf("output ends with %q, want prompt %q", got[strings.LastIndex(got, "\n")+1:], want)
+		}
+		out.Reset()
+	}
+
+	first := ask("Deploy?")
+	expectPrompt("[#1]> ")
+	ask("Migrate?")
+	expectPrompt("[#1 #2]> ")
+
+	if _, err := c.Send(ctx, addr.Chat, transport.Message{Text: "Build finished", Notice: true}); err != nil {
+		t.Fatal(err)
+	}
+	expectPrompt("[#1 #2]> ")
+
+	sessions.HandleReply(addr, first.MsgID, "yes")
+	if err := c.Edit(ctx, addr.Chat, first.MsgID, "Answered: yes"); err != nil {
+		t.Fatal(err)
+	}
+	expectPrompt("[#2]> ")
+}
+
+func TestParseAnswer(t *testing.T) {
+	tests := []struct {
+		line, replyTo, text string
+	}{
+		{"ship it", "", "ship it"},
+		{"  #2 skip them ", "2", "skip them"},
+		{"#2", "2", ""},
+		{"#two words", "", "#two words"},
+	}
+	for _, tt := range tests {
+		if replyTo, text := parseAnswer(tt.line); replyTo != tt.replyTo || text != tt.text {
+			t.Errorf("parseAnswer(%q) = %q, %q; want %q, %q", tt.line, replyTo, text, tt.replyTo, tt.text)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Package dashboard is a small web UI served by the daemon: live pending
+// questions across all sessions with a form to answer them, recent history
+// and session health. It is meant for localhost and guarded by a token.
+package dashboard
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/ipc"
+	"github.com/bupd/go-claude-code-telegram/internal/session"
+)
+
+const (
+	cookieName  = "cctg_dashboard"
+	historySize = 100
+	maxBodySize = 64 << 10
+	// pingInterval keeps idle event streams from being closed by proxies.
+	pingInterval = 15 * time.Second
+)
+
+// ErrNotPending is returned by the answer function for a question that has
+// already been answered or has timed out.
+var ErrNotPending = errors.New("question is no longer pending")
+
+//go:embed index.html
+var indexHTML []byte
+
+// Question is a pending question as shown in the dashboard.
+type Question struct {
+	ID        string    `json:"id"`
+	Session   string    `json:"session"`
+	Transport string    `json:"transport"`
+	Chat      string    `json:"chat"`
+	Text      string    `json:"text"`
+	Choices   []string  `json:"choices,omitempty"`
+	AskedAt   time.Time `json:"asked_at"`
+	Deadline  time.Time `json:"deadline"`
+}
+
+// State is the dashboard's view of the daemon, served at /api/state.
+type State struct {
+	Pending []Question      `json:"pending"`
+	History []session.Event `json:"history"`
+	Status  *ipc.Status     `json:"status"`
+}
+
+type Server struct {
+	listen   string
+	token    string
+	sessions *session.Manager
+	status   func() *ipc.Status
+	answer   func(id, text string) error
+
+	// ready is closed once the server is listening; addr is set by then.
+	ready chan struct{}
+	addr  net.Addr
+
+	mu      sync.Mutex
+	history []session.Event
+}
+
+// New returns a dashboard for cfg. status reports daemon health and answer
+// resolves a pending question by its ID. Without a configured token a
+// random one is generated; see Token.
+func New(cfg config.DashboardConfig, sessions *session.Manager, status func() *ipc.Status, answer func(id, text string) error) *Server {
+	token := cfg.Token
+	if token == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		token = hex.EncodeToString(b)
+	}
+	return &Server{
+		listen:   cfg.Listen,
+		token:    token,
+		sessions: sessions,
+		status:   status,
+		answer:   answer,
+		ready:    make(chan struct{}),
+	}
+}
+
+func (s *Server) Token() string {
+	return s.token
+}
+
+// Start serves the dashboard until ctx is done.
+func (s *Server) Start(ctx context.Context) error {
+	events, unsubscribe := s.sessions.Subscribe()
+	defer unsubscribe()
+	go s.record(events)
+
+	ln, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return fmt.Errorf("dashboard: %w", err)
+	}
+	s.addr = ln.Addr()
+	close(s.ready)
+
+	srv := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	srv.BaseContext = func(net.Listener) context.Context { return ctx }
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+	defer srv.Close()
+	log.Printf("dashboard listening on http://%s", ln.Addr())
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errCh:
+		return fmt.Errorf("dashboard: %w", err)
+	}
+}
+
+// record keeps the most recent events for the history page.
+func (s *Server) record(events <-chan session.Event) {
+	for ev := range events {
+		if ev.Type == session.EventAsked {
+			continue
+		}
+		s.mu.Lock()
+		s.history = append(s.history, ev)
+		if len(s.history) > historySize {
+			s.history = s.history[len(s.history)-historySize:]
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.handleIndex)
+	mux.Handle("GET /api/state", s.authorized(http.HandlerFunc(s.handleState)))
+	mux.Handle("GET /api/events", s.authorized(http.HandlerFunc(s.handleEvents)))
+	mux.Handle("POST /api/questions/{id}/answer", s.authorized(http.HandlerFunc(s.handleAnswer)))
+	return mux
+}
+
+// handleIndex serves the page. The login link carries the token in the
+// query; it is moved into a cookie so it doesn't stay in the address bar.
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if token := r.URL.Query().Get("token"); token != "" {
+		if !s.validToken(token) {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookieName,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if !s.authenticated(r) {
+		http.Error(w, "open the dashboard with the link in the dashboard-token file of the config directory, or add ?token=<dashboard token>", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(indexHTML)
+}
+
+func (s *Server) authorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authenticated(r) {
+			writeError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		// The cookie is SameSite=Strict, but refuse cross-site writes from
+		// browsers that ignore it. Only the host is compared, so the page
+		// also works behind an HTTPS reverse proxy.
+		if origin := r.Header.Get("Origin"); r.Method != http.MethodGet && origin != "" && !sameHost(origin, r.Host) {
+			writeError(w, http.StatusForbidden, "cross-origin request")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func sameHost(origin, host string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, host)
+}
+
+// authenticated accepts the token as a bearer token, for scripts, or as the
+// cookie set by the login link.
+func (s *Server) authenticated(r *http.Request) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return s.validToken(token)
+	}
+	if c, err := r.Cookie(cookieName); err == nil {
+		return s.validToken(c.Value)
+	}
+	return false
+}
+
+func (s *Server) validToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	st := State{Pending: []Question{}, Status: s.status()}
+	for _, pm := range s.sessions.Questions() {
+		st.Pending = append(st.Pending, Question{
+			ID:        pm.ID,
+			Session:   pm.Session,
+			Transport: pm.Addr.Transport,
+			Chat:      pm.Addr.Chat,
+			Text:      pm.Content,
+			Choices:   pm.Choices,
+			AskedAt:   pm.CreatedAt,
+			Deadline:  pm.Deadline,
+		})
+	}
+	s.mu.Lock()
+	st.History = append([]session.Event{}, s.history...)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// handleEvents streams session events as server-sent events named after
+// the event type.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	events, unsubscribe := s.sessions.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, ": connected\n\n")
+	flusher.Flush()
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			io.WriteString(w, ": ping\n\n")
+		case ev := <-events:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *Server) handleAnswer(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if strings.TrimSpace(body.Text) == "" {
+		writeError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+
+	err := s.answer(r.PathValue("id"), body.Text)
+	switch {
+	case errors.Is(err, ErrNotPending):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, `{"status":"answered"}`+"\n")
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,164 @@
+package dashboard
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/ipc"
+	"github.com/bupd/go-claude-code-telegram/internal/session"
+	"github.com/bupd/go-claude-code-telegram/internal/testutil"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+const testToken = "0123456789abcdef"
+
+var addr = transport.Address{Transport: transport.Telegram, Chat: "42"}
+
+func start(t *testing.T) (*session.Manager, string) {
+	t.Helper()
+	cfg := &config.Config{Sessions: []config.SessionConfig{{Name: "proj", ChatID: 42}}}
+	sessions := session.NewManager(cfg)
+	answer := func(id, text string) error {
+		if _, ok := sessions.Answer(id, text, session.SourceDashboard); !ok {
+			return ErrNotPending
+		}
+		return nil
+	}
+	status := func() *ipc.Status { return &ipc.Status{Version: "test"} }
+	s := New(config.DashboardConfig{Listen: "127.0.0.1:0", Token: testToken}, sessions, status, answer)
+
+	testutil.WaitReady(t, s.ready, testutil.Start(t, s.Start))
+	return sessions, "http://" + s.addr.String()
+}
+
+func do(t *testing.T, method, url, token, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestRequiresToken(t *testing.T) {
+	_, base := start(t)
+
+	for _, token := range []string{"", "wrong"} {
+		if resp := do(t, http.MethodGet, base+"/api/state", token, ""); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("state with token %q: HTTP %d, want 401", token, resp.StatusCode)
+		}
+	}
+
+	// The login link sets a cookie that then works on its own.
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(base + "/?token=" + testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	cookies := resp.Cookies()
+	if resp.StatusCode != http.StatusSeeOther || len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("login: HTTP %d, cookies %v", resp.StatusCode, cookies)
+	}
+	req, _ := http.NewRequest(http.MethodGet, base+"/", nil)
+	req.AddCookie(cookies[0])
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("page with cookie: HTTP %d", resp.StatusCode)
+	}
+}
+
+func TestAnswerResolvesPending(t *testing.T) {
+	sessions, base := start(t)
+	pm := sessions.AddPending(addr, "7", session.Question{Session: "proj", Content: "Deploy?", Choices: []string{"yes", "no"}}, time.Now().Add(time.Minute))
+
+	resp := do(t, http.MethodGet, base+"/api/state", testToken, "")
+	var st State
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Pending) != 1 || st.Pending[0].ID != pm.ID || st.Pending[0].Session != "proj" || len(st.Pending[0].Choices) != 2 || st.Status.Version != "test" {
+		t.Fatalf("state = %+v", st)
+	}
+
+	events := do(t, http.MethodGet, base+"/api/events", testToken, "")
+	lines := bufio.NewScanner(events.Body)
+	lines.Scan() // ": connected"
+
+	if resp := do(t, http.MethodPost, base+"/api/questions/"+pm.ID+"/answer", testToken, `{"text":"yes"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("answer: HTTP %d", resp.StatusCode)
+	}
+	select {
+	case reply := <-pm.ResponseCh:
+		if reply != "yes" {
+			t.Fatalf("reply = %q", reply)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending question not answered")
+	}
+
+	var ev session.Event
+	for lines.Scan() {
+		if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+			json.Unmarshal([]byte(data), &ev)
+			break
+		}
+	}
+	if ev.Type != session.EventAnswered || ev.QuestionID != pm.ID || ev.Text != "yes" || ev.Source != session.SourceDashboard {
+		t.Fatalf("event = %+v", ev)
+	}
+
+	if resp := do(t, http.MethodPost, base+"/api/questions/"+pm.ID+"/answer", testToken, `{"text":"no"}`); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("second answer: HTTP %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestOriginCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		origin func(base string) string
+		want   int
+	}{
+		{"same origin", func(base string) string { return base }, http.StatusOK},
+		{"https proxy in front", func(base string) string { return "https://" + strings.TrimPrefix(base, "http://") }, http.StatusOK},
+		{"other site", func(string) string { return "http://evil.example" }, http.StatusForbidden},
+		{"other site over https", func(string) string { return "https://evil.example" }, http.StatusForbidden},
+		{"opaque origin", func(string) string { return "null" }, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions, base := start(t)
+			pm := sessions.AddPending(addr, "7", session.Question{Session: "proj", Content: "Deploy?"}, time.Now().Add(time.Minute))
+
+			req, _ := http.NewRequest(http.MethodPost, base+"/api/questions/"+pm.ID+"/answer", strings.NewReader(`{"text":"yes"}`))
+			req.Header.Set("Authorization", "Bearer "+testToken)
+			req.Header.Set("Origin", tt.origin(base))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("answer: HTTP %d, want %d", resp.StatusCode, tt.want)
+			}
+			if answered := !sessions.HasPendingForChat(addr); answered != (tt.want == http.StatusOK) {
+				t.Fatalf("question answered: %v", answered)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+// Package httpclient builds the HTTP transport the messenger clients share,
+// so a configured proxy is applied the same way for every API.
+package httpclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultTimeout is the per-request timeout when none is configured.
+const DefaultTimeout = 30 * time.Second
+
+// Transport returns a copy of the default transport that sends requests
+// through proxy, an http://, https:// or socks5:// URL. With no proxy the
+// usual HTTPS_PROXY/NO_PROXY environment applies.
+func Transport(proxy string) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy == "" {
+		return transport, nil
+	}
+
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use http, https or socks5)", u.Scheme)
+	}
+	transport.Proxy = http.ProxyURL(u)
+	return transport, nil
+}
+
+// Timeout converts a configured timeout in seconds, using DefaultTimeout if
+// it isn't set.
+func Timeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return DefaultTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
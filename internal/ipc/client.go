@@ -2,58 +2,389 @@ package ipc
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Client struct {
-	socketPath string
+	// network and address are what to dial: the Unix socket, or a tcp://
+	// or https:// remote listener.
+	network string
+	address string
+	// https is set for https:// daemons, whose requests go to baseURL.
+	https   *http.Client
+	baseURL string
+	token   string
+
+	// hello is the daemon's handshake answer, once fetched. It is dropped
+	// when the connection is lost or the daemon seems to have changed, since
+	// a daemon restarted with --replace may be another version.
+	helloMu sync.Mutex
+	hello   *Hello
 }
 
 func NewClient(socketPath string) *Client {
 	return &Client{
-		socketPath: socketPath,
+		network: "unix",
+		address: socketPath,
 	}
 }
 
+// RemoteOptions are the credentials for a remote daemon.
+type RemoteOptions struct {
+	Token string
+	// CertFile and KeyFile are a client certificate for mTLS.
+	CertFile string
+	KeyFile  string
+	// CAFile verifies the daemon's certificate instead of the system roots.
+	CAFile string
+}
+
+// NewRemoteClient returns a client for a daemon at addr: a tcp://host:port
+// or https://host:port remote listener, or a unix:// socket path.
+func NewRemoteClient(addr string, opts RemoteOptions) (*Client, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("daemon address: %w", err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		return NewClient(u.Path), nil
+	case "tcp":
+		if u.Port() == "" {
+			return nil, fmt.Errorf("daemon address %q has no port", addr)
+		}
+		return &Client{network: "tcp", address: u.Host, token: opts.Token}, nil
+	case "https":
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if opts.CAFile != "" {
+			pool, err := loadCertPool(opts.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("daemon CA: %w", err)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if opts.CertFile != "" || opts.KeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("loading client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+		return &Client{
+			network: "tcp",
+			address: host,
+			https:   &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}},
+			baseURL: "https://" + u.Host,
+			token:   opts.Token,
+		}, nil
+	default:
+		return nil, fmt.Errorf("daemon address %q must be tcp://host:port, https://host:port or unix:///path", addr)
+	}
+}
+
+// Send sends req and returns the daemon's response.
 func (c *Client) Send(req *Request) (*Response, error) {
-	conn, err := net.DialTimeout("unix", c.socketPath, 5*time.Second)
+	return c.SendContext(context.Background(), req)
+}
+
+// SendContext is Send, giving up when ctx is done. A request type the
+// daemon doesn't know is reported as a version mismatch.
+func (c *Client) SendContext(ctx context.Context, req *Request) (*Response, error) {
+	resp, body, err := c.roundTrip(ctx, req)
 	if err != nil {
-		return nil, fmt.Errorf("connecting to daemon: %w", err)
+		return nil, err
 	}
-	defer conn.Close()
+	body.Close()
+	return resp, nil
+}
+
+// Subscribe sends a subscribe request and returns the streamed events. The
+// channel is closed when ctx is done or the daemon ends the stream.
+func (c *Client) Subscribe(ctx context.Context, req *Request) (<-chan Event, error) {
+	req.Type = RequestTypeSubscribe
+	resp, body, err := c.roundTrip(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		body.Close()
+		return nil, &ResponseError{Code: resp.Code, Message: resp.Error}
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer body.Close()
+		dec := json.NewDecoder(body)
+		for {
+			var ev Event
+			if err := dec.Decode(&ev); err != nil {
+				if ctx.Err() == nil {
+					c.forgetHello()
+				}
+				return
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// ResponseError is a failed response as an error.
+type ResponseError struct {
+	Code    string
+	Message string
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+// roundTrip sends req and reads the response, returning the rest of the
+// connection for streamed events. The body must be closed.
+func (c *Client) roundTrip(ctx context.Context, req *Request) (*Response, io.ReadCloser, error) {
+	versioned := *req
+	versioned.Version = ProtocolVersion
+
+	var resp *Response
+	var body io.ReadCloser
+	var err error
+	if c.https != nil {
+		resp, body, err = c.sendHTTPS(ctx, &versioned)
+	} else {
+		resp, body, err = c.send(ctx, &versioned)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		c.forgetHello()
+		return nil, nil, err
+	}
+	c.helloMu.Lock()
+	if c.hello != nil && resp.Version != 0 && resp.Version != c.hello.Version {
+		// Replaced between requests by a daemon of another version.
+		c.hello = nil
+	}
+	c.helloMu.Unlock()
+	if !resp.Success && (resp.Code == CodeUnknownRequest || (resp.Version == 0 && strings.HasPrefix(resp.Error, "unknown request type"))) {
+		mismatch := &MismatchError{Hello: Hello{Version: max(resp.Version, 1)}, Missing: fmt.Sprintf("%q requests", req.Type)}
+		c.forgetHello()
+		resp.Code = CodeVersionMismatch
+		resp.Error = mismatch.Error()
+	}
+	return resp, body, nil
+}
+
+// Hello returns the daemon's protocol range and capabilities. Daemons from
+// before the handshake are reported as protocol 1 with no capabilities.
+func (c *Client) Hello() (*Hello, error) {
+	return c.HelloContext(context.Background())
+}
+
+// HelloContext is Hello, giving up when ctx is done.
+func (c *Client) HelloContext(ctx context.Context) (*Hello, error) {
+	c.helloMu.Lock()
+	h := c.hello
+	c.helloMu.Unlock()
+	if h != nil {
+		return h, nil
+	}
+
+	resp, err := c.SendContext(ctx, &Request{Type: RequestTypeHello})
+	if err != nil {
+		return nil, err
+	}
+	h = resp.Hello
+	if h == nil {
+		h = &Hello{Version: max(resp.Version, 1), MinVersion: 1}
+	}
+	c.helloMu.Lock()
+	c.hello = h
+	c.helloMu.Unlock()
+	return h, nil
+}
+
+// forgetHello drops the cached handshake so the next Require asks the
+// daemon again. It is called when the connection fails or a request is
+// unknown: the daemon may have been replaced by another version.
+func (c *Client) forgetHello() {
+	c.helloMu.Lock()
+	c.hello = nil
+	c.helloMu.Unlock()
+}
 
+// Require returns a *MismatchError if the daemon lacks any of caps.
+func (c *Client) Require(caps ...string) error {
+	return c.RequireContext(context.Background(), caps...)
+}
+
+// RequireContext is Require, giving up when ctx is done.
+func (c *Client) RequireContext(ctx context.Context, caps ...string) error {
+	if len(caps) == 0 {
+		return nil
+	}
+	// A cached hello lacking a capability is checked again, in case the
+	// daemon was upgraded since.
+	for refreshed := false; ; refreshed = true {
+		h, err := c.HelloContext(ctx)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(caps, func(capability string) bool { return !slices.Contains(h.Capabilities, capability) })
+		if i < 0 {
+			return nil
+		}
+		if refreshed {
+			return &MismatchError{Hello: *h, Missing: caps[i]}
+		}
+		c.forgetHello()
+	}
+}
+
+// MismatchError reports that the daemon can't do something this client
+// needs, usually because it is older.
+type MismatchError struct {
+	Hello   Hello
+	Missing string
+}
+
+func (e *MismatchError) Error() string {
+	if e.Hello.Version < ProtocolVersion {
+		return fmt.Sprintf("the daemon is older than this cctg (protocol %d, this cctg speaks %d) and doesn't support %s; restart it with: cctg serve --replace",
+			e.Hello.Version, ProtocolVersion, e.Missing)
+	}
+	return fmt.Sprintf("the daemon doesn't support %s", e.Missing)
+}
+
+func (c *Client) send(ctx context.Context, req *Request) (*Response, io.ReadCloser, error) {
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to daemon: %w", err)
+	}
+	// Closing the connection unblocks the read below when ctx is done.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	body := &connBody{Conn: conn, stop: stop}
+
+	if c.token != "" {
+		req.Token = c.token
+	}
 	data, err := json.Marshal(req)
 	if err != nil {
-		return nil, fmt.Errorf("marshaling request: %w", err)
+		body.Close()
+		return nil, nil, fmt.Errorf("marshaling request: %w", err)
 	}
 	data = append(data, '\n')
 
 	if _, err := conn.Write(data); err != nil {
-		return nil, fmt.Errorf("sending request: %w", err)
+		body.Close()
+		return nil, nil, fmt.Errorf("sending request: %w", err)
 	}
 
 	reader := bufio.NewReader(conn)
 	line, err := reader.ReadBytes('\n')
 	if err != nil {
-		return nil, fmt.Errorf("reading response: %w", err)
+		body.Close()
+		return nil, nil, fmt.Errorf("reading response: %w", err)
 	}
+	body.Reader = reader
 
 	var resp Response
 	if err := json.Unmarshal(line, &resp); err != nil {
-		return nil, fmt.Errorf("parsing response: %w", err)
+		body.Close()
+		return nil, nil, fmt.Errorf("parsing response: %w", err)
+	}
+
+	return &resp, body, nil
+}
+
+// connBody is the rest of a socket connection after the response.
+type connBody struct {
+	net.Conn
+	*bufio.Reader
+	stop func() bool
+}
+
+func (b *connBody) Read(p []byte) (int, error) {
+	return b.Reader.Read(p)
+}
+
+func (b *connBody) Close() error {
+	b.stop()
+	return b.Conn.Close()
+}
+
+func (c *Client) sendHTTPS(ctx context.Context, req *Request) (*Response, io.ReadCloser, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshaling request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+APIPrefix+url.PathEscape(req.Type), bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("sending request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
 	}
 
-	return &resp, nil
+	httpResp, err := c.https.Do(httpReq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to daemon: %w", err)
+	}
+
+	// Events follow the response as JSON lines, so decode only the first
+	// value and hand the rest back.
+	reader := bufio.NewReader(httpResp.Body)
+	line, err := reader.ReadBytes('\n')
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		httpResp.Body.Close()
+		return nil, nil, fmt.Errorf("parsing response (HTTP %s): %w", httpResp.Status, err)
+	}
+	if err != nil && err != io.EOF {
+		httpResp.Body.Close()
+		return nil, nil, fmt.Errorf("reading response: %w", err)
+	}
+	return &resp, struct {
+		io.Reader
+		io.Closer
+	}{reader, httpResp.Body}, nil
 }
 
+// IsRunning reports whether something is listening at the daemon's address.
 func (c *Client) IsRunning() bool {
-	conn, err := net.DialTimeout("unix", c.socketPath, 1*time.Second)
+	conn, err := net.DialTimeout(c.network, c.address, 1*time.Second)
 	if err != nil {
 		return false
 	}
 	conn.Close()
 	return true
 }
+
+// Remote reports whether the client talks to a daemon over the network.
+func (c *Client) Remote() bool {
+	return c.network != "unix"
+}
@@ -0,0 +1,49 @@
+package ipc
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+)
+
+// Peer identifies the process on the other end of a socket connection.
+type Peer struct {
+	UID uint32
+	GID uint32
+	// Groups are the supplementary groups, if they could be read.
+	Groups []uint32
+	// PID is 0 when the peer is in a PID namespace we can't see.
+	PID int32
+	// Exe is the peer's executable, if we're allowed to read it.
+	Exe string
+}
+
+func (p Peer) String() string {
+	s := fmt.Sprintf("uid=%d gid=%d pid=%d", p.UID, p.GID, p.PID)
+	if p.Exe != "" {
+		s += " exe=" + p.Exe
+	}
+	return s
+}
+
+// allowedPeer reports whether p matches any of the socket's allow rules.
+func allowedPeer(rules config.SocketConfig, p Peer) bool {
+	if slices.Contains(rules.AllowedUIDs, p.UID) {
+		return true
+	}
+	for _, gid := range rules.AllowedGIDs {
+		if gid == p.GID || slices.Contains(p.Groups, gid) {
+			return true
+		}
+	}
+	if p.Exe != "" {
+		for _, exe := range rules.AllowedExecutables {
+			if filepath.Clean(exe) == p.Exe {
+				return true
+			}
+		}
+	}
+	return false
+}
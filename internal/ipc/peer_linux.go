@@ -0,0 +1,124 @@
+package ipc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const peerCredentialsSupported = true
+
+// Not in package syscall yet.
+const (
+	soPeerPIDFD        = 77 // SO_PEERPIDFD, Linux 6.5
+	sysPidfdSendSignal = 424
+)
+
+// peerCredentials reads SO_PEERCRED from a Unix socket connection, plus the
+// peer's executable and supplementary groups from /proc.
+func peerCredentials(conn net.Conn) (Peer, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return Peer{}, errors.New("not a unix socket connection")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return Peer{}, err
+	}
+	var p Peer
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, err := syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+		if err != nil {
+			credErr = err
+			return
+		}
+		p = Peer{UID: cred.Uid, GID: cred.Gid, PID: cred.Pid}
+		if p.PID > 0 {
+			p.Exe = peerExe(int(fd), p.PID)
+			p.Groups = procGroups(p.PID)
+		}
+	}); err != nil {
+		return Peer{}, err
+	}
+	if credErr != nil {
+		return Peer{}, fmt.Errorf("reading SO_PEERCRED: %w", credErr)
+	}
+	return p, nil
+}
+
+// peerExe returns the executable of the process that connected on fd, or ""
+// if it can't be told. With SO_PEERPIDFD the pid is checked to still belong
+// to that process after reading /proc, so a pid reused after the peer
+// exited doesn't pass for it. Kernels before 6.5 only give the pid.
+func peerExe(fd int, pid int32) string {
+	pidfd, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, soPeerPIDFD)
+	if err != nil {
+		exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+		return exe
+	}
+	defer syscall.Close(pidfd)
+
+	if pidfdPID(pidfd) != pid {
+		return ""
+	}
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return ""
+	}
+	// Signal 0 only checks that the process is still alive, and so still
+	// owns the pid.
+	if _, _, errno := syscall.Syscall6(sysPidfdSendSignal, uintptr(pidfd), 0, 0, 0, 0, 0); errno != 0 {
+		return ""
+	}
+	return exe
+}
+
+// pidfdPID returns the pid a pidfd refers to, or -1 once it has exited.
+func pidfdPID(pidfd int) int32 {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/self/fdinfo/%d", pidfd))
+	if err != nil {
+		return -1
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "Pid:"); ok {
+			pid, err := strconv.ParseInt(strings.TrimSpace(v), 10, 32)
+			if err != nil {
+				return -1
+			}
+			return int32(pid)
+		}
+	}
+	return -1
+}
+
+// procGroups returns the supplementary groups of pid, from the world
+// readable status file.
+func procGroups(pid int32) []uint32 {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		list, ok := strings.CutPrefix(scanner.Text(), "Groups:")
+		if !ok {
+			continue
+		}
+		var groups []uint32
+		for _, field := range strings.Fields(list) {
+			if gid, err := strconv.ParseUint(field, 10, 32); err == nil {
+				groups = append(groups, uint32(gid))
+			}
+		}
+		return groups
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package ipc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+)
+
+func startLocal(t *testing.T, rules config.SocketConfig) *Client {
+	t.Helper()
+	s := NewServer(filepath.Join(t.TempDir(), "cctg.sock"), echo)
+	s.AllowPeers(rules)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		s.Stop()
+	})
+	return NewClient(s.SocketPath())
+}
+
+func TestPeerCredentials(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe, _ = filepath.EvalSymlinks(exe)
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+
+	for _, tc := range []struct {
+		name  string
+		rules config.SocketConfig
+		ok    bool
+	}{
+		{"no rules", config.SocketConfig{}, true},
+		{"uid", config.SocketConfig{AllowedUIDs: []uint32{uid + 1, uid}}, true},
+		{"gid", config.SocketConfig{AllowedGIDs: []uint32{gid}}, true},
+		{"executable", config.SocketConfig{AllowedExecutables: []string{exe}}, true},
+		{"other executable", config.SocketConfig{AllowedExecutables: []string{"/usr/bin/false"}}, false},
+		{"no match", config.SocketConfig{AllowedUIDs: []uint32{uid + 1}, AllowedExecutables: []string{"/usr/bin/false"}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := startLocal(t, tc.rules)
+			resp, err := c.Send(&Request{Type: RequestTypeSend, Message: "hi"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.ok && (!resp.Success || resp.Reply != "send:hi") {
+				t.Fatalf("response = %+v, want the request answered", resp)
+			}
+			if !tc.ok && (resp.Success || resp.Error != ErrPeerNotAllowed) {
+				t.Fatalf("response = %+v, want %q", resp, ErrPeerNotAllowed)
+			}
+		})
+	}
+}
+
+func TestPeerExe(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	want, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := peerExe(fds[0], int32(os.Getpid())); got != want {
+		t.Fatalf("peerExe = %q, want %q", got, want)
+	}
+	// A pid that doesn't belong to the peer is not resolved with a pidfd.
+	if _, err := syscall.GetsockoptInt(fds[0], syscall.SOL_SOCKET, soPeerPIDFD); err == nil {
+		if got := peerExe(fds[0], 1); got != "" {
+			t.Fatalf("peerExe for another pid = %q, want it refused", got)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package ipc
+
+import (
+	"errors"
+	"net"
+)
+
+const peerCredentialsSupported = false
+
+func peerCredentials(conn net.Conn) (Peer, error) {
+	return Peer{}, errors.New("peer credentials are only supported on Linux")
+}
@@ -1,21 +1,184 @@
 package ipc
 
+import (
+	"fmt"
+	"time"
+)
+
+// ProtocolVersion is the protocol this build speaks. Messages without a
+// version are protocol 1, from before the protocol was versioned. A daemon
+// answers requests from MinProtocolVersion up to its own version.
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1
+)
+
+// Capabilities a daemon announces in its hello response. Clients check for
+// them before using the corresponding request fields, which an older daemon
+// would silently ignore.
+const (
+	CapAttachments = "attachments"
+	CapChoices     = "choices"
+	// CapAsync covers the ask, wait and cancel requests.
+	CapAsync  = "async"
+	CapNotify = "notify"
+	CapEvents = "events"
+)
+
+// Error codes in Response.Code.
+const (
+	CodeBadRequest     = "bad_request"
+	CodeUnknownRequest = "unknown_request"
+	// CodeVersionMismatch means client and daemon protocols don't overlap.
+	CodeVersionMismatch      = "version_mismatch"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeShuttingDown         = "shutting_down"
+	CodeNoSession            = "no_session"
+	CodeTransportUnavailable = "transport_unavailable"
+	CodeUnsupported          = "unsupported"
+	CodeDeliveryFailed       = "delivery_failed"
+	CodeTimeout              = "timeout"
+	CodeInvalidConfig        = "invalid_config"
+	// CodeNotFound is a wait or cancel for a question the daemon doesn't
+	// know, or no longer remembers.
+	CodeNotFound  = "not_found"
+	CodeCancelled = "cancelled"
+)
+
+// Question states in Response.State.
+const (
+	StatePending   = "pending"
+	StateAnswered  = "answered"
+	StateExpired   = "expired"
+	StateCancelled = "cancelled"
+)
+
 type Request struct {
+	// Version is the client's protocol version.
+	Version int    `json:"version,omitempty"`
 	Type    string `json:"type"`
 	Session string `json:"session"`
 	Message string `json:"message"`
 	Timeout int    `json:"timeout"`
 	WorkDir string `json:"workdir"`
+	// Attachments are absolute paths of files to send with the message.
+	Attachments []string `json:"attachments,omitempty"`
+	// Choices are answers offered as buttons where the transport has them.
+	Choices []string `json:"choices,omitempty"`
+	// Token authenticates requests on a tcp:// remote listener.
+	Token string `json:"token,omitempty"`
+	// ID names the question for wait and cancel.
+	ID string `json:"id,omitempty"`
 }
 
 type Response struct {
+	// Version is the daemon's protocol version.
+	Version int    `json:"version,omitempty"`
 	Success bool   `json:"success"`
 	Reply   string `json:"reply"`
 	ChatID  int64  `json:"chat_id,omitempty"`
-	Error   string `json:"error,omitempty"`
+	// Chat is the captured chat for get_chat_id, as transport:chat.
+	Chat  string `json:"chat,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Code classifies Error for programs; see the Code constants.
+	Code   string  `json:"code,omitempty"`
+	Status *Status `json:"status,omitempty"`
+	Hello  *Hello  `json:"hello,omitempty"`
+	// ID and Deadline describe the question created by ask.
+	ID       string     `json:"id,omitempty"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// State is the question's state for ask, wait and cancel.
+	State string `json:"state,omitempty"`
+
+	// Events, for subscribe, are streamed to the client as JSON lines after
+	// the response until the channel is closed or the client goes away.
+	// Unsubscribe is called when streaming stops.
+	Events      <-chan Event `json:"-"`
+	Unsubscribe func()       `json:"-"`
+}
+
+// Event is a question or chat event streamed to subscribers.
+type Event struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Session    string    `json:"session,omitempty"`
+	Transport  string    `json:"transport"`
+	Chat       string    `json:"chat"`
+	QuestionID string    `json:"question_id,omitempty"`
+	Question   string    `json:"question,omitempty"`
+	Choices    []string  `json:"choices,omitempty"`
+	Text       string    `json:"text,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	State      string    `json:"state,omitempty"`
+}
+
+// Errorf returns a failed response with the given code.
+func Errorf(code, format string, args ...any) *Response {
+	return &Response{Success: false, Code: code, Error: fmt.Sprintf(format, args...)}
+}
+
+// Hello is the daemon's answer to a hello request.
+type Hello struct {
+	// Version and MinVersion are the range of protocols the daemon speaks.
+	Version       int      `json:"version"`
+	MinVersion    int      `json:"min_version"`
+	DaemonVersion string   `json:"daemon_version,omitempty"`
+	Capabilities  []string `json:"capabilities"`
+}
+
+// Status describes the running daemon.
+type Status struct {
+	Version       string          `json:"version"`
+	PID           int             `json:"pid"`
+	StartedAt     time.Time       `json:"started_at"`
+	UptimeSeconds int64           `json:"uptime_seconds"`
+	ConfigPath    string          `json:"config_path"`
+	BotUsername   string          `json:"bot_username,omitempty"`
+	Telegram      TelegramStatus  `json:"telegram"`
+	Sessions      []SessionStatus `json:"sessions"`
+}
+
+type SessionStatus struct {
+	Name      string `json:"name"`
+	Transport string `json:"transport"`
+	// Chat is the chat in the transport's own terms; ChatID is set as well
+	// for Telegram.
+	Chat                    string `json:"chat"`
+	ChatID                  int64  `json:"chat_id,omitempty"`
+	Pending                 int    `json:"pending"`
+	Queued                  int    `json:"queued"`
+	OldestPendingAgeSeconds int64  `json:"oldest_pending_age_seconds,omitempty"`
+}
+
+type TelegramStatus struct {
+	State         string    `json:"state"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorAt   time.Time `json:"last_error_at"`
+	ConflictSince time.Time `json:"conflict_since"`
+	LastUpdateAt  time.Time `json:"last_update_at"`
 }
 
 const (
 	RequestTypeSend      = "send"
 	RequestTypeGetChatID = "get_chat_id"
+	RequestTypeReload    = "reload"
+	RequestTypeShutdown  = "shutdown"
+	RequestTypeStatus    = "status"
+	RequestTypeHello     = "hello"
+	// RequestTypeAsk sends a question and returns its ID at once; wait
+	// returns the answer and cancel withdraws it.
+	RequestTypeAsk       = "ask"
+	RequestTypeWait      = "wait"
+	RequestTypeCancel    = "cancel"
+	RequestTypeNotify    = "notify"
+	RequestTypeSubscribe = "subscribe"
 )
+
+// ErrShuttingDown is returned to in-flight requests when the daemon stops,
+// for example because it is being replaced by "cctg serve --replace".
+const ErrShuttingDown = "daemon is shutting down"
+
+// ErrPeerNotAllowed is returned to socket clients that match none of the
+// socket allow rules.
+const ErrPeerNotAllowed = "not allowed by the daemon's socket rules"
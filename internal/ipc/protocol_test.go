@@ -0,0 +1,141 @@
+package ipc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// legacyDaemon answers like a daemon from before protocol versioning: no
+// version, no codes and no hello.
+func legacyDaemon(t *testing.T) *Client {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "legacy.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadBytes('\n')
+			var req map[string]any
+			json.Unmarshal(line, &req)
+			if req["type"] == RequestTypeStatus {
+				conn.Write([]byte(`{"success":true,"reply":""}` + "\n"))
+			} else {
+				conn.Write([]byte(`{"success":false,"reply":"","error":"unknown request type"}` + "\n"))
+			}
+			conn.Close()
+		}
+	}()
+	return NewClient(path)
+}
+
+func TestLegacyDaemon(t *testing.T) {
+	c := legacyDaemon(t)
+
+	h, err := c.Hello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 1 || len(h.Capabilities) != 0 {
+		t.Fatalf("hello = %+v, want protocol 1 without capabilities", h)
+	}
+
+	err = c.Require(CapChoices)
+	var mismatch *MismatchError
+	if !errors.As(err, &mismatch) || !strings.Contains(err.Error(), "restart it") {
+		t.Fatalf("Require(choices) = %v, want a mismatch asking for a restart", err)
+	}
+
+	resp, err := c.Send(&Request{Type: "something_new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != CodeVersionMismatch || !strings.Contains(resp.Error, "daemon is older") {
+		t.Fatalf("unknown request response = %+v", resp)
+	}
+
+	if resp, err := c.Send(&Request{Type: RequestTypeStatus}); err != nil || !resp.Success {
+		t.Fatalf("status = %+v, %v; old requests should still work", resp, err)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	var got *Request
+	s := NewServer(filepath.Join(t.TempDir(), "cctg.sock"), func(req *Request) *Response {
+		got = req
+		if req.Type == RequestTypeHello {
+			return &Response{Success: true, Hello: &Hello{Version: ProtocolVersion, MinVersion: MinProtocolVersion, Capabilities: []string{CapChoices}}}
+		}
+		return Errorf(CodeUnknownRequest, "unknown request type %q", req.Type)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		s.Stop()
+	})
+	c := NewClient(s.SocketPath())
+
+	if err := c.Require(CapChoices); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != ProtocolVersion {
+		t.Fatalf("request version = %d, want %d", got.Version, ProtocolVersion)
+	}
+	if err := c.Require(CapAttachments); err == nil || strings.Contains(err.Error(), "older") {
+		t.Fatalf("Require(attachments) = %v, want a plain unsupported error", err)
+	}
+}
+
+func TestClientTooOld(t *testing.T) {
+	minClientVersion = ProtocolVersion + 1
+	t.Cleanup(func() { minClientVersion = MinProtocolVersion })
+	s := NewServer(filepath.Join(t.TempDir(), "cctg.sock"), echo)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		s.Stop()
+	})
+	c := NewClient(s.SocketPath())
+
+	resp, err := c.Send(&Request{Type: RequestTypeSend, Message: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Success || resp.Code != CodeVersionMismatch || !strings.Contains(resp.Error, "upgrade cctg") {
+		t.Fatalf("response = %+v, want a version mismatch", resp)
+	}
+
+	// A request without a version is protocol 1.
+	conn, err := net.Dial("unix", s.SocketPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(`{"type":"send","message":"hi"}` + "\n"))
+	var legacy Response
+	if err := json.NewDecoder(conn).Decode(&legacy); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Code != CodeVersionMismatch || !strings.Contains(legacy.Error, "speaks protocol 1") {
+		t.Fatalf("unversioned response = %+v, want a version mismatch", legacy)
+	}
+}
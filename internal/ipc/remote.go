@@ -0,0 +1,299 @@
+package ipc
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+)
+
+// ErrUnauthorized is the error returned to remote clients without a valid
+// token or client certificate.
+const ErrUnauthorized = "unauthorized"
+
+// APIPrefix is the path prefix of the REST API: requests are POSTed to
+// APIPrefix + request type, such as /v1/send.
+const APIPrefix = "/v1/"
+
+const maxRequestSize = 1 << 20
+
+// requestTimeout bounds how long a remote client may take to send its
+// request, so unauthenticated connections can't be held open.
+const requestTimeout = 10 * time.Second
+
+// RemoteServer serves the IPC requests over TCP, either as newline JSON
+// (tcp://) or as a REST API (https://), for clients that can't reach the
+// Unix socket.
+type RemoteServer struct {
+	scheme    string
+	host      string
+	tokens    []string
+	tlsConfig *tls.Config
+	handler   RequestHandler
+	// readTimeout is requestTimeout, shortened by tests.
+	readTimeout time.Duration
+
+	listener net.Listener
+	http     *http.Server
+	conns    sync.WaitGroup
+}
+
+func NewRemoteServer(cfg config.RemoteConfig, handler RequestHandler) (*RemoteServer, error) {
+	u, err := url.Parse(cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("remote.listen: %w", err)
+	}
+	s := &RemoteServer{scheme: u.Scheme, host: u.Host, tokens: cfg.Tokens, handler: handler, readTimeout: requestTimeout}
+
+	switch u.Scheme {
+	case "tcp":
+		if len(cfg.Tokens) == 0 {
+			return nil, errors.New("remote.tokens is empty: a tcp:// listener needs at least one token")
+		}
+	case "https":
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading remote TLS certificate: %w", err)
+		}
+		s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		if cfg.ClientCA != "" {
+			pool, err := loadCertPool(cfg.ClientCA)
+			if err != nil {
+				return nil, fmt.Errorf("remote.client_ca: %w", err)
+			}
+			s.tlsConfig.ClientCAs = pool
+			s.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			if len(cfg.Tokens) == 0 {
+				s.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+		} else if len(cfg.Tokens) == 0 {
+			return nil, errors.New("remote listener has no way to authenticate clients: set remote.tokens or remote.client_ca")
+		}
+	default:
+		return nil, fmt.Errorf("remote.listen must be tcp://host:port or https://host:port, got %q", cfg.Listen)
+	}
+	return s, nil
+}
+
+// Start listens and serves requests until Stop.
+func (s *RemoteServer) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.host)
+	if err != nil {
+		return fmt.Errorf("remote listener: %w", err)
+	}
+	s.listener = ln
+
+	if s.scheme == "tcp" {
+		go s.acceptLoop(ctx)
+		return nil
+	}
+
+	s.http = &http.Server{
+		Handler:           s.restHandler(),
+		TLSConfig:         s.tlsConfig,
+		ReadHeaderTimeout: s.readTimeout,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go s.http.Serve(tls.NewListener(ln, s.tlsConfig))
+	return nil
+}
+
+// Addr is the listening address, once started.
+func (s *RemoteServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// URL is the address clients connect to, once started.
+func (s *RemoteServer) URL() string {
+	return s.scheme + "://" + s.listener.Addr().String()
+}
+
+// Stop closes the listener and gives in-flight requests a moment to be
+// answered.
+func (s *RemoteServer) Stop() error {
+	if s.listener == nil {
+		return nil
+	}
+	if s.http != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+		defer cancel()
+		s.http.Shutdown(ctx)
+		return nil
+	}
+	s.listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+	}
+	return nil
+}
+
+func (s *RemoteServer) acceptLoop(ctx context.Context) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			// The request is read before its token is checked, so bound
+			// it in size and time.
+			conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+			serveConn(conn, io.LimitReader(conn, maxRequestSize), func(req *Request) *Response {
+				if !s.validToken(req.Token) {
+					log.Printf("remote: rejecting %s request from %s: invalid token", req.Type, conn.RemoteAddr())
+					return Errorf(CodeUnauthorized, ErrUnauthorized)
+				}
+				req.Token = ""
+				return s.handle(req)
+			})
+		}()
+	}
+}
+
+func (s *RemoteServer) restHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+APIPrefix+RequestTypeStatus, s.handleREST)
+	mux.HandleFunc("POST "+APIPrefix+"{type}", s.handleREST)
+	return mux
+}
+
+// handleREST answers a request whose type is the last path element and
+// whose other fields, if any, are the JSON body.
+func (s *RemoteServer) handleREST(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticated(r) {
+		log.Printf("remote: rejecting %s %s from %s: no valid token or client certificate", r.Method, r.URL.Path, r.RemoteAddr)
+		writeResponse(w, http.StatusUnauthorized, Errorf(CodeUnauthorized, ErrUnauthorized))
+		return
+	}
+
+	var req Request
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+			writeResponse(w, http.StatusBadRequest, Errorf(CodeBadRequest, "invalid request format"))
+			return
+		}
+	}
+	req.Type = strings.TrimPrefix(r.URL.Path, APIPrefix)
+	req.Token = ""
+	resp := dispatch(s.handle, &req)
+	writeResponse(w, http.StatusOK, resp)
+	if resp.Events != nil {
+		streamHTTP(w, r, resp)
+	}
+}
+
+// streamHTTP writes resp.Events as JSON lines after the response, flushing
+// each, until the stream ends or the client goes away.
+func streamHTTP(w http.ResponseWriter, r *http.Request, resp *Response) {
+	defer resp.Unsubscribe()
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case ev, ok := <-resp.Events:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// remoteDenied are the requests only local clients may make.
+var remoteDenied = []string{RequestTypeShutdown, RequestTypeReload, RequestTypeGetChatID}
+
+// handle answers a request from a remote client. Remote clients can't
+// control the daemon, and can't attach files since attachments are paths
+// the daemon opens on its own disk.
+func (s *RemoteServer) handle(req *Request) *Response {
+	if slices.Contains(remoteDenied, req.Type) {
+		return Errorf(CodeForbidden, "%s requests are only accepted on the local socket", req.Type)
+	}
+	if len(req.Attachments) > 0 {
+		return Errorf(CodeUnsupported, "attachments are only accepted on the local socket")
+	}
+	resp := s.handler(req)
+	if req.Type == RequestTypeHello && resp.Hello != nil {
+		hello := *resp.Hello
+		hello.Capabilities = slices.DeleteFunc(slices.Clone(hello.Capabilities), func(c string) bool { return c == CapAttachments })
+		resp.Hello = &hello
+	}
+	return resp
+}
+
+// authenticated accepts a bearer token or a client certificate verified
+// against the client CA.
+func (s *RemoteServer) authenticated(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && s.validToken(token)
+}
+
+func (s *RemoteServer) validToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	valid := false
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func writeResponse(w http.ResponseWriter, code int, resp *Response) {
+	resp.Version = ProtocolVersion
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
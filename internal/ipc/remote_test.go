@@ -0,0 +1,264 @@
+package ipc
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+)
+
+const testToken = "remote-test-token-0123"
+
+func echo(req *Request) *Response {
+	if req.Token != "" {
+		return &Response{Success: false, Error: "token reached the handler"}
+	}
+	if req.Type == RequestTypeHello {
+		return &Response{Success: true, Hello: &Hello{Version: ProtocolVersion, MinVersion: MinProtocolVersion, Capabilities: []string{CapAttachments, CapChoices}}}
+	}
+	return &Response{Success: true, Reply: req.Type + ":" + req.Message}
+}
+
+func startRemote(t *testing.T, cfg config.RemoteConfig) *RemoteServer {
+	t.Helper()
+	return startRemoteTimeout(t, cfg, requestTimeout)
+}
+
+func startRemoteTimeout(t *testing.T, cfg config.RemoteConfig, readTimeout time.Duration) *RemoteServer {
+	t.Helper()
+	s, err := NewRemoteServer(cfg, echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.readTimeout = readTimeout
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		s.Stop()
+	})
+	return s
+}
+
+func expectReply(t *testing.T, c *Client, want string) {
+	t.Helper()
+	resp, err := c.Send(&Request{Type: RequestTypeSend, Message: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want == "" {
+		if resp.Success || resp.Error != ErrUnauthorized {
+			t.Fatalf("response = %+v, want %q", resp, ErrUnauthorized)
+		}
+		return
+	}
+	if !resp.Success || resp.Reply != want {
+		t.Fatalf("response = %+v, want reply %q", resp, want)
+	}
+}
+
+func TestRemoteTCP(t *testing.T) {
+	s := startRemote(t, config.RemoteConfig{Listen: "tcp://127.0.0.1:0", Tokens: []string{"other-token-abcdefgh", testToken}})
+
+	for token, want := range map[string]string{testToken: "send:hi", "wrong": "", "": ""} {
+		c, err := NewRemoteClient(s.URL(), RemoteOptions{Token: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.IsRunning() {
+			t.Fatal("remote daemon not running")
+		}
+		expectReply(t, c, want)
+	}
+}
+
+func TestRemoteTCPBoundsRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		readTimeout time.Duration
+		request     []byte
+	}{
+		{"stalled", 100 * time.Millisecond, []byte(`{"type":"send","token":`)},
+		{"oversized", time.Minute, bytes.Repeat([]byte("a"), maxRequestSize+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := startRemoteTimeout(t, config.RemoteConfig{Listen: "tcp://127.0.0.1:0", Tokens: []string{testToken}}, tt.readTimeout)
+			conn, err := net.Dial("tcp", s.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			go conn.Write(tt.request)
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, err := conn.Read(make([]byte, 1))
+			if n != 0 || err == nil {
+				t.Fatalf("read %d bytes, %v; want the connection closed", n, err)
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatal("daemon kept the connection open")
+			}
+		})
+	}
+}
+
+func TestRemoteRestrictions(t *testing.T) {
+	s := startRemote(t, config.RemoteConfig{Listen: "tcp://127.0.0.1:0", Tokens: []string{testToken}})
+	c, err := NewRemoteClient(s.URL(), RemoteOptions{Token: testToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		req  Request
+		code string
+	}{
+		{"shutdown", Request{Type: RequestTypeShutdown}, CodeForbidden},
+		{"reload", Request{Type: RequestTypeReload}, CodeForbidden},
+		{"get_chat_id", Request{Type: RequestTypeGetChatID}, CodeForbidden},
+		{"send with attachments", Request{Type: RequestTypeSend, Message: "hi", Attachments: []string{"/etc/passwd"}}, CodeUnsupported},
+		{"ask with attachments", Request{Type: RequestTypeAsk, Message: "hi", Attachments: []string{"/etc/passwd"}}, CodeUnsupported},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := c.Send(&tc.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Success || resp.Code != tc.code {
+				t.Fatalf("response = %+v, want code %s", resp, tc.code)
+			}
+		})
+	}
+
+	h, err := c.Hello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(h.Capabilities, CapAttachments) || !slices.Contains(h.Capabilities, CapChoices) {
+		t.Fatalf("remote hello capabilities = %v, want choices without attachments", h.Capabilities)
+	}
+}
+
+func TestRemoteHTTPS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "server", true)
+	clientCert, clientKey := ca.issue(t, dir, "client", false)
+
+	s := startRemote(t, config.RemoteConfig{
+		Listen:   "https://127.0.0.1:0",
+		Tokens:   []string{testToken},
+		TLSCert:  serverCert,
+		TLSKey:   serverKey,
+		ClientCA: ca.certFile,
+	})
+
+	for _, tc := range []struct {
+		name string
+		opts RemoteOptions
+		want string
+	}{
+		{"token", RemoteOptions{Token: testToken, CAFile: ca.certFile}, "send:hi"},
+		{"client certificate", RemoteOptions{CertFile: clientCert, KeyFile: clientKey, CAFile: ca.certFile}, "send:hi"},
+		{"wrong token", RemoteOptions{Token: "wrong", CAFile: ca.certFile}, ""},
+		{"nothing", RemoteOptions{CAFile: ca.certFile}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewRemoteClient(s.URL(), tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectReply(t, c, tc.want)
+		})
+	}
+
+	// Without the CA the daemon's certificate isn't trusted.
+	c, _ := NewRemoteClient(s.URL(), RemoteOptions{Token: testToken})
+	if _, err := c.Send(&Request{Type: RequestTypeStatus}); err == nil {
+		t.Fatal("request to a daemon with an untrusted certificate succeeded")
+	}
+}
+
+type testCA struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+}
+
+func newCA(t *testing.T, dir string) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	certFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, certFile: certFile}
+}
+
+// issue writes a certificate and key signed by the CA and returns their
+// paths.
+func (ca *testCA) issue(t *testing.T, dir, name string, server bool) (string, string) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
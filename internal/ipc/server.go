@@ -4,20 +4,39 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/systemd"
 )
 
+// stopTimeout bounds how long Stop waits for in-flight requests to be
+// answered.
+const stopTimeout = 5 * time.Second
+
 type RequestHandler func(req *Request) *Response
 
 type Server struct {
 	socketPath string
 	listener   net.Listener
+	lock       *os.File
 	handler    RequestHandler
+	allow      config.SocketConfig
+	conns      sync.WaitGroup
+
+	// inherited is the socket passed by systemd, looked up on the first
+	// Start. It belongs to the socket unit, so Stop leaves the file alone.
+	inherited        net.Listener
+	checkedInherited bool
 }
 
 func NewServer(socketPath string, handler RequestHandler) *Server {
@@ -27,24 +46,72 @@ func NewServer(socketPath string, handler RequestHandler) *Server {
 	}
 }
 
+// AllowPeers restricts the socket to peers matching rules. It must be
+// called before Start.
+func (s *Server) AllowPeers(rules config.SocketConfig) {
+	s.allow = rules
+}
+
+// Start takes the single-instance lock and listens on the socket, or uses
+// the socket passed by systemd socket activation. It fails with
+// ErrAlreadyRunning if another daemon holds the lock or answers on the
+// socket, rather than stealing the socket from it.
 func (s *Server) Start(ctx context.Context) error {
+	if s.allow.Restricted() && !peerCredentialsSupported {
+		return errors.New("socket allow rules need peer credentials, which are only supported on Linux")
+	}
+	if !s.checkedInherited {
+		s.checkedInherited = true
+		ln, err := inheritedListener(s.socketPath)
+		if err != nil {
+			return err
+		}
+		s.inherited = ln
+	}
+	if s.inherited != nil {
+		lock, err := acquireLock(s.socketPath + ".lock")
+		if err != nil {
+			return err
+		}
+		s.listener = s.inherited
+		s.lock = lock
+		go s.acceptLoop(ctx)
+		return nil
+	}
 	if err := os.MkdirAll(filepath.Dir(s.socketPath), 0700); err != nil {
 		return fmt.Errorf("creating socket directory: %w", err)
 	}
 
+	lock, err := acquireLock(s.socketPath + ".lock")
+	if err != nil {
+		return err
+	}
+
+	// A daemon from before the lock existed wouldn't hold it.
+	if conn, err := net.DialTimeout("unix", s.socketPath, time.Second); err == nil {
+		conn.Close()
+		releaseLock(lock)
+		return fmt.Errorf("%w: a daemon is answering on %s", ErrAlreadyRunning, s.socketPath)
+	}
+
 	if err := os.Remove(s.socketPath); err != nil && !os.IsNotExist(err) {
+		releaseLock(lock)
 		return fmt.Errorf("removing existing socket: %w", err)
 	}
 
 	listener, err := net.Listen("unix", s.socketPath)
 	if err != nil {
+		releaseLock(lock)
 		return fmt.Errorf("creating unix socket: %w", err)
 	}
-	s.listener = listener
-
 	if err := os.Chmod(s.socketPath, 0600); err != nil {
+		listener.Close()
+		os.Remove(s.socketPath)
+		releaseLock(lock)
 		return fmt.Errorf("setting socket permissions: %w", err)
 	}
+	s.listener = listener
+	s.lock = lock
 
 	go s.acceptLoop(ctx)
 
@@ -59,47 +126,144 @@ func (s *Server) acceptLoop(ctx context.Context) {
 			case <-ctx.Done():
 				return
 			default:
-				continue
 			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
 		}
 
-		go s.handleConnection(conn)
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			s.serveLocal(conn)
+		}()
+	}
+}
+
+// serveLocal answers a socket connection, checking the peer against the
+// allow rules and logging who made the request.
+func (s *Server) serveLocal(conn net.Conn) {
+	peer, err := peerCredentials(conn)
+	who := peer.String()
+	if err != nil {
+		who = "unknown peer"
+		if s.allow.Restricted() {
+			log.Printf("ipc: rejecting connection: %v", err)
+			conn.Close()
+			return
+		}
 	}
+
+	serveConn(conn, conn, func(req *Request) *Response {
+		if s.allow.Restricted() && !allowedPeer(s.allow, peer) {
+			log.Printf("ipc: rejecting %s request from %s: not allowed by socket rules", req.Type, who)
+			return Errorf(CodeForbidden, ErrPeerNotAllowed)
+		}
+		log.Printf("ipc: %s request from %s", req.Type, who)
+		return s.handler(req)
+	})
 }
 
-func (s *Server) handleConnection(conn net.Conn) {
+// serveConn answers the one request read from r, which is conn or a
+// limited view of it, and writes the response to conn.
+func serveConn(conn net.Conn, r io.Reader, handler RequestHandler) {
 	defer conn.Close()
 
-	reader := bufio.NewReader(conn)
+	reader := bufio.NewReader(r)
 	line, err := reader.ReadBytes('\n')
 	if err != nil {
 		return
 	}
+	// An event stream stays open for as long as the client wants it.
+	conn.SetReadDeadline(time.Time{})
 
 	var req Request
 	if err := json.Unmarshal(line, &req); err != nil {
-		s.sendResponse(conn, &Response{
-			Success: false,
-			Error:   "invalid request format",
-		})
+		sendResponse(conn, Errorf(CodeBadRequest, "invalid request format"))
 		return
 	}
 
-	resp := s.handler(&req)
-	s.sendResponse(conn, resp)
+	resp := dispatch(handler, &req)
+	sendResponse(conn, resp)
+	if resp.Events != nil {
+		streamEvents(conn, reader, resp)
+	}
+}
+
+// streamEvents writes resp.Events to conn until the stream ends or the
+// client hangs up.
+func streamEvents(conn net.Conn, reader *bufio.Reader, resp *Response) {
+	defer resp.Unsubscribe()
+
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, reader)
+		close(gone)
+	}()
+
+	enc := json.NewEncoder(conn)
+	for {
+		select {
+		case ev, ok := <-resp.Events:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
 }
 
-func (s *Server) sendResponse(conn net.Conn, resp *Response) {
+// minClientVersion is MinProtocolVersion, raised by tests.
+var minClientVersion = MinProtocolVersion
+
+// dispatch answers req unless it comes from a client too old for this
+// daemon. Clients newer than the daemon are answered too: they check the
+// hello capabilities before relying on anything the daemon may lack.
+func dispatch(handler RequestHandler, req *Request) *Response {
+	version := req.Version
+	if version == 0 {
+		version = 1
+	}
+	if version < minClientVersion {
+		return Errorf(CodeVersionMismatch, "this cctg speaks protocol %d but the daemon needs at least %d; upgrade cctg", version, minClientVersion)
+	}
+	return handler(req)
+}
+
+func sendResponse(conn net.Conn, resp *Response) {
+	resp.Version = ProtocolVersion
 	data, _ := json.Marshal(resp)
 	data = append(data, '\n')
 	conn.Write(data)
 }
 
+// Stop closes the socket, gives in-flight requests a moment to be answered
+// and releases the single-instance lock.
 func (s *Server) Stop() error {
-	if s.listener != nil {
-		s.listener.Close()
+	if s.listener == nil {
+		return nil
+	}
+	s.listener.Close()
+	if s.inherited == nil {
+		os.Remove(s.socketPath)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
 	}
-	os.Remove(s.socketPath)
+
+	releaseLock(s.lock)
 	return nil
 }
 
@@ -107,6 +271,34 @@ func (s *Server) SocketPath() string {
 	return s.socketPath
 }
 
+// Activated reports whether the socket was passed by systemd.
+func (s *Server) Activated() bool {
+	return s.inherited != nil
+}
+
+// inheritedListener returns the socket for socketPath passed by systemd
+// socket activation, or nil if there is none.
+func inheritedListener(socketPath string) (net.Listener, error) {
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	var found net.Listener
+	var others []string
+	for _, ln := range listeners {
+		if addr, ok := ln.Addr().(*net.UnixAddr); ok && addr.Name == socketPath && found == nil {
+			found = ln
+			continue
+		}
+		others = append(others, ln.Addr().String())
+		ln.Close()
+	}
+	if found == nil && len(others) > 0 {
+		return nil, fmt.Errorf("socket activation passed %s, but the daemon's socket is %s; fix ListenStream= in the socket unit", strings.Join(others, ", "), socketPath)
+	}
+	return found, nil
+}
+
 func DefaultSocketPath() string {
 	return config.GetSocketPath()
 }
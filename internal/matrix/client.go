@@ -0,0 +1,203 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/httpclient"
+)
+
+// client covers the handful of client-server API endpoints the transport
+// uses.
+type client struct {
+	homeserver string
+	token      string
+	http       *http.Client
+	// timeout bounds each request; /sync adds its long-poll time.
+	timeout time.Duration
+
+	txnPrefix string
+	txn       atomic.Int64
+}
+
+// Error is an error response from the homeserver.
+type Error struct {
+	Status     int    `json:"-"`
+	Code       string `json:"errcode"`
+	Message    string `json:"error"`
+	RetryAfter int64  `json:"retry_after_ms"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("matrix: %s: %s (HTTP %d)", e.Code, e.Message, e.Status)
+}
+
+func newClient(cfg config.MatrixConfig) (*client, error) {
+	transport, err := httpclient.Transport(cfg.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("matrix.proxy: %w", err)
+	}
+	return &client{
+		homeserver: strings.TrimRight(cfg.Homeserver, "/"),
+		token:      cfg.AccessToken,
+		http:       &http.Client{Transport: transport},
+		timeout:    httpclient.Timeout(cfg.RequestTimeout),
+		txnPrefix:  strconv.FormatInt(time.Now().UnixNano(), 36),
+	}, nil
+}
+
+type syncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []event `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+type event struct {
+	Type           string       `json:"type"`
+	EventID        string       `json:"event_id"`
+	Sender         string       `json:"sender"`
+	OriginServerTS int64        `json:"origin_server_ts"`
+	Content        eventContent `json:"content"`
+}
+
+type eventContent struct {
+	MsgType   string `json:"msgtype"`
+	Body      string `json:"body"`
+	RelatesTo *struct {
+		RelType   string `json:"rel_type"`
+		InReplyTo *struct {
+			EventID string `json:"event_id"`
+		} `json:"m.in_reply_to"`
+	} `json:"m.relates_to"`
+}
+
+// syncFilter keeps /sync responses down to room messages.
+const syncFilter = `{"presence":{"not_types":["*"]},"account_data":{"not_types":["*"]},"room":{"ephemeral":{"not_types":["*"]},"state":{"not_types":["*"]},"timeline":{"types":["m.room.message"]}}}`
+
+func (c *client) whoami(ctx context.Context) (string, error) {
+	var out struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/_matrix/client/v3/account/whoami", nil, nil, &out); err != nil {
+		return "", err
+	}
+	return out.UserID, nil
+}
+
+func (c *client) join(ctx context.Context, room string) error {
+	return c.doJSON(ctx, http.MethodPost, "/_matrix/client/v3/join/"+url.PathEscape(room), nil, struct{}{}, nil)
+}
+
+// sync long-polls for events after since, for up to timeout.
+func (c *client) sync(ctx context.Context, since string, timeout time.Duration) (*syncResponse, error) {
+	q := url.Values{
+		"timeout": {strconv.FormatInt(timeout.Milliseconds(), 10)},
+		"filter":  {syncFilter},
+	}
+	if since != "" {
+		q.Set("since", since)
+	}
+	var out syncResponse
+	if err := c.doJSON(ctx, http.MethodGet, "/_matrix/client/v3/sync", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// send sends an event to room and returns its ID. Rate limited requests are
+// retried after the delay the server asks for.
+func (c *client) send(ctx context.Context, room, eventType string, content any) (string, error) {
+	txn := c.txnPrefix + "-" + strconv.FormatInt(c.txn.Add(1), 10)
+	path := "/_matrix/client/v3/rooms/" + url.PathEscape(room) + "/send/" + eventType + "/" + txn
+
+	for {
+		var out struct {
+			EventID string `json:"event_id"`
+		}
+		err := c.doJSON(ctx, http.MethodPut, path, nil, content, &out)
+		mxErr, ok := err.(*Error)
+		if !ok || mxErr.Code != "M_LIMIT_EXCEEDED" {
+			return out.EventID, err
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Duration(max(mxErr.RetryAfter, 1000)) * time.Millisecond):
+		}
+	}
+}
+
+// upload stores data in the media repository and returns its mxc:// URI.
+func (c *client) upload(ctx context.Context, name, contentType string, data io.Reader) (string, error) {
+	var out struct {
+		ContentURI string `json:"content_uri"`
+	}
+	q := url.Values{"filename": {name}}
+	if err := c.do(ctx, http.MethodPost, "/_matrix/media/v3/upload", q, contentType, data, &out); err != nil {
+		return "", err
+	}
+	return out.ContentURI, nil
+}
+
+func (c *client) doJSON(ctx context.Context, method, path string, q url.Values, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	return c.do(ctx, method, path, q, "application/json", body, out)
+}
+
+func (c *client) do(ctx context.Context, method, path string, q url.Values, contentType string, body io.Reader, out any) error {
+	u := c.homeserver + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		mxErr := &Error{Status: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(mxErr); err != nil || mxErr.Code == "" {
+			mxErr.Code, mxErr.Message = "M_UNKNOWN", resp.Status
+		}
+		return mxErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s response: %w", path, err)
+	}
+	return nil
+}
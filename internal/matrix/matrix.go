@@ -0,0 +1,325 @@
+// Package matrix is the Matrix transport. Each session talks in its own
+// room, and answers are matched to questions by in-reply-to relations.
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/session"
+	"github.com/bupd/go-claude-code-telegram/internal/state"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+const (
+	syncTimeout = 30 * time.Second
+	minBackoff  = time.Second
+	maxBackoff  = 2 * time.Minute
+)
+
+var _ transport.Transport = (*Transport)(nil)
+
+type Transport struct {
+	client   *client
+	sessions *session.Manager
+	store    *state.Store
+	incoming chan transport.Incoming
+
+	// ready is closed once the access token has been checked; userID is
+	// set by then.
+	ready  chan struct{}
+	userID string
+}
+
+// New prepares the Matrix transport without contacting the homeserver; that
+// happens in Start. The /sync position is kept in store so a restart picks
+// up where it left off.
+func New(cfg *config.Config, sessions *session.Manager, store *state.Store) (*Transport, error) {
+	if cfg.Matrix.Homeserver == "" {
+		return nil, errors.New("matrix.homeserver is not set")
+	}
+	if cfg.Matrix.AccessToken == "" {
+		return nil, errors.New("matrix access token is not set (use matrix.access_token or MATRIX_ACCESS_TOKEN)")
+	}
+
+	client, err := newClient(cfg.Matrix)
+	if err != nil {
+		return nil, err
+	}
+	return &Transport{
+		client:   client,
+		sessions: sessions,
+		store:    store,
+		incoming: make(chan transport.Incoming, 100),
+		ready:    make(chan struct{}),
+	}, nil
+}
+
+func (t *Transport) Name() string {
+	return transport.Matrix
+}
+
+func (t *Transport) Capabilities() transport.Capabilities {
+	return transport.Capabilities{
+		Edit:        true,
+		Attachments: true,
+		Replies:     true,
+		Formats:     []string{config.FormatHTML},
+	}
+}
+
+func (t *Transport) Incoming() <-chan transport.Incoming {
+	return t.incoming
+}
+
+// Start logs in, joins the session rooms and runs the /sync loop until ctx
+// is done. A rejected access token is fatal; anything else is retried.
+func (t *Transport) Start(ctx context.Context) error {
+	userID, err := t.login(ctx)
+	if err != nil || userID == "" {
+		return err
+	}
+	t.userID = userID
+	close(t.ready)
+	log.Printf("connected to matrix as %s", userID)
+
+	for _, room := range t.rooms() {
+		reqCtx, cancel := context.WithTimeout(ctx, t.client.timeout)
+		if err := t.client.join(reqCtx, room); err != nil {
+			log.Printf("matrix: joining %s: %v", room, err)
+		}
+		cancel()
+	}
+
+	since := t.store.MatrixSince()
+	backoff := minBackoff
+	for ctx.Err() == nil {
+		// The first sync only finds our place; old messages aren't answers.
+		timeout := syncTimeout
+		if since == "" {
+			timeout = 0
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, timeout+t.client.timeout)
+		resp, err := t.client.sync(reqCtx, since, timeout)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("matrix sync failed, retrying in %s: %v", backoff, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+		backoff = minBackoff
+
+		if since != "" {
+			for room, joined := range resp.Rooms.Join {
+				for _, ev := range joined.Timeline.Events {
+					t.handleEvent(ctx, room, ev)
+				}
+			}
+		}
+		since = resp.NextBatch
+		if err := t.store.SetMatrixSince(since); err != nil {
+			log.Printf("saving matrix sync token: %v", err)
+		}
+	}
+	return nil
+}
+
+func (t *Transport) login(ctx context.Context) (string, error) {
+	backoff := minBackoff
+	for {
+		reqCtx, cancel := context.WithTimeout(ctx, t.client.timeout)
+		userID, err := t.client.whoami(reqCtx)
+		cancel()
+		if err == nil {
+			return userID, nil
+		}
+
+		var mxErr *Error
+		if errors.As(err, &mxErr) && mxErr.Status == http.StatusUnauthorized {
+			return "", fmt.Errorf("matrix rejected the access token: %w", err)
+		}
+
+		log.Printf("cannot reach matrix homeserver, retrying in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return "", nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// rooms returns the rooms of the sessions using Matrix.
+func (t *Transport) rooms() []string {
+	var rooms []string
+	for _, sess := range t.sessions.Config().Sessions {
+		if sess.TransportName() == transport.Matrix && !slices.Contains(rooms, sess.Room) {
+			rooms = append(rooms, sess.Room)
+		}
+	}
+	return rooms
+}
+
+// handleEvent passes text messages from allowed users on to Incoming.
+func (t *Transport) handleEvent(ctx context.Context, room string, ev event) {
+	if ev.Type != "m.room.message" || ev.Sender == t.userID || !t.isAllowedUser(ev.Sender) {
+		return
+	}
+
+	in := transport.Incoming{
+		Chat: transport.Address{Transport: transport.Matrix, Chat: room},
+		ID:   ev.EventID,
+		User: ev.Sender,
+		Text: ev.Content.Body,
+		Time: time.UnixMilli(ev.OriginServerTS),
+	}
+	if rel := ev.Content.RelatesTo; rel != nil {
+		if rel.RelType == "m.replace" {
+			// An edit of an earlier message, which was already routed.
+			return
+		}
+		if rel.InReplyTo != nil {
+			in.ReplyTo = rel.InReplyTo.EventID
+			in.Text = stripReplyFallback(in.Text)
+		}
+	}
+
+	select {
+	case t.incoming <- in:
+	case <-ctx.Done():
+	}
+}
+
+func (t *Transport) isAllowedUser(mxid string) bool {
+	return slices.Contains(t.sessions.Config().Matrix.AllowedUsers, mxid)
+}
+
+// stripReplyFallback removes the "> <@user> quoted text" lines clients put
+// in front of a reply's body.
+func stripReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], ">") {
+		i++
+	}
+	if i == 0 {
+		return body
+	}
+	return strings.TrimSpace(strings.Join(lines[i:], "\n"))
+}
+
+// Send posts msg to the room chat and returns its event ID. Attachments are
+// uploaded and sent as files replying to the text.
+func (t *Transport) Send(ctx context.Context, chat string, msg transport.Message) (string, error) {
+	select {
+	case <-t.ready:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	content := map[string]any{"msgtype": "m.text", "body": msg.Text}
+	if msg.Format == config.FormatHTML {
+		content["body"] = plainText(msg.Text)
+		content["format"] = "org.matrix.custom.html"
+		content["formatted_body"] = msg.Text
+	}
+	id, err := t.client.send(ctx, chat, "m.room.message", content)
+	if err != nil {
+		return "", fmt.Errorf("sending message: %w", err)
+	}
+
+	for _, a := range msg.Attachments {
+		if err := t.sendFile(ctx, chat, id, a.Path); err != nil {
+			return "", fmt.Errorf("sending %s: %w", a.Path, err)
+		}
+	}
+	return id, nil
+}
+
+func (t *Transport) sendFile(ctx context.Context, room, replyTo, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(path)
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	uri, err := t.client.upload(ctx, name, contentType, f)
+	if err != nil {
+		return fmt.Errorf("uploading: %w", err)
+	}
+
+	_, err = t.client.send(ctx, room, "m.room.message", map[string]any{
+		"msgtype":  "m.file",
+		"body":     name,
+		"filename": name,
+		"url":      uri,
+		"info":     map[string]any{"size": info.Size(), "mimetype": contentType},
+		"m.relates_to": map[string]any{
+			"m.in_reply_to": map[string]any{"event_id": replyTo},
+		},
+	})
+	return err
+}
+
+// Edit replaces the text of event id with an m.replace relation.
+func (t *Transport) Edit(ctx context.Context, chat, id, text string) error {
+	select {
+	case <-t.ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	_, err := t.client.send(ctx, chat, "m.room.message", map[string]any{
+		"msgtype":       "m.text",
+		"body":          "* " + text,
+		"m.new_content": map[string]any{"msgtype": "m.text", "body": text},
+		"m.relates_to":  map[string]any{"rel_type": "m.replace", "event_id": id},
+	})
+	if err != nil {
+		return fmt.Errorf("editing message: %w", err)
+	}
+	return nil
+}
+
+var (
+	htmlBreak = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6]|pre|blockquote)>`)
+	htmlTag   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// plainText is the body of an HTML message, for clients and notifications
+// that don't render formatted_body.
+func plainText(s string) string {
+	s = htmlBreak.ReplaceAllString(s, "\n")
+	s = htmlTag.ReplaceAllString(s, "")
+	return strings.TrimSpace(html.UnescapeString(s))
+}
@@ -0,0 +1,367 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/session"
+	"github.com/bupd/go-claude-code-telegram/internal/state"
+	"github.com/bupd/go-claude-code-telegram/internal/testutil"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+const (
+	testToken = "syt_test"
+	botUser   = "@cctg:example.org"
+	alice     = "@alice:example.org"
+	room      = "!abc:example.org"
+)
+
+// homeserver is a stub implementing the client-server endpoints the
+// transport uses. The position in its single event list is the sync token.
+type homeserver struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	events  []map[string]any
+	joined  []string
+	uploads []string
+	changed chan struct{}
+}
+
+func newHomeserver(t *testing.T) *homeserver {
+	hs := &homeserver{changed: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_matrix/client/v3/account/whoami", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"user_id": botUser})
+	})
+	mux.HandleFunc("POST /_matrix/client/v3/join/{room}", func(w http.ResponseWriter, r *http.Request) {
+		hs.mu.Lock()
+		hs.joined = append(hs.joined, r.PathValue("room"))
+		hs.mu.Unlock()
+		writeJSON(w, map[string]any{"room_id": r.PathValue("room")})
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/sync", hs.sync)
+	mux.HandleFunc("PUT /_matrix/client/v3/rooms/{room}/send/{type}/{txn}", func(w http.ResponseWriter, r *http.Request) {
+		var content map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id := hs.post(r.PathValue("room"), botUser, content)
+		writeJSON(w, map[string]any{"event_id": id})
+	})
+	mux.HandleFunc("POST /_matrix/media/v3/upload", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		hs.mu.Lock()
+		hs.uploads = append(hs.uploads, r.URL.Query().Get("filename")+":"+string(data))
+		hs.mu.Unlock()
+		writeJSON(w, map[string]any{"content_uri": "mxc://example.org/file"})
+	})
+
+	hs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			writeJSON(w, map[string]any{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid access token"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(hs.Close)
+	return hs
+}
+
+// post adds a message event to room and returns its ID.
+func (hs *homeserver) post(room, sender string, content map[string]any) string {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	id := "$" + strconv.Itoa(len(hs.events))
+	hs.events = append(hs.events, map[string]any{
+		"room":             room,
+		"type":             "m.room.message",
+		"event_id":         id,
+		"sender":           sender,
+		"origin_server_ts": time.Now().UnixMilli(),
+		"content":          content,
+	})
+	close(hs.changed)
+	hs.changed = make(chan struct{})
+	return id
+}
+
+func (hs *homeserver) sync(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+	timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+
+	hs.mu.Lock()
+	if len(hs.events) == since && timeout > 0 {
+		changed := hs.changed
+		hs.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(time.Duration(timeout) * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		hs.mu.Lock()
+	}
+	events := hs.events[since:]
+	next := len(hs.events)
+	hs.mu.Unlock()
+
+	byRoom := map[string][]map[string]any{}
+	for _, ev := range events {
+		room := ev["room"].(string)
+		byRoom[room] = append(byRoom[room], ev)
+	}
+	join := map[string]any{}
+	for room, events := range byRoom {
+		join[room] = map[string]any{"timeline": map[string]any{"events": events}}
+	}
+	writeJSON(w, map[string]any{
+		"next_batch": strconv.Itoa(next),
+		"rooms":      map[string]any{"join": join},
+	})
+}
+
+// sent returns the content of the events the bot sent.
+func (hs *homeserver) sent() []map[string]any {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	var out []map[string]any
+	for _, ev := range hs.events {
+		if ev["sender"] == botUser {
+			out = append(out, ev["content"].(map[string]any))
+		}
+	}
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+type fixture struct {
+	hs    *homeserver
+	tr    *Transport
+	store *state.Store
+	errCh chan error
+}
+
+func start(t *testing.T, token string) *fixture {
+	t.Helper()
+	hs := newHomeserver(t)
+	cfg := &config.Config{
+		Matrix: config.MatrixConfig{
+			Homeserver:   hs.URL,
+			AccessToken:  token,
+			AllowedUsers: []string{alice},
+		},
+		Sessions: []config.SessionConfig{{Name: "proj", Transport: transport.Matrix, Room: room}},
+	}
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := New(cfg, session.NewManager(cfg), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fixture{hs: hs, tr: tr, store: store, errCh: testutil.Start(t, tr.Start)}
+}
+
+// synced waits until the first sync has stored its token, so later events
+// count as new.
+func (f *fixture) synced(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for f.store.MatrixSince() == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("first sync did not happen")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (f *fixture) next(t *testing.T) transport.Incoming {
+	t.Helper()
+	select {
+	case in := <-f.tr.Incoming():
+		return in
+	case <-time.After(5 * time.Second):
+		t.Fatal("no incoming message")
+		return transport.Incoming{}
+	}
+}
+
+func TestReplyMatching(t *testing.T) {
+	f := start(t, testToken)
+	f.hs.post(room, alice, map[string]any{"msgtype": "m.text", "body": "from before the daemon started"})
+	f.synced(t)
+
+	id, err := f.tr.Send(context.Background(), room, transport.Message{Text: "Deploy?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.hs.post(room, "@mallory:example.org", map[string]any{"msgtype": "m.text", "body": "yes"})
+	f.hs.post(room, alice, map[string]any{
+		"msgtype": "m.text",
+		"body":    "> <@cctg:example.org> Deploy?\n\nno, wait",
+		"m.relates_to": map[string]any{
+			"m.in_reply_to": map[string]any{"event_id": id},
+		},
+	})
+
+	in := f.next(t)
+	want := transport.Address{Transport: transport.Matrix, Chat: room}
+	if in.Chat != want || in.User != alice || in.ReplyTo != id || in.Text != "no, wait" {
+		t.Fatalf("incoming = %+v, want a reply from %s to %s in %s saying %q", in, alice, id, want, "no, wait")
+	}
+
+	f.hs.mu.Lock()
+	joined := f.hs.joined
+	f.hs.mu.Unlock()
+	if len(joined) != 1 || joined[0] != room {
+		t.Errorf("joined %v, want [%s]", joined, room)
+	}
+}
+
+func TestSkipsEdits(t *testing.T) {
+	f := start(t, testToken)
+	f.synced(t)
+
+	f.hs.post(room, alice, map[string]any{
+		"msgtype":       "m.text",
+		"body":          "* fixed typo",
+		"m.new_content": map[string]any{"msgtype": "m.text", "body": "fixed typo"},
+		"m.relates_to":  map[string]any{"rel_type": "m.replace", "event_id": "$0"},
+	})
+	f.hs.post(room, alice, map[string]any{"msgtype": "m.text", "body": "plain"})
+
+	if in := f.next(t); in.Text != "plain" || in.ReplyTo != "" {
+		t.Fatalf("incoming = %+v, want the plain message", in)
+	}
+}
+
+func TestSendAttachmentAndEdit(t *testing.T) {
+	f := start(t, testToken)
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "plan.md")
+	if err := os.WriteFile(path, []byte("# plan"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	id, err := f.tr.Send(ctx, room, transport.Message{
+		Text:        "<b>Review?</b>",
+		Format:      config.FormatHTML,
+		Attachments: []transport.Attachment{{Path: path}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.tr.Edit(ctx, room, id, "Review? (answered)"); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := f.hs.sent()
+	if len(sent) != 3 {
+		t.Fatalf("sent %d events, want 3: %v", len(sent), sent)
+	}
+	if sent[0]["formatted_body"] != "<b>Review?</b>" || sent[0]["format"] != "org.matrix.custom.html" || sent[0]["body"] != "Review?" {
+		t.Errorf("question = %v, want html", sent[0])
+	}
+	file := sent[1]
+	replyTo := file["m.relates_to"].(map[string]any)["m.in_reply_to"].(map[string]any)["event_id"]
+	if file["msgtype"] != "m.file" || file["url"] != "mxc://example.org/file" || replyTo != id {
+		t.Errorf("attachment = %v, want an m.file replying to %s", file, id)
+	}
+	f.hs.mu.Lock()
+	uploads := f.hs.uploads
+	f.hs.mu.Unlock()
+	if len(uploads) != 1 || uploads[0] != "plan.md:# plan" {
+		t.Errorf("uploads = %v", uploads)
+	}
+	edit := sent[2]
+	rel := edit["m.relates_to"].(map[string]any)
+	newContent := edit["m.new_content"].(map[string]any)
+	if rel["rel_type"] != "m.replace" || rel["event_id"] != id || newContent["body"] != "Review? (answered)" {
+		t.Errorf("edit = %v, want an m.replace of %s", edit, id)
+	}
+}
+
+func TestResumesFromStoredSyncToken(t *testing.T) {
+	f := start(t, testToken)
+	f.synced(t)
+	f.hs.post(room, alice, map[string]any{"msgtype": "m.text", "body": "one"})
+	f.next(t)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for f.store.MatrixSince() != "1" {
+		if time.Now().After(deadline) {
+			t.Fatalf("sync token = %q, want 1", f.store.MatrixSince())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRejectedToken(t *testing.T) {
+	f := start(t, "wrong")
+	if err := testutil.Stopped(t, f.errCh); err == nil || !strings.Contains(err.Error(), "M_UNKNOWN_TOKEN") {
+		t.Fatalf("Start = %v, want an unknown token error", err)
+	}
+}
+
+func TestStripReplyFallback(t *testing.T) {
+	tests := map[string]string{
+		"plain":                             "plain",
+		"> <@a:b> question\n\nanswer":       "answer",
+		"> <@a:b> line one\n> line two\nok": "ok",
+		"answer\n> not a fallback":          "answer\n> not a fallback",
+	}
+	for body, want := range tests {
+		if got := stripReplyFallback(body); got != want {
+			t.Errorf("stripReplyFallback(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{"Deploy?", "Deploy?"},
+		{"<b>Deploy</b> to <code>prod</code>?", "Deploy to prod?"},
+		{"line one<br>line two<br/>three", "line one\nline two\nthree"},
+		{"<p>first</p><p>second</p>", "first\nsecond"},
+		{"<ul><li>a</li><li>b</li></ul>", "a\nb"},
+		{"x &lt; y &amp;&amp; y &gt; z", "x < y && y > z"},
+		{`<a href="https://example.org">the PR</a>`, "the PR"},
+	}
+	for _, tt := range tests {
+		if got := plainText(tt.html); got != tt.want {
+			t.Errorf("plainText(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
+
+func TestProxyIsValidated(t *testing.T) {
+	cfg := &config.Config{Matrix: config.MatrixConfig{Homeserver: "https://matrix.example.org", AccessToken: testToken, Proxy: "ftp://proxy"}}
+	if _, err := New(cfg, session.NewManager(cfg), nil); err == nil || !strings.Contains(err.Error(), "matrix.proxy") {
+		t.Fatalf("New with an ftp proxy = %v, want a matrix.proxy error", err)
+	}
+}
@@ -0,0 +1,144 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+// Event types.
+const (
+	EventAsked    = "asked"
+	EventAnswered = "answered"
+	// EventExpired is a question that timed out without an answer.
+	EventExpired = "expired"
+	// EventCancelled is a question withdrawn by the asker.
+	EventCancelled = "cancelled"
+	// EventQueued is a message that arrived while nothing was pending; it is
+	// handed to the next question in the chat.
+	EventQueued = "queued"
+	// EventCommand is a bot command, such as /status on Telegram. The
+	// message is still routed like any other.
+	EventCommand = "command"
+	// EventConnection is a change in a transport's connection state.
+	EventConnection = "connection"
+)
+
+// SourceDashboard is the Event.Source of answers given in the dashboard.
+const SourceDashboard = "dashboard"
+
+// Event is something that happened to a question or chat.
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Session   string    `json:"session,omitempty"`
+	Transport string    `json:"transport"`
+	Chat      string    `json:"chat"`
+	// QuestionID is the PendingMessage.ID of the question concerned.
+	QuestionID string   `json:"question_id,omitempty"`
+	Question   string   `json:"question,omitempty"`
+	Choices    []string `json:"choices,omitempty"`
+	// Text is the answer, queued message or command, or for connection
+	// events the last error.
+	Text string `json:"text,omitempty"`
+	// Source is where an answer came from, a transport name or
+	// SourceDashboard, or the user who sent a command.
+	Source string `json:"source,omitempty"`
+	// State is the new connection state, with Text as the last error.
+	State string `json:"state,omitempty"`
+}
+
+const subscriberBuffer = 64
+
+type bus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Subscribe returns a channel of events from now on and a function that
+// ends the subscription. Events are dropped for subscribers that fall more
+// than a few dozen behind.
+func (m *Manager) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	m.events.mu.Lock()
+	if m.events.subs == nil {
+		m.events.subs = make(map[chan Event]struct{})
+	}
+	m.events.subs[ch] = struct{}{}
+	m.events.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			m.events.mu.Lock()
+			delete(m.events.subs, ch)
+			m.events.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (m *Manager) publish(ev Event) {
+	ev.Time = time.Now()
+
+	m.events.mu.Lock()
+	defer m.events.mu.Unlock()
+	for ch := range m.events.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func questionEvent(typ string, pm *PendingMessage) Event {
+	return Event{
+		Type:       typ,
+		Session:    pm.Session,
+		Transport:  pm.Addr.Transport,
+		Chat:       pm.Addr.Chat,
+		QuestionID: pm.ID,
+		Question:   pm.Content,
+		Choices:    pm.Choices,
+	}
+}
+
+// sessionFor returns the name of the first session asking in addr, for
+// events that aren't about a particular question.
+func (m *Manager) sessionFor(addr transport.Address) string {
+	for _, sess := range m.Config().Sessions {
+		if sess.Address() == addr {
+			return sess.Name
+		}
+	}
+	return ""
+}
+
+func (m *Manager) publishQueued(addr transport.Address, text string) {
+	m.publish(Event{
+		Type:      EventQueued,
+		Session:   m.sessionFor(addr),
+		Transport: addr.Transport,
+		Chat:      addr.Chat,
+		Text:      text,
+	})
+}
+
+func (m *Manager) publishCommand(in transport.Incoming) {
+	m.publish(Event{
+		Type:      EventCommand,
+		Session:   m.sessionFor(in.Chat),
+		Transport: in.Chat.Transport,
+		Chat:      in.Chat.Chat,
+		Text:      in.Text,
+		Source:    in.User,
+	})
+}
+
+// PublishConnection reports a transport's new connection state, with the
+// error that caused it if any.
+func (m *Manager) PublishConnection(name, state, lastError string) {
+	m.publish(Event{Type: EventConnection, Transport: name, State: state, Text: lastError})
+}
@@ -1,146 +1,429 @@
 package session
 
 import (
+	"log"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/state"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
 )
 
 type PendingMessage struct {
-	ID         string
-	TgMsgID    int
+	ID      string
+	Addr    transport.Address
+	Session string
+	// MsgID is the transport's ID for the question message.
+	MsgID      string
 	Content    string
+	Choices    []string
 	ResponseCh chan string
 	CreatedAt  time.Time
+	Deadline   time.Time
+	// Source is where the answer came from, set before it is sent on
+	// ResponseCh.
+	Source string
+}
+
+// Question is what AddPending needs to know about a question besides where
+// it was sent.
+type Question struct {
+	Session string
+	Content string
+	Choices []string
+}
+
+// ChatStats summarizes the questions and messages waiting in one chat.
+type ChatStats struct {
+	Pending       int
+	Queued        int
+	OldestPending time.Time
 }
 
 type ChatIDCapture struct {
-	ResponseCh chan int64
+	ResponseCh chan transport.Address
 }
 
 type Manager struct {
-	config        *config.Config
-	pending       map[int64][]*PendingMessage // keyed by chat_id
-	queuedMsgs    map[int64][]string          // messages sent when no pending
-	chatIDCapture *ChatIDCapture              // pending chat ID capture request
+	config        atomic.Pointer[config.Config]
+	pending       map[transport.Address][]*PendingMessage
+	queuedMsgs    map[transport.Address][]string // messages sent when no pending
+	chatIDCapture *ChatIDCapture                 // pending chat ID capture request
+	store         *state.Store                   // persists pending questions, may be nil
+	recovered     map[transport.Address][]state.PendingRecord
 	mu            sync.RWMutex
 	idSeq         int64
+	events        bus
 }
 
 func NewManager(cfg *config.Config) *Manager {
-	return &Manager{
-		config:     cfg,
-		pending:    make(map[int64][]*PendingMessage),
-		queuedMsgs: make(map[int64][]string),
+	m := &Manager{
+		pending:    make(map[transport.Address][]*PendingMessage),
+		queuedMsgs: make(map[transport.Address][]string),
+		recovered:  make(map[transport.Address][]state.PendingRecord),
 	}
+	m.config.Store(cfg)
+	return m
 }
 
+// Config returns the current config. It may be swapped at any time by a
+// reload, so callers should fetch it once per operation.
 func (m *Manager) Config() *config.Config {
-	return m.config
+	return m.config.Load()
+}
+
+// SetConfig atomically replaces the config. Pending questions and queued
+// messages are kept.
+func (m *Manager) SetConfig(cfg *config.Config) {
+	m.config.Store(cfg)
+}
+
+// SetStore makes the manager persist pending questions to store. Questions
+// left in the store by a previous daemon that haven't reached their deadline
+// are kept as recovered questions, so late replies to them can still be
+// routed.
+func (m *Manager) SetStore(store *state.Store) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.store = store
+	now := time.Now()
+	for _, rec := range store.Pending() {
+		if rec.Deadline.After(now) {
+			addr := transport.Address{Transport: rec.Transport, Chat: rec.Chat}
+			m.recovered[addr] = append(m.recovered[addr], rec)
+		}
+	}
+	m.persist()
+}
+
+// persist writes live and recovered questions to the store. The caller must
+// hold m.mu.
+func (m *Manager) persist() {
+	if m.store == nil {
+		return
+	}
+
+	var records []state.PendingRecord
+	for addr, queue := range m.pending {
+		for _, pm := range queue {
+			records = append(records, state.PendingRecord{
+				Transport: addr.Transport,
+				Chat:      addr.Chat,
+				MsgID:     pm.MsgID,
+				Content:   pm.Content,
+				CreatedAt: pm.CreatedAt,
+				Deadline:  pm.Deadline,
+			})
+		}
+	}
+	for _, recs := range m.recovered {
+		records = append(records, recs...)
+	}
+
+	if err := m.store.SetPending(records); err != nil {
+		log.Printf("persisting pending questions: %v", err)
+	}
 }
 
-func (m *Manager) AddPending(chatID int64, tgMsgID int, content string) *PendingMessage {
+func (m *Manager) AddPending(addr transport.Address, msgID string, q Question, deadline time.Time) *PendingMessage {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	m.idSeq++
 	pm := &PendingMessage{
-		ID:         string(rune(m.idSeq)),
-		TgMsgID:    tgMsgID,
-		Content:    content,
+		ID:         strconv.FormatInt(m.idSeq, 10),
+		Addr:       addr,
+		Session:    q.Session,
+		MsgID:      msgID,
+		Content:    q.Content,
+		Choices:    q.Choices,
 		ResponseCh: make(chan string, 1),
 		CreatedAt:  time.Now(),
+		Deadline:   deadline,
 	}
 
-	m.pending[chatID] = append(m.pending[chatID], pm)
+	m.pending[addr] = append(m.pending[addr], pm)
+	m.persist()
+	m.publish(questionEvent(EventAsked, pm))
 	return pm
 }
 
-func (m *Manager) HandleReply(chatID int64, replyToMsgID int, reply string) bool {
+// Receive routes a message from a transport: it completes a chat ID
+// capture, answers a pending question, or is queued for the next send to
+// the chat. Bot commands are also published for watchers. A choice button
+// only ever answers the question it belongs to, and is dropped once that is
+// settled.
+func (m *Manager) Receive(in transport.Incoming) {
+	if m.TryCaptureChat(in.Chat) {
+		return
+	}
+
+	if in.Choice {
+		if !m.AnswerMessage(in.Chat, in.ReplyTo, in.Text, in.Chat.Transport) {
+			log.Printf("dropping choice %q in %s: message %s has no pending question", in.Text, in.Chat, in.ReplyTo)
+		}
+		return
+	}
+
+	if in.ReplyTo != "" {
+		matched, routed := m.RouteRecoveredReply(in.Chat, in.ReplyTo, in.Text)
+		if matched && routed {
+			log.Printf("reply in %s answers a question from before the restart, queued for the next send", in.Chat)
+		} else if matched {
+			log.Printf("dropping reply in %s to a question that has timed out", in.Chat)
+		}
+		if matched {
+			return
+		}
+	}
+
+	if in.Command {
+		m.publishCommand(in)
+	}
+	if !m.HandleReply(in.Chat, in.ReplyTo, in.Text) {
+		m.QueueMessage(in.Chat, in.Text)
+	}
+}
+
+// RouteRecoveredReply handles a reply to a question asked by a previous
+// daemon. matched reports whether replyTo referred to a recovered question;
+// if it was still before its deadline the reply is queued for the next send
+// to the chat and routed is true, otherwise it is dropped.
+func (m *Manager) RouteRecoveredReply(addr transport.Address, replyTo string, reply string) (matched, routed bool) {
+	if replyTo == "" {
+		return false, false
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	queue, exists := m.pending[chatID]
-	if !exists || len(queue) == 0 {
-		return false
+	recs := m.recovered[addr]
+	for i, rec := range recs {
+		if rec.MsgID != replyTo {
+			continue
+		}
+		m.recovered[addr] = append(recs[:i], recs[i+1:]...)
+		if len(m.recovered[addr]) == 0 {
+			delete(m.recovered, addr)
+		}
+		if time.Now().Before(rec.Deadline) {
+			m.queuedMsgs[addr] = append(m.queuedMsgs[addr], reply)
+			m.publishQueued(addr, reply)
+			routed = true
+		}
+		m.persist()
+		return true, routed
 	}
+	return false, false
+}
+
+// HandleReply answers the pending question replyTo refers to or, if replyTo
+// is empty, the oldest one in the chat. It reports false if nothing was
+// answered, including when replyTo isn't a pending question.
+func (m *Manager) HandleReply(addr transport.Address, replyTo string, reply string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	var matched *PendingMessage
-	var matchedIdx int
+	queue := m.pending[addr]
+	if len(queue) == 0 {
+		return false
+	}
 
-	if replyToMsgID > 0 {
+	matchedIdx := 0
+	if replyTo != "" {
+		matchedIdx = -1
 		for i, pm := range queue {
-			if pm.TgMsgID == replyToMsgID {
-				matched = pm
+			if pm.MsgID == replyTo {
 				matchedIdx = i
 				break
 			}
 		}
+		if matchedIdx < 0 {
+			return false
+		}
 	}
 
-	if matched == nil {
-		matched = queue[0]
-		matchedIdx = 0
+	m.resolve(addr, matchedIdx, reply, addr.Transport)
+	return true
+}
+
+// Answer answers the pending question with the given ID the way a reply in
+// its chat would, recording source as where the answer came from. It
+// returns the question, or false if it is no longer pending.
+func (m *Manager) Answer(id, reply, source string) (*PendingMessage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for addr, queue := range m.pending {
+		for i, pm := range queue {
+			if pm.ID == id {
+				m.resolve(addr, i, reply, source)
+				return pm, true
+			}
+		}
 	}
+	return nil, false
+}
 
-	matched.ResponseCh <- reply
-	close(matched.ResponseCh)
+// resolve hands reply to the i'th pending question in addr. m.mu must be
+// held.
+func (m *Manager) resolve(addr transport.Address, i int, reply, source string) {
+	queue := m.pending[addr]
+	pm := queue[i]
+	pm.Source = source
+	pm.ResponseCh <- reply
+	close(pm.ResponseCh)
 
-	m.pending[chatID] = append(queue[:matchedIdx], queue[matchedIdx+1:]...)
-	if len(m.pending[chatID]) == 0 {
-		delete(m.pending, chatID)
+	m.pending[addr] = append(queue[:i], queue[i+1:]...)
+	if len(m.pending[addr]) == 0 {
+		delete(m.pending, addr)
 	}
+	m.persist()
+
+	ev := questionEvent(EventAnswered, pm)
+	ev.Text = reply
+	ev.Source = source
+	m.publish(ev)
+}
+
+// RemovePending drops a question that timed out. It reports false if the
+// question was no longer pending, because it was answered or cancelled in
+// the meantime.
+func (m *Manager) RemovePending(addr transport.Address, pm *PendingMessage) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
+	if !m.drop(addr, pm.ID) {
+		return false
+	}
+	m.publish(questionEvent(EventExpired, pm))
 	return true
 }
 
-func (m *Manager) RemovePending(chatID int64, pm *PendingMessage) {
+// Cancel withdraws the pending question with the given ID. Its ResponseCh
+// is closed without an answer.
+func (m *Manager) Cancel(id string) (*PendingMessage, bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	queue, exists := m.pending[chatID]
-	if !exists {
-		return
+	for addr, queue := range m.pending {
+		for _, pm := range queue {
+			if pm.ID == id {
+				m.drop(addr, id)
+				close(pm.ResponseCh)
+				m.publish(questionEvent(EventCancelled, pm))
+				return pm, true
+			}
+		}
 	}
+	return nil, false
+}
 
+// drop removes question id from addr's queue. m.mu must be held.
+func (m *Manager) drop(addr transport.Address, id string) bool {
+	queue := m.pending[addr]
 	for i, p := range queue {
-		if p.ID == pm.ID {
-			m.pending[chatID] = append(queue[:i], queue[i+1:]...)
-			if len(m.pending[chatID]) == 0 {
-				delete(m.pending, chatID)
+		if p.ID == id {
+			m.pending[addr] = append(queue[:i], queue[i+1:]...)
+			if len(m.pending[addr]) == 0 {
+				delete(m.pending, addr)
 			}
-			return
+			m.persist()
+			return true
+		}
+	}
+	return false
+}
+
+// Questions returns the pending questions in every chat, oldest first.
+func (m *Manager) Questions() []*PendingMessage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var qs []*PendingMessage
+	for _, queue := range m.pending {
+		qs = append(qs, queue...)
+	}
+	sort.Slice(qs, func(i, j int) bool { return qs[i].CreatedAt.Before(qs[j].CreatedAt) })
+	return qs
+}
+
+// AnswerMessage answers the question sent as msgID in addr, recording
+// source as where the answer came from. An answer to a question from before
+// a restart is queued as RouteRecoveredReply does. It reports false if
+// msgID isn't waiting for an answer.
+func (m *Manager) AnswerMessage(addr transport.Address, msgID, reply, source string) bool {
+	m.mu.Lock()
+	for i, pm := range m.pending[addr] {
+		if pm.MsgID == msgID {
+			m.resolve(addr, i, reply, source)
+			m.mu.Unlock()
+			return true
 		}
 	}
+	m.mu.Unlock()
+
+	_, routed := m.RouteRecoveredReply(addr, msgID, reply)
+	return routed
 }
 
-func (m *Manager) HasPendingForChat(chatID int64) bool {
+func (m *Manager) HasPendingForChat(addr transport.Address) bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	queue, exists := m.pending[chatID]
+	queue, exists := m.pending[addr]
 	return exists && len(queue) > 0
 }
 
+// Stats returns per-chat counts for every chat with pending questions or
+// queued messages.
+func (m *Manager) Stats() map[transport.Address]ChatStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := make(map[transport.Address]ChatStats)
+	for addr, queue := range m.pending {
+		st := stats[addr]
+		st.Pending = len(queue)
+		for _, pm := range queue {
+			if st.OldestPending.IsZero() || pm.CreatedAt.Before(st.OldestPending) {
+				st.OldestPending = pm.CreatedAt
+			}
+		}
+		stats[addr] = st
+	}
+	for addr, msgs := range m.queuedMsgs {
+		st := stats[addr]
+		st.Queued = len(msgs)
+		stats[addr] = st
+	}
+	return stats
+}
+
 func (m *Manager) FindSessionByName(name string) *config.SessionConfig {
-	return m.config.FindSessionByName(name)
+	return m.Config().FindSessionByName(name)
 }
 
 func (m *Manager) FindSessionByWorkDir(workDir string) *config.SessionConfig {
-	return m.config.FindSessionByWorkDir(workDir)
+	return m.Config().FindSessionByWorkDir(workDir)
 }
 
-func (m *Manager) QueueMessage(chatID int64, text string) {
+func (m *Manager) QueueMessage(addr transport.Address, text string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.queuedMsgs[chatID] = append(m.queuedMsgs[chatID], text)
+	m.queuedMsgs[addr] = append(m.queuedMsgs[addr], text)
+	m.publishQueued(addr, text)
 }
 
-func (m *Manager) PopQueuedMessages(chatID int64) []string {
+func (m *Manager) PopQueuedMessages(addr transport.Address) []string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	msgs := m.queuedMsgs[chatID]
-	delete(m.queuedMsgs, chatID)
+	msgs := m.queuedMsgs[addr]
+	delete(m.queuedMsgs, addr)
 	return msgs
 }
 
@@ -148,7 +431,7 @@ func (m *Manager) StartChatIDCapture() *ChatIDCapture {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.chatIDCapture = &ChatIDCapture{
-		ResponseCh: make(chan int64, 1),
+		ResponseCh: make(chan transport.Address, 1),
 	}
 	return m.chatIDCapture
 }
@@ -159,11 +442,11 @@ func (m *Manager) CancelChatIDCapture() {
 	m.chatIDCapture = nil
 }
 
-func (m *Manager) TryCaptureChat(chatID int64) bool {
+func (m *Manager) TryCaptureChat(addr transport.Address) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.chatIDCapture != nil {
-		m.chatIDCapture.ResponseCh <- chatID
+		m.chatIDCapture.ResponseCh <- addr
 		close(m.chatIDCapture.ResponseCh)
 		m.chatIDCapture = nil
 		return true
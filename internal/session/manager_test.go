@@ -0,0 +1,166 @@
+package session
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/state"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+var chat = transport.Address{Transport: transport.Telegram, Chat: "100"}
+
+// newTestManager returns a manager for chat with questions sent as messages
+// 10 and 11 pending, and from a previous daemon message 5 still waiting and
+// message 6 past its deadline.
+func newTestManager(t *testing.T) (*Manager, *state.Store, []*PendingMessage) {
+	t.Helper()
+	store := state.New(filepath.Join(t.TempDir(), "state.json"))
+	now := time.Now()
+	err := store.SetPending([]state.PendingRecord{
+		{Transport: chat.Transport, Chat: chat.Chat, MsgID: "5", Content: "Old?", Deadline: now.Add(time.Hour)},
+		{Transport: chat.Transport, Chat: chat.Chat, MsgID: "6", Content: "Older?", Deadline: now.Add(20 * time.Millisecond)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(&config.Config{Sessions: []config.SessionConfig{{Name: "api", ChatID: 100}}})
+	m.SetStore(store)
+	time.Sleep(30 * time.Millisecond)
+
+	deadline := now.Add(time.Hour)
+	pms := []*PendingMessage{
+		m.AddPending(chat, "10", Question{Session: "api", Content: "First?"}, deadline),
+		m.AddPending(chat, "11", Question{Session: "api", Content: "Second?"}, deadline),
+	}
+	return m, store, pms
+}
+
+func TestReceive(t *testing.T) {
+	tests := []struct {
+		name string
+		in   transport.Incoming
+		// answered is the index of the question answered, or -1.
+		answered int
+		queued   []string
+		// command reports whether a command event is published.
+		command bool
+		// recovered is how many questions from the previous daemon are
+		// still known afterwards.
+		recovered int
+	}{
+		{"reply to a question", transport.Incoming{Text: "yes", ReplyTo: "11"}, 1, nil, false, 2},
+		{"plain message answers the oldest", transport.Incoming{Text: "yes"}, 0, nil, false, 2},
+		{"reply to another message is queued", transport.Incoming{Text: "by the way", ReplyTo: "99"}, -1, []string{"by the way"}, false, 2},
+		{"reply to a recovered question is queued", transport.Incoming{Text: "late", ReplyTo: "5"}, -1, []string{"late"}, false, 1},
+		{"reply to an expired recovered question is dropped", transport.Incoming{Text: "too late", ReplyTo: "6"}, -1, nil, false, 1},
+		{"choice", transport.Incoming{Text: "merge", ReplyTo: "11", Choice: true}, 1, nil, false, 2},
+		{"stale choice is dropped", transport.Incoming{Text: "merge", ReplyTo: "99", Choice: true}, -1, nil, false, 2},
+		{"choice on a recovered question is queued", transport.Incoming{Text: "merge", ReplyTo: "5", Choice: true}, -1, []string{"merge"}, false, 1},
+		{"command answers the oldest", transport.Incoming{Text: "/status", Command: true}, 0, nil, true, 2},
+		{"command as a reply", transport.Incoming{Text: "/status", ReplyTo: "11", Command: true}, 1, nil, true, 2},
+		{"slash that isn't a command is an answer", transport.Incoming{Text: "/ is fine"}, 0, nil, false, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, store, pms := newTestManager(t)
+			tt.in.Chat = chat
+
+			events, unsubscribe := m.Subscribe()
+			defer unsubscribe()
+
+			m.Receive(tt.in)
+
+			for i, pm := range pms {
+				select {
+				case reply := <-pm.ResponseCh:
+					if i != tt.answered {
+						t.Errorf("question %d answered with %q", i, reply)
+					} else if reply != tt.in.Text {
+						t.Errorf("question %d answered with %q, want %q", i, reply, tt.in.Text)
+					}
+				default:
+					if i == tt.answered {
+						t.Errorf("question %d not answered", i)
+					}
+				}
+			}
+			if queued := m.PopQueuedMessages(chat); !slices.Equal(queued, tt.queued) {
+				t.Errorf("queued %q, want %q", queued, tt.queued)
+			}
+			if command := publishedCommand(events); command != tt.command {
+				t.Errorf("command event published: %v, want %v", command, tt.command)
+			}
+
+			live := 2
+			if tt.answered >= 0 {
+				live--
+			}
+			if n := len(store.Pending()); n != live+tt.recovered {
+				t.Errorf("%d questions persisted, want %d live and %d recovered", n, live, tt.recovered)
+			}
+		})
+	}
+}
+
+// publishedCommand reports whether a command event is waiting in events.
+func publishedCommand(events <-chan Event) bool {
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == EventCommand {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+func TestSetStoreSkipsExpired(t *testing.T) {
+	store := state.New(filepath.Join(t.TempDir(), "state.json"))
+	err := store.SetPending([]state.PendingRecord{
+		{Transport: chat.Transport, Chat: chat.Chat, MsgID: "1", Deadline: time.Now().Add(-time.Minute)},
+		{Transport: chat.Transport, Chat: chat.Chat, MsgID: "2", Deadline: time.Now().Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(&config.Config{})
+	m.SetStore(store)
+	if p := store.Pending(); len(p) != 1 || p[0].MsgID != "2" {
+		t.Fatalf("persisted %+v, want only the unexpired question", p)
+	}
+	if matched, _ := m.RouteRecoveredReply(chat, "1", "late"); matched {
+		t.Fatal("expired question was recovered")
+	}
+}
+
+func TestAnswerMessage(t *testing.T) {
+	m, _, pms := newTestManager(t)
+
+	if m.AnswerMessage(chat, "99", "yes", transport.Webhook) {
+		t.Fatal("answered a message that isn't a question")
+	}
+	if !m.AnswerMessage(chat, "11", "yes", transport.Webhook) {
+		t.Fatal("question not answered")
+	}
+	if reply := <-pms[1].ResponseCh; reply != "yes" || pms[1].Source != transport.Webhook {
+		t.Fatalf("answer = %q from %q", reply, pms[1].Source)
+	}
+	if m.AnswerMessage(chat, "11", "again", transport.Webhook) {
+		t.Fatal("answered the same question twice")
+	}
+	if !m.AnswerMessage(chat, "5", "late", transport.Webhook) {
+		t.Fatal("answer to a recovered question was not queued")
+	}
+	if queued := m.PopQueuedMessages(chat); !slices.Equal(queued, []string{"late"}) {
+		t.Fatalf("queued %q", queued)
+	}
+}
@@ -0,0 +1,139 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAPIEndpoint is the Web API base URL used unless slack.api_endpoint
+// says otherwise.
+const DefaultAPIEndpoint = "https://slack.com/api"
+
+// client calls the few Web API methods the transport uses.
+type client struct {
+	endpoint string
+	token    string
+	http     *http.Client
+}
+
+// Error is a Web API response with "ok": false.
+type Error struct {
+	Method string
+	Code   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("slack: %s: %s", e.Method, e.Code)
+}
+
+// fatal reports whether the error means the token is unusable.
+func (e *Error) fatal() bool {
+	switch e.Code {
+	case "invalid_auth", "not_authed", "account_inactive", "token_revoked", "token_expired":
+		return true
+	}
+	return false
+}
+
+func newClient(endpoint, token string) *client {
+	if endpoint == "" {
+		endpoint = DefaultAPIEndpoint
+	}
+	return &client{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		token:    token,
+		http:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// authTest checks the token and returns the bot's own user ID.
+func (c *client) authTest(ctx context.Context) (string, error) {
+	var out struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.call(ctx, "auth.test", struct{}{}, &out); err != nil {
+		return "", err
+	}
+	return out.UserID, nil
+}
+
+func (c *client) postMessage(ctx context.Context, channel, text string, blocks []block) (string, error) {
+	var out struct {
+		TS string `json:"ts"`
+	}
+	in := map[string]any{"channel": channel, "text": text, "unfurl_links": false}
+	if len(blocks) > 0 {
+		in["blocks"] = blocks
+	}
+	if err := c.call(ctx, "chat.postMessage", in, &out); err != nil {
+		return "", err
+	}
+	return out.TS, nil
+}
+
+func (c *client) update(ctx context.Context, channel, ts, text string, blocks []block) error {
+	in := map[string]any{"channel": channel, "ts": ts, "text": text, "blocks": blocks}
+	return c.call(ctx, "chat.update", in, nil)
+}
+
+// call posts in as JSON to method. Rate limited calls are retried after the
+// delay Slack asks for.
+func (c *client) call(ctx context.Context, method string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding %s request: %w", method, err)
+	}
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/"+method, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+			wait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(max(wait, 1)) * time.Second):
+			}
+			continue
+		}
+
+		var raw json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&raw)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decoding %s response (HTTP %d): %w", method, resp.StatusCode, err)
+		}
+
+		var status struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &status); err != nil {
+			return fmt.Errorf("decoding %s response: %w", method, err)
+		}
+		if !status.OK {
+			return &Error{Method: method, Code: status.Error}
+		}
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(raw, out)
+	}
+}
@@ -0,0 +1,249 @@
+package slack
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+const (
+	// EventsPath receives Events API callbacks and InteractionsPath button
+	// presses; both are set as request URLs in the Slack app.
+	EventsPath       = "/slack/events"
+	InteractionsPath = "/slack/interactions"
+
+	maxBodySize = 1 << 20
+	// maxClockSkew is how old a request may be before it is treated as a
+	// replay.
+	maxClockSkew = 5 * time.Minute
+	// dedupWindow is how long event IDs are remembered, covering Slack's
+	// retries of events it thinks we didn't acknowledge.
+	dedupWindow = 10 * time.Minute
+)
+
+var errBadSignature = errors.New("invalid slack signature")
+
+func (t *Transport) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+EventsPath, t.handleEvents)
+	mux.HandleFunc("POST "+InteractionsPath, t.handleInteractions)
+	return mux
+}
+
+// readSigned reads the request body and checks Slack's signature over it.
+func (t *Transport) readSigned(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "reading body", http.StatusBadRequest)
+		return nil, false
+	}
+	if err := verify(t.signingSecret, r.Header, body, time.Now()); err != nil {
+		log.Printf("slack: rejecting request from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	return body, true
+}
+
+// verify checks the v0 signature Slack puts on every request: an HMAC-SHA256
+// of "v0:<timestamp>:<body>" keyed with the signing secret.
+func verify(secret string, h http.Header, body []byte, now time.Time) error {
+	ts := h.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errBadSignature
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > maxClockSkew || age < -maxClockSkew {
+		return errors.New("slack request timestamp is too far from now")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(want), []byte(h.Get("X-Slack-Signature"))) {
+		return errBadSignature
+	}
+	return nil
+}
+
+type eventEnvelope struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	EventID   string `json:"event_id"`
+	Event     struct {
+		Type     string `json:"type"`
+		Subtype  string `json:"subtype"`
+		BotID    string `json:"bot_id"`
+		User     string `json:"user"`
+		Channel  string `json:"channel"`
+		Text     string `json:"text"`
+		TS       string `json:"ts"`
+		ThreadTS string `json:"thread_ts"`
+	} `json:"event"`
+}
+
+func (t *Transport) handleEvents(w http.ResponseWriter, r *http.Request) {
+	body, ok := t.readSigned(w, r)
+	if !ok {
+		return
+	}
+
+	var env eventEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	switch env.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, env.Challenge)
+		return
+	case "event_callback":
+	default:
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Acknowledge first: Slack retries anything not answered within three
+	// seconds.
+	w.WriteHeader(http.StatusOK)
+
+	ev := env.Event
+	if ev.Type != "message" || ev.Subtype != "" || ev.BotID != "" || ev.User == t.self() || !t.isAllowedUser(ev.User) {
+		return
+	}
+	if t.seen(env.EventID) {
+		return
+	}
+
+	in := transport.Incoming{
+		Chat: transport.Address{Transport: transport.Slack, Chat: ev.Channel},
+		ID:   ev.TS,
+		User: ev.User,
+		Text: unescape(ev.Text),
+		Time: tsTime(ev.TS),
+	}
+	if ev.ThreadTS != "" && ev.ThreadTS != ev.TS {
+		in.ReplyTo = ev.ThreadTS
+	}
+	t.deliver(r, in)
+}
+
+type interaction struct {
+	Type string `json:"type"`
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	Channel struct {
+		ID string `json:"id"`
+	} `json:"channel"`
+	Message struct {
+		TS string `json:"ts"`
+	} `json:"message"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+		ActionTS string `json:"action_ts"`
+	} `json:"actions"`
+}
+
+// handleInteractions turns a press on a choice button into a reply to the
+// message carrying the buttons.
+func (t *Transport) handleInteractions(w http.ResponseWriter, r *http.Request) {
+	body, ok := t.readSigned(w, r)
+	if !ok {
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	var p interaction
+	if err := json.Unmarshal([]byte(r.PostForm.Get("payload")), &p); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if p.Type != "block_actions" || len(p.Actions) == 0 || !t.isAllowedUser(p.User.ID) {
+		return
+	}
+	action := p.Actions[0]
+	if !strings.HasPrefix(action.ActionID, choiceActionPrefix) {
+		return
+	}
+
+	t.deliver(r, transport.Incoming{
+		Chat:    transport.Address{Transport: transport.Slack, Chat: p.Channel.ID},
+		ID:      action.ActionTS,
+		User:    p.User.ID,
+		Text:    action.Value,
+		ReplyTo: p.Message.TS,
+		Choice:  true,
+		Time:    time.Now(),
+	})
+}
+
+// self returns the bot's user ID, or "" before it is known.
+func (t *Transport) self() string {
+	select {
+	case <-t.ready:
+		return t.userID
+	default:
+		return ""
+	}
+}
+
+func (t *Transport) deliver(r *http.Request, in transport.Incoming) {
+	select {
+	case t.incoming <- in:
+	case <-r.Context().Done():
+		log.Printf("slack: dropped message %s in %s: daemon busy", in.ID, in.Chat.Chat)
+	}
+}
+
+// seen records id and reports whether it was already handled.
+func (t *Transport) seen(id string) bool {
+	if id == "" {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for k, at := range t.eventIDs {
+		if now.Sub(at) > dedupWindow {
+			delete(t.eventIDs, k)
+		}
+	}
+	if _, ok := t.eventIDs[id]; ok {
+		return true
+	}
+	t.eventIDs[id] = now
+	return false
+}
+
+// tsTime converts a message timestamp like "1700000000.000100" to a time.
+func tsTime(ts string) time.Time {
+	sec, _, _ := strings.Cut(ts, ".")
+	n, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.Unix(n, 0)
+}
@@ -0,0 +1,247 @@
+// Package slack is the Slack transport. Each session posts in its own
+// channel, answers are replies in the question's thread, and choices are
+// Block Kit buttons. Events and button presses arrive over HTTP from Slack's
+// Events API.
+package slack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/session"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+// DefaultListen is the events endpoint address unless slack.listen is set.
+const DefaultListen = "127.0.0.1:8377"
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 2 * time.Minute
+
+	// Block Kit limits.
+	maxSectionText = 3000
+	maxButtonText  = 75
+	maxButtonValue = 2000
+	maxButtons     = 25
+
+	choiceActionPrefix = "cctg_choice_"
+)
+
+var _ transport.Transport = (*Transport)(nil)
+
+type Transport struct {
+	client        *client
+	signingSecret string
+	listen        string
+	sessions      *session.Manager
+	incoming      chan transport.Incoming
+
+	// ready is closed once the endpoint is listening and the token has been
+	// checked; addr and userID are set by then.
+	ready  chan struct{}
+	addr   net.Addr
+	userID string
+
+	mu       sync.Mutex
+	eventIDs map[string]time.Time
+}
+
+// New prepares the Slack transport; the endpoint is opened and the token
+// checked in Start.
+func New(cfg *config.Config, sessions *session.Manager) (*Transport, error) {
+	if cfg.Slack.BotToken == "" {
+		return nil, errors.New("slack bot token is not set (use slack.bot_token or SLACK_BOT_TOKEN)")
+	}
+	if cfg.Slack.SigningSecret == "" {
+		return nil, errors.New("slack signing secret is not set (use slack.signing_secret or SLACK_SIGNING_SECRET)")
+	}
+
+	listen := cfg.Slack.Listen
+	if listen == "" {
+		listen = DefaultListen
+	}
+	return &Transport{
+		client:        newClient(cfg.Slack.APIEndpoint, cfg.Slack.BotToken),
+		signingSecret: cfg.Slack.SigningSecret,
+		listen:        listen,
+		sessions:      sessions,
+		incoming:      make(chan transport.Incoming, 100),
+		ready:         make(chan struct{}),
+		eventIDs:      make(map[string]time.Time),
+	}, nil
+}
+
+func (t *Transport) Name() string {
+	return transport.Slack
+}
+
+func (t *Transport) Capabilities() transport.Capabilities {
+	return transport.Capabilities{
+		Edit:    true,
+		Replies: true,
+		Choices: true,
+	}
+}
+
+func (t *Transport) Incoming() <-chan transport.Incoming {
+	return t.incoming
+}
+
+// Start opens the events endpoint, checks the bot token and serves events
+// until ctx is done. A rejected token is fatal; an unreachable Slack is
+// retried.
+func (t *Transport) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", t.listen)
+	if err != nil {
+		return fmt.Errorf("slack events endpoint: %w", err)
+	}
+	srv := &http.Server{Handler: t.handler(), ReadHeaderTimeout: 10 * time.Second}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+	defer srv.Close()
+	log.Printf("slack events endpoint listening on %s", ln.Addr())
+
+	userID, err := t.login(ctx)
+	if err != nil || userID == "" {
+		return err
+	}
+	t.addr = ln.Addr()
+	t.userID = userID
+	close(t.ready)
+	log.Printf("connected to slack as %s", userID)
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errCh:
+		return fmt.Errorf("slack events endpoint: %w", err)
+	}
+}
+
+func (t *Transport) login(ctx context.Context) (string, error) {
+	backoff := minBackoff
+	for {
+		userID, err := t.client.authTest(ctx)
+		if err == nil {
+			return userID, nil
+		}
+
+		var slackErr *Error
+		if errors.As(err, &slackErr) && slackErr.fatal() {
+			return "", fmt.Errorf("slack rejected the bot token: %w", err)
+		}
+
+		log.Printf("cannot reach slack, retrying in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return "", nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (t *Transport) isAllowedUser(id string) bool {
+	return slices.Contains(t.sessions.Config().Slack.AllowedUsers, id)
+}
+
+// Send posts msg to channel chat and returns its timestamp, which replies in
+// its thread refer to.
+func (t *Transport) Send(ctx context.Context, chat string, msg transport.Message) (string, error) {
+	select {
+	case <-t.ready:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	text := escape(msg.Text)
+	var blocks []block
+	if len(msg.Choices) > 0 {
+		if len(text) > maxSectionText {
+			return "", fmt.Errorf("message with choices exceeds %d character limit", maxSectionText)
+		}
+		if len(msg.Choices) > maxButtons {
+			return "", fmt.Errorf("at most %d choices are supported", maxButtons)
+		}
+		blocks = []block{sectionBlock(text), choicesBlock(msg.Choices)}
+	}
+
+	ts, err := t.client.postMessage(ctx, chat, text, blocks)
+	if err != nil {
+		return "", fmt.Errorf("sending message: %w", err)
+	}
+	return ts, nil
+}
+
+// Edit replaces the text of message id, removing any choice buttons.
+func (t *Transport) Edit(ctx context.Context, chat, id, text string) error {
+	select {
+	case <-t.ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	text = escape(text)
+	var blocks []block
+	if len(text) <= maxSectionText {
+		blocks = []block{sectionBlock(text)}
+	}
+	if err := t.client.update(ctx, chat, id, text, blocks); err != nil {
+		return fmt.Errorf("editing message: %w", err)
+	}
+	return nil
+}
+
+type block map[string]any
+
+func sectionBlock(text string) block {
+	return block{"type": "section", "text": map[string]any{"type": "mrkdwn", "text": text}}
+}
+
+func choicesBlock(choices []string) block {
+	var buttons []map[string]any
+	for i, c := range choices {
+		buttons = append(buttons, map[string]any{
+			"type":      "button",
+			"action_id": choiceActionPrefix + strconv.Itoa(i),
+			"text":      map[string]any{"type": "plain_text", "text": truncate(c, maxButtonText)},
+			"value":     truncate(c, maxButtonValue),
+		})
+	}
+	return block{"type": "actions", "block_id": "cctg_choices", "elements": buttons}
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+var (
+	escaper   = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	unescaper = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+)
+
+// escape encodes the characters Slack reserves for links and mentions.
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
@@ -0,0 +1,263 @@
+package slack
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/session"
+	"github.com/bupd/go-claude-code-telegram/internal/testutil"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+const (
+	testToken  = "xoxb-test"
+	testSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+	botUser    = "UBOT"
+	alice      = "UALICE"
+	channel    = "C0PROJECT"
+)
+
+// webAPI is a stub of the Slack Web API methods the transport calls.
+type webAPI struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	calls  []apiCall
+	nextTS int
+}
+
+type apiCall struct {
+	Method string
+	Body   map[string]any
+}
+
+func newWebAPI(t *testing.T) *webAPI {
+	api := &webAPI{}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": "invalid_auth"})
+			return
+		}
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		method := strings.TrimPrefix(r.URL.Path, "/")
+
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		api.calls = append(api.calls, apiCall{Method: method, Body: body})
+
+		out := map[string]any{"ok": true}
+		switch method {
+		case "auth.test":
+			out["user_id"] = botUser
+		case "chat.postMessage":
+			api.nextTS++
+			out["ts"] = "1700000000.00010" + strconv.Itoa(api.nextTS)
+		case "chat.update":
+			out["ts"] = body["ts"]
+		default:
+			out = map[string]any{"ok": false, "error": "unknown_method"}
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func (api *webAPI) called(method string) []apiCall {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	var out []apiCall
+	for _, c := range api.calls {
+		if c.Method == method {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func start(t *testing.T, token string) (*Transport, *webAPI, chan error) {
+	t.Helper()
+	api := newWebAPI(t)
+	cfg := &config.Config{
+		Slack: config.SlackConfig{
+			BotToken:      token,
+			SigningSecret: testSecret,
+			Listen:        "127.0.0.1:0",
+			APIEndpoint:   api.URL,
+			AllowedUsers:  []string{alice},
+		},
+		Sessions: []config.SessionConfig{{Name: "proj", Transport: transport.Slack, Channel: channel}},
+	}
+	tr, err := New(cfg, session.NewManager(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errCh := testutil.Start(t, tr.Start)
+	if token == testToken {
+		testutil.WaitReady(t, tr.ready, errCh)
+	}
+	return tr, api, errCh
+}
+
+// post sends a request signed with secret to the transport's endpoint.
+func post(t *testing.T, tr *Transport, path, contentType, body, secret string) *http.Response {
+	t.Helper()
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+tr.addr.String()+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func postEvent(t *testing.T, tr *Transport, eventID string, event map[string]any) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]any{"type": "event_callback", "event_id": eventID, "event": event})
+	if resp := post(t, tr, EventsPath, "application/json", string(body), testSecret); resp.StatusCode != http.StatusOK {
+		t.Fatalf("posting event: HTTP %d", resp.StatusCode)
+	}
+}
+
+func next(t *testing.T, tr *Transport) transport.Incoming {
+	t.Helper()
+	select {
+	case in := <-tr.Incoming():
+		return in
+	case <-time.After(5 * time.Second):
+		t.Fatal("no incoming message")
+		return transport.Incoming{}
+	}
+}
+
+func TestURLVerification(t *testing.T) {
+	tr, _, _ := start(t, testToken)
+
+	resp := post(t, tr, EventsPath, "application/json", `{"type":"url_verification","challenge":"3eZbrw1aB"}`, testSecret)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "3eZbrw1aB" {
+		t.Fatalf("challenge response = HTTP %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestRejectsBadSignature(t *testing.T) {
+	tr, _, _ := start(t, testToken)
+
+	resp := post(t, tr, EventsPath, "application/json", `{"type":"url_verification","challenge":"x"}`, "wrong secret")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("HTTP %d, want 401", resp.StatusCode)
+	}
+
+	h := http.Header{}
+	h.Set("X-Slack-Request-Timestamp", strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	if err := verify(testSecret, h, nil, time.Now()); err == nil {
+		t.Error("verify accepted an hour-old request")
+	}
+}
+
+func TestThreadReplyMatching(t *testing.T) {
+	tr, api, _ := start(t, testToken)
+
+	ts, err := tr.Send(context.Background(), channel, transport.Message{Text: "Deploy <main> & tag?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := api.called("chat.postMessage")
+	if len(sent) != 1 || sent[0].Body["channel"] != channel || sent[0].Body["text"] != "Deploy &lt;main&gt; &amp; tag?" {
+		t.Fatalf("chat.postMessage calls = %v", sent)
+	}
+
+	postEvent(t, tr, "Ev1", map[string]any{"type": "message", "user": "UMALLORY", "channel": channel, "text": "yes", "ts": "1700000001.000001", "thread_ts": ts})
+	postEvent(t, tr, "Ev2", map[string]any{"type": "message", "bot_id": "B1", "user": botUser, "channel": channel, "text": "echo", "ts": "1700000001.000002"})
+	reply := map[string]any{"type": "message", "user": alice, "channel": channel, "text": "yes &amp; tag it", "ts": "1700000001.000003", "thread_ts": ts}
+	postEvent(t, tr, "Ev3", reply)
+	postEvent(t, tr, "Ev3", reply) // a retry by Slack
+	postEvent(t, tr, "Ev4", map[string]any{"type": "message", "user": alice, "channel": channel, "text": "unprompted", "ts": "1700000001.000004"})
+
+	in := next(t, tr)
+	want := transport.Address{Transport: transport.Slack, Chat: channel}
+	if in.Chat != want || in.User != alice || in.ReplyTo != ts || in.Text != "yes & tag it" || in.Choice {
+		t.Fatalf("incoming = %+v, want a reply from %s in thread %s", in, alice, ts)
+	}
+	if in := next(t, tr); in.Text != "unprompted" || in.ReplyTo != "" {
+		t.Fatalf("incoming = %+v, want the unthreaded message (and no duplicate)", in)
+	}
+}
+
+func TestChoiceButtons(t *testing.T) {
+	tr, api, _ := start(t, testToken)
+
+	ts, err := tr.Send(context.Background(), channel, transport.Message{Text: "Merge?", Choices: []string{"merge", "wait"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, _ := json.Marshal(api.called("chat.postMessage")[0].Body["blocks"])
+	if !strings.Contains(string(blocks), `"action_id":"cctg_choice_1"`) || !strings.Contains(string(blocks), `"value":"wait"`) {
+		t.Fatalf("blocks = %s, want a button per choice", blocks)
+	}
+
+	press := func(user string) {
+		payload, _ := json.Marshal(map[string]any{
+			"type":    "block_actions",
+			"user":    map[string]any{"id": user},
+			"channel": map[string]any{"id": channel},
+			"message": map[string]any{"ts": ts},
+			"actions": []map[string]any{{"action_id": "cctg_choice_1", "value": "wait", "action_ts": "1700000002.1"}},
+		})
+		form := url.Values{"payload": {string(payload)}}.Encode()
+		if resp := post(t, tr, InteractionsPath, "application/x-www-form-urlencoded", form, testSecret); resp.StatusCode != http.StatusOK {
+			t.Fatalf("posting interaction: HTTP %d", resp.StatusCode)
+		}
+	}
+	press("UMALLORY")
+	press(alice)
+
+	if in := next(t, tr); in.User != alice || in.Text != "wait" || in.ReplyTo != ts || !in.Choice {
+		t.Fatalf("incoming = %+v, want %s choosing wait on %s", in, alice, ts)
+	}
+
+	if err := tr.Edit(context.Background(), channel, ts, "Merge? (answered: wait)"); err != nil {
+		t.Fatal(err)
+	}
+	updates := api.called("chat.update")
+	if len(updates) != 1 || updates[0].Body["ts"] != ts || strings.Contains(mustJSON(updates[0].Body["blocks"]), "button") {
+		t.Fatalf("chat.update calls = %v, want the buttons removed from %s", updates, ts)
+	}
+}
+
+func TestRejectedToken(t *testing.T) {
+	_, _, errCh := start(t, "xoxb-revoked")
+	if err := testutil.Stopped(t, errCh); err == nil || !strings.Contains(err.Error(), "invalid_auth") {
+		t.Fatalf("Start = %v, want invalid_auth", err)
+	}
+}
+
+func mustJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
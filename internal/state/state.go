@@ -0,0 +1,152 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// State is what the daemon keeps across restarts.
+type State struct {
+	// Offset is the ID of the last Telegram update that was processed.
+	Offset int `json:"offset"`
+	// MatrixSince is the /sync token to resume the Matrix transport from.
+	MatrixSince string          `json:"matrix_since,omitempty"`
+	Pending     []PendingRecord `json:"pending,omitempty"`
+}
+
+// PendingRecord is a question that was waiting for a reply when the state
+// was last written.
+type PendingRecord struct {
+	Transport string    `json:"transport"`
+	Chat      string    `json:"chat"`
+	MsgID     string    `json:"msg_id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	Deadline  time.Time `json:"deadline"`
+
+	// Telegram-only fields written before transports existed, converted
+	// on Open.
+	LegacyChatID  int64 `json:"chat_id,omitempty"`
+	LegacyTgMsgID int   `json:"tg_msg_id,omitempty"`
+}
+
+// Store persists State as JSON, writing atomically on every change.
+type Store struct {
+	path string
+	mu   sync.Mutex
+	st   State
+}
+
+// New returns an empty store that will write to path.
+func New(path string) *Store {
+	return &Store{path: path}
+}
+
+// Open loads the state at path. A missing file is an empty state.
+func Open(path string) (*Store, error) {
+	s := New(path)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.st); err != nil {
+		return nil, fmt.Errorf("parsing state %s: %w", path, err)
+	}
+	for i, rec := range s.st.Pending {
+		if rec.Transport == "" {
+			s.st.Pending[i] = PendingRecord{
+				Transport: "telegram",
+				Chat:      strconv.FormatInt(rec.LegacyChatID, 10),
+				MsgID:     strconv.Itoa(rec.LegacyTgMsgID),
+				Content:   rec.Content,
+				CreatedAt: rec.CreatedAt,
+				Deadline:  rec.Deadline,
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) Offset() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.st.Offset
+}
+
+func (s *Store) SetOffset(offset int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset <= s.st.Offset {
+		return nil
+	}
+	s.st.Offset = offset
+	return s.save()
+}
+
+func (s *Store) MatrixSince() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.st.MatrixSince
+}
+
+func (s *Store) SetMatrixSince(since string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if since == s.st.MatrixSince {
+		return nil
+	}
+	s.st.MatrixSince = since
+	return s.save()
+}
+
+func (s *Store) Pending() []PendingRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PendingRecord(nil), s.st.Pending...)
+}
+
+func (s *Store) SetPending(pending []PendingRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.st.Pending = pending
+	return s.save()
+}
+
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("creating state directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replacing state: %w", err)
+	}
+	return nil
+}
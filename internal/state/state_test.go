@@ -0,0 +1,134 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenMigratesLegacyPending(t *testing.T) {
+	created := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	deadline := created.Add(time.Hour)
+
+	tests := []struct {
+		name  string
+		state string
+		want  []PendingRecord
+	}{
+		{
+			name:  "telegram-only record",
+			state: `{"offset": 7, "pending": [{"chat_id": -100123, "tg_msg_id": 55, "content": "Deploy?", "created_at": "2025-01-02T03:04:05Z", "deadline": "2025-01-02T04:04:05Z"}]}`,
+			want:  []PendingRecord{{Transport: "telegram", Chat: "-100123", MsgID: "55", Content: "Deploy?", CreatedAt: created, Deadline: deadline}},
+		},
+		{
+			name:  "current record",
+			state: `{"pending": [{"transport": "matrix", "chat": "!room:example.org", "msg_id": "$event", "content": "Deploy?", "created_at": "2025-01-02T03:04:05Z", "deadline": "2025-01-02T04:04:05Z"}]}`,
+			want:  []PendingRecord{{Transport: "matrix", Chat: "!room:example.org", MsgID: "$event", Content: "Deploy?", CreatedAt: created, Deadline: deadline}},
+		},
+		{
+			name: "mixed",
+			state: `{"pending": [
+				{"transport": "slack", "chat": "C123", "msg_id": "1.2", "content": "a", "created_at": "2025-01-02T03:04:05Z", "deadline": "2025-01-02T04:04:05Z"},
+				{"chat_id": 42, "tg_msg_id": 9, "content": "b", "created_at": "2025-01-02T03:04:05Z", "deadline": "2025-01-02T04:04:05Z"}
+			]}`,
+			want: []PendingRecord{
+				{Transport: "slack", Chat: "C123", MsgID: "1.2", Content: "a", CreatedAt: created, Deadline: deadline},
+				{Transport: "telegram", Chat: "42", MsgID: "9", Content: "b", CreatedAt: created, Deadline: deadline},
+			},
+		},
+		{
+			name:  "nothing pending",
+			state: `{"offset": 3}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if err := os.WriteFile(path, []byte(tt.state), 0600); err != nil {
+				t.Fatal(err)
+			}
+			s, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.Pending()
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d records, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if !got[i].CreatedAt.Equal(tt.want[i].CreatedAt) || !got[i].Deadline.Equal(tt.want[i].Deadline) {
+					t.Errorf("record %d times = %v, %v", i, got[i].CreatedAt, got[i].Deadline)
+				}
+				got[i].CreatedAt, got[i].Deadline = tt.want[i].CreatedAt, tt.want[i].Deadline
+				if got[i] != tt.want[i] {
+					t.Errorf("record %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Fatalf("opening a missing state: %v", err)
+	}
+	if s.Offset() != 0 || len(s.Pending()) != 0 {
+		t.Fatal("missing state is not empty")
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(corrupt); err == nil {
+		t.Fatal("opening a corrupt state succeeded")
+	}
+}
+
+func TestStorePersists(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "state.json")
+	s := New(path)
+
+	if err := s.SetOffset(10); err != nil {
+		t.Fatal(err)
+	}
+	// Offsets only move forward.
+	if err := s.SetOffset(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetMatrixSince("s72594_4483_1934"); err != nil {
+		t.Fatal(err)
+	}
+	rec := PendingRecord{Transport: "telegram", Chat: "1", MsgID: "2", Content: "Deploy?", Deadline: time.Now().Add(time.Hour).Truncate(time.Second)}
+	if err := s.SetPending([]PendingRecord{rec}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Offset() != 10 {
+		t.Errorf("offset = %d, want 10", reopened.Offset())
+	}
+	if reopened.MatrixSince() != "s72594_4483_1934" {
+		t.Errorf("matrix since = %q", reopened.MatrixSince())
+	}
+	if p := reopened.Pending(); len(p) != 1 || p[0].MsgID != "2" || !p[0].Deadline.Equal(rec.Deadline) {
+		t.Errorf("pending = %+v, want %+v", p, rec)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("state directory has %d entries, want only the state file", len(entries))
+	}
+}
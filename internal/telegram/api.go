@@ -0,0 +1,100 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/httpclient"
+)
+
+const DefaultAPIEndpoint = "https://api.telegram.org"
+
+// Client is the HTTP client all Bot API calls go through. It applies the
+// configured proxy and gives each request its own timeout, extended by the
+// long-poll duration for getUpdates.
+type Client struct {
+	http    *http.Client
+	timeout time.Duration
+}
+
+// NewClient builds a Client from the telegram section of the config. With no
+// proxy configured the usual HTTPS_PROXY/NO_PROXY environment applies.
+func NewClient(cfg config.TelegramConfig) (*Client, error) {
+	transport, err := httpclient.Transport(cfg.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("telegram.proxy: %w", err)
+	}
+	return &Client{
+		http:    &http.Client{Transport: transport},
+		timeout: httpclient.Timeout(cfg.RequestTimeout),
+	}, nil
+}
+
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	timeout := c.timeout
+	if strings.HasSuffix(req.URL.Path, "/getUpdates") {
+		timeout += pollTimeout * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// NewAPI creates a Bot API client for the configured endpoint, proxy and
+// timeouts, and verifies the token with getMe.
+func NewAPI(cfg config.TelegramConfig, token string) (*tgbotapi.BotAPI, error) {
+	client, err := NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return tgbotapi.NewBotAPIWithClient(token, endpointFormat(cfg.APIEndpoint), client)
+}
+
+// ScrubError removes the bot token from transport errors, which include the
+// request URL, so it doesn't end up in logs or status output.
+func ScrubError(err error, token string) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s %s: %w", urlErr.Op, strings.ReplaceAll(urlErr.URL, token, "<token>"), urlErr.Err)
+	}
+	return err
+}
+
+// IsUnauthorized reports whether Telegram rejected the bot token.
+func IsUnauthorized(err error) bool {
+	return isUnauthorized(err)
+}
+
+// endpointFormat turns a base URL like https://api.telegram.org into the
+// method URL format tgbotapi expects.
+func endpointFormat(base string) string {
+	if base == "" {
+		base = DefaultAPIEndpoint
+	}
+	return strings.TrimRight(base, "/") + "/bot%s/%s"
+}
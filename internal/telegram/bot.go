@@ -2,82 +2,395 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"sync"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"github.com/bupd/go-claude-code-telegram/internal/config"
 	"github.com/bupd/go-claude-code-telegram/internal/session"
+	"github.com/bupd/go-claude-code-telegram/internal/state"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
 )
 
 const MaxMessageLength = 4096
 
+const (
+	pollTimeout = 60
+	minBackoff  = time.Second
+	maxBackoff  = 2 * time.Minute
+	outboxSize  = 100
+	// noticeTimeout bounds how long a status message may wait in the
+	// outbox.
+	noticeTimeout = time.Minute
+)
+
+var _ transport.Transport = (*Bot)(nil)
+
+// Bot is the Telegram transport.
 type Bot struct {
-	api      *tgbotapi.BotAPI
-	config   *config.Config
+	token    string
 	sessions *session.Manager
+	store    *state.Store
+	health   health
+	outbox   chan *outboxItem
+	incoming chan transport.Incoming
+
+	mu  sync.RWMutex
+	api *tgbotapi.BotAPI
+}
+
+// outboxItem is a message waiting to be sent. Messages queue here while
+// Telegram is unreachable and are delivered in order once connected.
+type outboxItem struct {
+	ctx    context.Context
+	msg    tgbotapi.Chattable
+	result chan sendResult
+}
+
+type sendResult struct {
+	msg tgbotapi.Message
+	err error
 }
 
-func NewBot(cfg *config.Config, sessions *session.Manager) (*Bot, error) {
-	api, err := tgbotapi.NewBotAPI(cfg.Telegram.BotToken)
+// NewBot prepares the bot without contacting Telegram; the connection is
+// made in the background by Start. Processed update IDs are recorded in
+// store so a restart resumes where it left off.
+func NewBot(cfg *config.Config, sessions *session.Manager, store *state.Store) (*Bot, error) {
+	token, err := cfg.Telegram.Token()
 	if err != nil {
-		return nil, fmt.Errorf("creating bot api: %w", err)
+		return nil, err
 	}
 
-	return &Bot{
-		api:      api,
-		config:   cfg,
+	b := &Bot{
+		token:    token,
 		sessions: sessions,
-	}, nil
+		store:    store,
+		outbox:   make(chan *outboxItem, outboxSize),
+		incoming: make(chan transport.Incoming, outboxSize),
+	}
+	b.health.h.State = StateConnecting
+	b.health.changed = func(h Health) {
+		lastError := h.LastError
+		if h.State == StateConnected {
+			lastError = ""
+		}
+		sessions.PublishConnection(transport.Telegram, h.State, lastError)
+	}
+	return b, nil
+}
+
+func (b *Bot) Name() string {
+	return transport.Telegram
+}
+
+func (b *Bot) Capabilities() transport.Capabilities {
+	return transport.Capabilities{
+		Edit:             true,
+		Attachments:      true,
+		Replies:          true,
+		Choices:          true,
+		Formats:          []string{config.FormatMarkdown, config.FormatHTML},
+		MaxMessageLength: MaxMessageLength,
+	}
 }
 
+func (b *Bot) Incoming() <-chan transport.Incoming {
+	return b.incoming
+}
+
+// Health returns the current state of the connection to Telegram.
+func (b *Bot) Health() Health {
+	return b.health.get()
+}
+
+// Username returns the bot's username, or "" before the first connection.
+func (b *Bot) Username() string {
+	if api := b.getAPI(); api != nil {
+		return api.Self.UserName
+	}
+	return ""
+}
+
+func (b *Bot) getAPI() *tgbotapi.BotAPI {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.api
+}
+
+// Start connects to Telegram, retrying with exponential backoff while it is
+// unreachable, then delivers the outbox and handles updates until ctx is
+// done.
 func (b *Bot) Start(ctx context.Context) error {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	api, err := b.connect(ctx)
+	if err != nil || api == nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.api = api
+	b.mu.Unlock()
+	log.Printf("connected to telegram as @%s", api.Self.UserName)
 
-	updates := b.api.GetUpdatesChan(u)
+	updates := make(chan tgbotapi.Update, 100)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- b.poll(ctx, updates)
+	}()
+	go b.deliver(ctx)
 
-	b.notifyAllSessions("cctg daemon started")
+	b.notifyAllSessions(ctx, "cctg daemon started")
 
 	for {
 		select {
 		case <-ctx.Done():
-			b.notifyAllSessions("cctg daemon stopped")
+			b.sendNow("cctg daemon stopped")
 			return nil
+		case err := <-errCh:
+			return err
 		case update := <-updates:
-			if update.Message == nil {
+			if update.Message != nil {
+				b.handleMessage(ctx, update.Message)
+			}
+			if update.CallbackQuery != nil {
+				b.handleCallback(ctx, update.CallbackQuery)
+			}
+			if err := b.store.SetOffset(update.UpdateID); err != nil {
+				log.Printf("saving update offset: %v", err)
+			}
+		}
+	}
+}
+
+func (b *Bot) connect(ctx context.Context) (*tgbotapi.BotAPI, error) {
+	backoff := minBackoff
+	for {
+		api, err := NewAPI(b.sessions.Config().Telegram, b.token)
+		err = b.scrub(err)
+		if err == nil {
+			b.health.ok(false)
+			return api, nil
+		}
+		if isUnauthorized(err) {
+			return nil, fmt.Errorf("telegram rejected the bot token: %w", err)
+		}
+
+		b.health.fail(err)
+		log.Printf("cannot reach telegram, retrying in %s: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// deliver sends outbox messages in order. A message that fails because
+// Telegram is unreachable is retried until its context expires; errors from
+// Telegram itself are returned to the sender.
+func (b *Bot) deliver(ctx context.Context) {
+	for {
+		var item *outboxItem
+		select {
+		case <-ctx.Done():
+			return
+		case item = <-b.outbox:
+		}
+
+		backoff := minBackoff
+		for {
+			if err := item.ctx.Err(); err != nil {
+				item.result <- sendResult{err: err}
+				break
+			}
+
+			sent, err := b.getAPI().Send(item.msg)
+			err = b.scrub(err)
+			var tgErr *tgbotapi.Error
+			if err == nil || errors.As(err, &tgErr) {
+				item.result <- sendResult{msg: sent, err: err}
+				break
+			}
+
+			b.health.fail(err)
+			log.Printf("sending message failed, retrying in %s: %v", backoff, err)
+			select {
+			case <-ctx.Done():
+				item.result <- sendResult{err: ctx.Err()}
+				return
+			case <-item.ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxBackoff)
+		}
+	}
+}
+
+// send queues msg in the outbox and waits for it to be delivered or for ctx
+// to expire.
+func (b *Bot) send(ctx context.Context, msg tgbotapi.Chattable) (tgbotapi.Message, error) {
+	item := &outboxItem{ctx: ctx, msg: msg, result: make(chan sendResult, 1)}
+
+	select {
+	case b.outbox <- item:
+	case <-ctx.Done():
+		return tgbotapi.Message{}, ctx.Err()
+	}
+
+	select {
+	case res := <-item.result:
+		return res.msg, res.err
+	case <-ctx.Done():
+		return tgbotapi.Message{}, ctx.Err()
+	}
+}
+
+// poll long-polls getUpdates until ctx is done. Failures are retried with
+// exponential backoff. A 409 Conflict means another process is polling with
+// the same token and Telegram is splitting updates between us; depending on
+// telegram.on_conflict we keep retrying or give up so the service manager
+// sees a failure.
+func (b *Bot) poll(ctx context.Context, updates chan<- tgbotapi.Update) error {
+	api := b.getAPI()
+	u := tgbotapi.NewUpdate(0)
+	if last := b.store.Offset(); last > 0 {
+		u.Offset = last + 1
+		log.Printf("resuming updates after %d", last)
+	}
+	u.Timeout = pollTimeout
+	backoff := minBackoff
+
+	for ctx.Err() == nil {
+		batch, err := api.GetUpdates(u)
+		err = b.scrub(err)
+		if err != nil {
+			if b.health.fail(err) {
+				log.Printf("%v", ErrConflict)
+			}
+			if isConflict(err) && b.sessions.Config().Telegram.OnConflict == config.ConflictExit {
+				return ErrConflict
+			}
+			if !isConflict(err) {
+				log.Printf("getUpdates failed, retrying in %s: %v", backoff, err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+
+		if b.health.ok(len(batch) > 0) {
+			log.Printf("telegram getUpdates conflict resolved")
+		}
+		backoff = minBackoff
+
+		for _, update := range batch {
+			if update.UpdateID < u.Offset {
 				continue
 			}
-			b.handleMessage(update.Message)
+			u.Offset = update.UpdateID + 1
+			select {
+			case updates <- update:
+			case <-ctx.Done():
+				return nil
+			}
 		}
 	}
+	return nil
+}
+
+func (b *Bot) scrub(err error) error {
+	return ScrubError(err, b.token)
 }
 
-func (b *Bot) handleMessage(msg *tgbotapi.Message) {
-	if !b.isAllowedUser(msg.From.ID) {
+// handleMessage passes messages from allowed users on to Incoming.
+func (b *Bot) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
+	if msg.From == nil || !b.isAllowedUser(msg.From.ID) {
+		return
+	}
+
+	if n := b.sessions.Config().Telegram.DiscardBacklogMinutes; n > 0 && time.Since(msg.Time()) > time.Duration(n)*time.Minute {
+		log.Printf("discarding message %d in chat %d: older than %d minutes", msg.MessageID, msg.Chat.ID, n)
 		return
 	}
 
-	chatID := msg.Chat.ID
+	in := transport.Incoming{
+		Chat: transport.Address{Transport: transport.Telegram, Chat: strconv.FormatInt(msg.Chat.ID, 10)},
+		ID:   strconv.Itoa(msg.MessageID),
+		User: strconv.FormatInt(msg.From.ID, 10),
+		Text: msg.Text,
+		Time: msg.Time(),
+		// Only what Telegram marks as a bot command, not any text
+		// starting with a slash.
+		Command: msg.IsCommand(),
+	}
+	if msg.ReplyToMessage != nil {
+		in.ReplyTo = strconv.Itoa(msg.ReplyToMessage.MessageID)
+	}
 
-	if b.sessions.TryCaptureChat(chatID) {
+	select {
+	case b.incoming <- in:
+	case <-ctx.Done():
+	}
+}
+
+// handleCallback turns a press on a choice button into a reply to the
+// message carrying the buttons.
+func (b *Bot) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery) {
+	// Stop the button's progress indicator whoever pressed it.
+	go func() {
+		if _, err := b.getAPI().Request(tgbotapi.NewCallback(q.ID, "")); err != nil {
+			log.Printf("answering callback query: %v", b.scrub(err))
+		}
+	}()
+
+	if q.From == nil || !b.isAllowedUser(q.From.ID) || q.Message == nil {
 		return
 	}
 
-	if b.sessions.HasPendingForChat(chatID) {
-		replyToMsgID := 0
-		if msg.ReplyToMessage != nil {
-			replyToMsgID = msg.ReplyToMessage.MessageID
+	in := transport.Incoming{
+		Chat:    transport.Address{Transport: transport.Telegram, Chat: strconv.FormatInt(q.Message.Chat.ID, 10)},
+		ID:      q.ID,
+		User:    strconv.FormatInt(q.From.ID, 10),
+		Text:    choiceText(q),
+		ReplyTo: strconv.Itoa(q.Message.MessageID),
+		Choice:  true,
+		Time:    time.Now(),
+	}
+
+	select {
+	case b.incoming <- in:
+	case <-ctx.Done():
+	}
+}
+
+// choiceText returns the label of the pressed button. Callback data is only
+// the choice's index, since labels can exceed its 64 byte limit.
+func choiceText(q *tgbotapi.CallbackQuery) string {
+	if markup := q.Message.ReplyMarkup; markup != nil {
+		for _, row := range markup.InlineKeyboard {
+			for _, button := range row {
+				if button.CallbackData != nil && *button.CallbackData == q.Data {
+					return button.Text
+				}
+			}
 		}
-		b.sessions.HandleReply(chatID, replyToMsgID, msg.Text)
-	} else {
-		b.sessions.QueueMessage(chatID, msg.Text)
 	}
+	return q.Data
 }
 
 func (b *Bot) isAllowedUser(userID int64) bool {
-	for _, allowed := range b.config.Telegram.AllowedUsers {
+	for _, allowed := range b.sessions.Config().Telegram.AllowedUsers {
 		if allowed == userID {
 			return true
 		}
@@ -85,29 +398,108 @@ func (b *Bot) isAllowedUser(userID int64) bool {
 	return false
 }
 
-func (b *Bot) SendMessage(chatID int64, text string) (int, error) {
-	if len(text) > MaxMessageLength {
-		return 0, fmt.Errorf("message exceeds %d character limit", MaxMessageLength)
+// Send sends msg to chat and returns the Telegram message ID. Attachments
+// follow as documents replying to the text. If Telegram is unreachable the
+// messages wait in the outbox until they can be delivered or ctx expires.
+func (b *Bot) Send(ctx context.Context, chat string, msg transport.Message) (string, error) {
+	chatID, err := strconv.ParseInt(chat, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid telegram chat ID %q", chat)
+	}
+	if len(msg.Text) > MaxMessageLength {
+		return "", fmt.Errorf("message exceeds %d character limit", MaxMessageLength)
 	}
 
-	msg := tgbotapi.NewMessage(chatID, text)
-	sent, err := b.api.Send(msg)
+	text := tgbotapi.NewMessage(chatID, msg.Text)
+	text.ParseMode = parseMode(msg.Format)
+	if len(msg.Choices) > 0 {
+		var rows [][]tgbotapi.InlineKeyboardButton
+		for i, c := range msg.Choices {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(c, strconv.Itoa(i))))
+		}
+		text.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	sent, err := b.send(ctx, text)
+	if err != nil {
+		return "", fmt.Errorf("sending message: %w", err)
+	}
+
+	for _, a := range msg.Attachments {
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FilePath(a.Path))
+		doc.ReplyToMessageID = sent.MessageID
+		if _, err := b.send(ctx, doc); err != nil {
+			return "", fmt.Errorf("sending %s: %w", a.Path, err)
+		}
+	}
+	return strconv.Itoa(sent.MessageID), nil
+}
+
+// Edit replaces the text of message id in chat.
+func (b *Bot) Edit(ctx context.Context, chat, id, text string) error {
+	chatID, err := strconv.ParseInt(chat, 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("sending message: %w", err)
+		return fmt.Errorf("invalid telegram chat ID %q", chat)
 	}
-	return sent.MessageID, nil
+	msgID, err := strconv.Atoi(id)
+	if err != nil {
+		return fmt.Errorf("invalid telegram message ID %q", id)
+	}
+
+	if _, err := b.send(ctx, tgbotapi.NewEditMessageText(chatID, msgID, text)); err != nil {
+		return fmt.Errorf("editing message: %w", err)
+	}
+	return nil
 }
 
-func (b *Bot) notifyAllSessions(text string) {
-	for _, sess := range b.config.Sessions {
-		msg := tgbotapi.NewMessage(sess.ChatID, text)
-		if _, err := b.api.Send(msg); err != nil {
-			log.Printf("failed to notify chat %d: %v", sess.ChatID, err)
+func parseMode(format string) string {
+	switch format {
+	case config.FormatMarkdown:
+		return tgbotapi.ModeMarkdown
+	case config.FormatHTML:
+		return tgbotapi.ModeHTML
+	default:
+		return ""
+	}
+}
+
+// notifyAllSessions sends a status message to every Telegram session, one
+// after another, without holding up the caller. Each gives up after
+// noticeTimeout, or when ctx is done.
+func (b *Bot) notifyAllSessions(ctx context.Context, text string) {
+	chats := b.chats()
+	go func() {
+		for _, chatID := range chats {
+			ctx, cancel := context.WithTimeout(ctx, noticeTimeout)
+			_, err := b.send(ctx, tgbotapi.NewMessage(chatID, text))
+			cancel()
+			if err != nil {
+				log.Printf("failed to notify chat %d: %v", chatID, err)
+			}
+		}
+	}()
+}
+
+// sendNow sends text to every Telegram session directly, bypassing the
+// outbox, for use while shutting down.
+func (b *Bot) sendNow(text string) {
+	api := b.getAPI()
+	if api == nil {
+		return
+	}
+	for _, chatID := range b.chats() {
+		if _, err := api.Send(tgbotapi.NewMessage(chatID, text)); err != nil {
+			log.Printf("failed to notify chat %d: %v", chatID, err)
 		}
 	}
 }
 
-func (b *Bot) NotifyTimeout(chatID int64) {
-	msg := tgbotapi.NewMessage(chatID, "timeout: no reply received")
-	b.api.Send(msg)
+// chats returns the chat IDs of the sessions using Telegram.
+func (b *Bot) chats() []int64 {
+	var ids []int64
+	for _, sess := range b.sessions.Config().Sessions {
+		if sess.TransportName() == transport.Telegram {
+			ids = append(ids, sess.ChatID)
+		}
+	}
+	return ids
 }
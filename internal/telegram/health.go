@@ -0,0 +1,99 @@
+package telegram
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	StateConnecting = "connecting"
+	StateConnected  = "connected"
+	StateConflict   = "conflict"
+	StateError      = "error"
+	// StateDisabled means no session uses Telegram, so the bot isn't running.
+	StateDisabled = "disabled"
+)
+
+var ErrConflict = errors.New("telegram getUpdates conflict: another process is polling with this bot token")
+
+// Health is a snapshot of the bot's connection to Telegram.
+type Health struct {
+	State         string
+	LastError     string
+	LastErrorAt   time.Time
+	ConflictSince time.Time
+	LastUpdateAt  time.Time
+}
+
+type health struct {
+	mu sync.Mutex
+	h  Health
+	// changed, if set, is called with the lock held when State changes.
+	changed func(Health)
+}
+
+func (h *health) get() Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.h
+}
+
+// ok records a successful poll and reports whether a conflict just ended.
+func (h *health) ok(gotUpdates bool) (resolvedConflict bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	resolvedConflict = h.h.State == StateConflict
+	h.setState(StateConnected)
+	h.h.ConflictSince = time.Time{}
+	if gotUpdates {
+		h.h.LastUpdateAt = time.Now()
+	}
+	return resolvedConflict
+}
+
+// fail records a failed request and reports whether it started a conflict.
+// Before the first successful connection the state stays "connecting".
+func (h *health) fail(err error) (newConflict bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	h.h.LastError = err.Error()
+	h.h.LastErrorAt = now
+	if !isConflict(err) {
+		if h.h.State != StateConnecting {
+			h.setState(StateError)
+		}
+		return false
+	}
+	newConflict = h.h.State != StateConflict
+	if newConflict {
+		h.h.ConflictSince = now
+	}
+	h.setState(StateConflict)
+	return newConflict
+}
+
+// setState records a new state. h.mu must be held.
+func (h *health) setState(state string) {
+	if h.h.State == state {
+		return
+	}
+	h.h.State = state
+	if h.changed != nil {
+		h.changed(h.h)
+	}
+}
+
+func isUnauthorized(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && tgErr.Code == http.StatusUnauthorized
+}
+
+func isConflict(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && tgErr.Code == http.StatusConflict
+}
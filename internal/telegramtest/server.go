@@ -0,0 +1,404 @@
+// Package telegramtest provides an in-process fake of the Telegram Bot API
+// for tests. Point telegram.api_endpoint at Server.URL and use the bot token
+// the server was created with.
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bot is the identity getMe reports.
+var Bot = User{ID: 1000, Username: "cctg_test_bot"}
+
+// User is the sender of an injected message or button press.
+type User struct {
+	ID       int64
+	Username string
+}
+
+// Call is a Bot API request the server received.
+type Call struct {
+	Method string
+	Params url.Values
+	// File is the name of the uploaded file for sendDocument.
+	File string
+	// MessageID is the ID of the message sent or edited by the call.
+	MessageID int
+}
+
+// Message is a message in a fake chat, sent either by the bot or by a user.
+type Message struct {
+	ID      int
+	ChatID  int64
+	From    User
+	Text    string
+	ReplyTo int
+	// ReplyMarkup is the JSON keyboard the bot attached, if any.
+	ReplyMarkup string
+}
+
+type Server struct {
+	URL   string
+	token string
+	srv   *httptest.Server
+
+	mu       sync.Mutex
+	changed  chan struct{} // closed and replaced whenever calls or updates change
+	closed   chan struct{}
+	calls    []Call
+	updates  []map[string]any
+	messages map[int]*Message
+	nextMsg  int
+	nextCb   int
+}
+
+// NewServer starts a fake Bot API that accepts token.
+func NewServer(token string) *Server {
+	s := &Server{
+		token:    token,
+		changed:  make(chan struct{}),
+		closed:   make(chan struct{}),
+		messages: make(map[int]*Message),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close ends pending long polls and shuts the server down.
+func (s *Server) Close() {
+	close(s.closed)
+	s.srv.Close()
+}
+
+// PostMessage delivers text from a user in chatID to the bot and returns
+// the new message ID.
+func (s *Server) PostMessage(chatID int64, from User, text string) int {
+	return s.post(&Message{ChatID: chatID, From: from, Text: text})
+}
+
+// PostReply delivers text as a reply to message replyTo.
+func (s *Server) PostReply(chatID int64, from User, replyTo int, text string) int {
+	return s.post(&Message{ChatID: chatID, From: from, Text: text, ReplyTo: replyTo})
+}
+
+// PressButton delivers a callback query for an inline keyboard button on
+// messageID and returns the callback query ID.
+func (s *Server) PressButton(chatID int64, from User, messageID int, data string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextCb++
+	id := strconv.Itoa(s.nextCb)
+	q := map[string]any{
+		"id":            id,
+		"from":          userJSON(from, false),
+		"data":          data,
+		"chat_instance": strconv.FormatInt(chatID, 10),
+	}
+	if msg := s.messages[messageID]; msg != nil {
+		q["message"] = s.messageJSON(msg)
+	}
+	s.addUpdate("callback_query", q)
+	return id
+}
+
+func (s *Server) post(msg *Message) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.store(msg)
+	s.addUpdate("message", s.messageJSON(msg))
+	return msg.ID
+}
+
+// Calls returns the requests received so far, optionally only those for the
+// given methods.
+func (s *Server) Calls(methods ...string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return filterCalls(s.calls, methods)
+}
+
+// WaitCall waits until a request matching match has been received and
+// returns the first one.
+func (s *Server) WaitCall(timeout time.Duration, match func(Call) bool) (Call, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		for _, c := range s.calls {
+			if match(c) {
+				s.mu.Unlock()
+				return c, nil
+			}
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return Call{}, fmt.Errorf("no matching Bot API call within %s", timeout)
+		}
+	}
+}
+
+// WaitSent waits for the bot to send text to chatID and returns the message.
+func (s *Server) WaitSent(timeout time.Duration, chatID int64, text string) (Message, error) {
+	chat := strconv.FormatInt(chatID, 10)
+	c, err := s.WaitCall(timeout, func(c Call) bool {
+		return c.Method == "sendMessage" && c.Params.Get("chat_id") == chat && c.Params.Get("text") == text
+	})
+	if err != nil {
+		return Message{}, fmt.Errorf("waiting for %q in chat %d: %w", text, chatID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.messages[c.MessageID], nil
+}
+
+func filterCalls(calls []Call, methods []string) []Call {
+	var out []Call
+	for _, c := range calls {
+		if len(methods) == 0 || contains(methods, c.Method) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// notify wakes everyone waiting on calls or updates. The caller must hold
+// s.mu.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) store(msg *Message) {
+	s.nextMsg++
+	msg.ID = s.nextMsg
+	s.messages[msg.ID] = msg
+}
+
+func (s *Server) addUpdate(kind string, payload map[string]any) {
+	s.updates = append(s.updates, map[string]any{
+		"update_id": len(s.updates) + 1,
+		kind:        payload,
+	})
+	s.notify()
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	token, method, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/bot"), "/")
+	if !ok || token != s.token {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	call := Call{Method: method}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		call.Params = url.Values(r.MultipartForm.Value)
+		if fh := r.MultipartForm.File["document"]; len(fh) > 0 {
+			call.File = fh[0].Filename
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		call.Params = r.PostForm
+	}
+
+	if method == "getUpdates" {
+		s.record(call)
+		s.getUpdates(w, r, call.Params)
+		return
+	}
+
+	s.mu.Lock()
+	result, code, desc := s.dispatch(&call)
+	s.calls = append(s.calls, call)
+	s.notify()
+	s.mu.Unlock()
+
+	if code != 0 {
+		writeError(w, code, desc)
+		return
+	}
+	writeResult(w, result)
+}
+
+func (s *Server) record(call Call) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+	s.notify()
+}
+
+// dispatch answers every method except getUpdates. The caller must hold
+// s.mu.
+func (s *Server) dispatch(call *Call) (result any, code int, desc string) {
+	p := call.Params
+	chatID, _ := strconv.ParseInt(p.Get("chat_id"), 10, 64)
+
+	switch call.Method {
+	case "getMe":
+		return userJSON(Bot, true), 0, ""
+
+	case "sendMessage", "sendDocument":
+		msg := &Message{ChatID: chatID, From: Bot, Text: p.Get("text")}
+		if call.Method == "sendDocument" {
+			msg.Text = p.Get("caption")
+		}
+		msg.ReplyTo, _ = strconv.Atoi(p.Get("reply_to_message_id"))
+		msg.ReplyMarkup = p.Get("reply_markup")
+		s.store(msg)
+		call.MessageID = msg.ID
+
+		out := s.messageJSON(msg)
+		if call.Method == "sendDocument" {
+			out["document"] = map[string]any{"file_id": "file" + strconv.Itoa(msg.ID), "file_name": call.File}
+		}
+		return out, 0, ""
+
+	case "editMessageText":
+		id, _ := strconv.Atoi(p.Get("message_id"))
+		msg := s.messages[id]
+		if msg == nil || msg.ChatID != chatID {
+			return nil, http.StatusBadRequest, "Bad Request: message to edit not found"
+		}
+		msg.Text = p.Get("text")
+		// Like Telegram, an edit without a keyboard removes the old one.
+		msg.ReplyMarkup = p.Get("reply_markup")
+		call.MessageID = id
+		return s.messageJSON(msg), 0, ""
+
+	case "answerCallbackQuery", "sendChatAction":
+		return true, 0, ""
+
+	case "getChat":
+		return chatJSON(chatID), 0, ""
+
+	case "getChatMember":
+		userID, _ := strconv.ParseInt(p.Get("user_id"), 10, 64)
+		return map[string]any{
+			"status": "member",
+			"user":   userJSON(User{ID: userID}, userID == Bot.ID),
+		}, 0, ""
+
+	default:
+		return nil, http.StatusNotFound, "Not Found"
+	}
+}
+
+// getUpdates long-polls like the real API: it answers as soon as there are
+// updates at or after offset, or with an empty list after timeout seconds.
+func (s *Server) getUpdates(w http.ResponseWriter, r *http.Request, p url.Values) {
+	offset, _ := strconv.Atoi(p.Get("offset"))
+	timeout, _ := strconv.Atoi(p.Get("timeout"))
+	deadline := time.After(time.Duration(timeout) * time.Second)
+
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		var batch []map[string]any
+		for _, u := range s.updates {
+			if u["update_id"].(int) >= offset {
+				batch = append(batch, u)
+			}
+		}
+		s.mu.Unlock()
+
+		if len(batch) > 0 {
+			writeResult(w, batch)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			writeResult(w, []any{})
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			writeResult(w, []any{})
+			return
+		}
+	}
+}
+
+func (s *Server) messageJSON(msg *Message) map[string]any {
+	out := map[string]any{
+		"message_id": msg.ID,
+		"from":       userJSON(msg.From, msg.From.ID == Bot.ID),
+		"date":       time.Now().Unix(),
+		"chat":       chatJSON(msg.ChatID),
+		"text":       msg.Text,
+	}
+	if orig := s.messages[msg.ReplyTo]; orig != nil {
+		out["reply_to_message"] = s.messageJSON(orig)
+	}
+	if msg.ReplyMarkup != "" {
+		out["reply_markup"] = json.RawMessage(msg.ReplyMarkup)
+	}
+	if cmd := botCommand.FindString(msg.Text); cmd != "" {
+		out["entities"] = []map[string]any{{"type": "bot_command", "offset": 0, "length": len(cmd)}}
+	}
+	return out
+}
+
+// botCommand matches a command at the start of a message, which Telegram
+// marks with a bot_command entity.
+var botCommand = regexp.MustCompile(`^/[A-Za-z0-9_]{1,32}(@[A-Za-z0-9_]+)?`)
+
+func userJSON(u User, isBot bool) map[string]any {
+	return map[string]any{
+		"id":         u.ID,
+		"is_bot":     isBot,
+		"first_name": "user" + strconv.FormatInt(u.ID, 10),
+		"username":   u.Username,
+	}
+}
+
+func chatJSON(chatID int64) map[string]any {
+	chatType := "private"
+	if chatID < 0 {
+		chatType = "supergroup"
+	}
+	return map[string]any{"id": chatID, "type": chatType}
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func writeError(w http.ResponseWriter, code int, desc string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": code, "description": desc})
+}
@@ -0,0 +1,59 @@
+// Package testutil has the helpers the transport and dashboard tests share
+// for running a server in the background.
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Timeout bounds every wait, so a server that fails to start or stop fails
+// the test instead of hanging it.
+const Timeout = 5 * time.Second
+
+// Start runs start in the background until the test ends and returns the
+// channel its result arrives on. Cleanup cancels start's context and waits
+// for it to return.
+func Start(t testing.TB, start func(context.Context) error) chan error {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-errCh:
+		case <-time.After(Timeout):
+			t.Error("server did not stop")
+		}
+	})
+	return errCh
+}
+
+// WaitReady waits until ready is closed, failing the test if the server
+// returns first.
+func WaitReady(t testing.TB, ready <-chan struct{}, errCh chan error) {
+	t.Helper()
+	select {
+	case <-ready:
+	case err := <-errCh:
+		errCh <- err
+		t.Fatalf("server stopped before it was ready: %v", err)
+	case <-time.After(Timeout):
+		t.Fatal("server did not become ready")
+	}
+}
+
+// Stopped waits for the server to return on its own and returns its error.
+func Stopped(t testing.TB, errCh chan error) error {
+	t.Helper()
+	select {
+	case err := <-errCh:
+		errCh <- err
+		return err
+	case <-time.After(Timeout):
+		t.Fatal("server kept running")
+		return nil
+	}
+}
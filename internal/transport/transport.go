@@ -0,0 +1,114 @@
+// Package transport defines the interface between the daemon and the
+// messengers it asks questions through.
+package transport
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// Telegram is the transport sessions use unless they choose another one.
+	Telegram = "telegram"
+	Matrix   = "matrix"
+	Slack    = "slack"
+	// Webhook posts questions to a URL and takes answers on a callback.
+	Webhook = "webhook"
+	// Console asks questions on the daemon's terminal.
+	Console = "console"
+)
+
+// ConsoleChat is the only chat the console transport has: the terminal.
+const ConsoleChat = "tty"
+
+// WebhookChat is the only chat the webhook transport has. Answers name the
+// question they belong to, so sessions don't need chats of their own.
+const WebhookChat = "hook"
+
+// ErrNotSupported is returned for operations a transport can't perform; check
+// Capabilities first.
+var ErrNotSupported = errors.New("not supported by this transport")
+
+// Address identifies a chat on a transport. Chat is in the transport's own
+// terms, e.g. a Telegram chat ID.
+type Address struct {
+	Transport string
+	Chat      string
+}
+
+func (a Address) String() string {
+	return a.Transport + ":" + a.Chat
+}
+
+// Message is an outgoing message.
+type Message struct {
+	// Session is the name of the asking session, for transports that show
+	// it.
+	Session string
+	Text    string
+	// Format is one of the config.Format* values; "" is plain text.
+	Format      string
+	Attachments []Attachment
+	// Choices are answers offered as buttons. Pressing one arrives as a
+	// reply to the message with the choice as its text.
+	Choices []string
+	// Notice marks a message that expects no answer.
+	Notice bool
+}
+
+// Attachment is a file sent along with a message.
+type Attachment struct {
+	// Path is the file on the daemon's host.
+	Path string
+}
+
+// Incoming is a message from an allowed user.
+type Incoming struct {
+	Chat Address
+	// ID is the transport's ID for the message.
+	ID   string
+	User string
+	Text string
+	// ReplyTo is the ID of the message this one replies to, if any.
+	ReplyTo string
+	// Choice reports that this is a press on a choice button of message
+	// ReplyTo rather than a typed message.
+	Choice bool
+	// Command reports that the messenger marked the text as a bot command,
+	// such as /status on Telegram.
+	Command bool
+	Time    time.Time
+}
+
+// Capabilities describes what a transport can do beyond plain text.
+type Capabilities struct {
+	Edit        bool
+	Attachments bool
+	// Replies reports whether users can reply to a specific message, so
+	// answers to concurrent questions can be told apart.
+	Replies bool
+	// Choices reports whether Message.Choices are shown as buttons.
+	Choices bool
+	Formats []string
+	// MaxMessageLength is the longest text Send accepts, 0 for no limit.
+	MaxMessageLength int
+}
+
+// Transport is a messenger the daemon can send questions through and receive
+// answers from.
+type Transport interface {
+	Name() string
+	Capabilities() Capabilities
+
+	// Start connects and delivers incoming messages until ctx is done. Sends
+	// made before the connection is up wait for it.
+	Start(ctx context.Context) error
+	// Incoming returns the stream of messages from allowed users.
+	Incoming() <-chan Incoming
+
+	// Send delivers msg to chat and returns the ID of the sent message.
+	Send(ctx context.Context, chat string, msg Message) (string, error)
+	// Edit replaces the text of a message sent earlier.
+	Edit(ctx context.Context, chat, id, text string) error
+}
@@ -0,0 +1,20 @@
+package version
+
+import "runtime/debug"
+
+// Version is set at build time with
+//
+//	-ldflags "-X github.com/bupd/go-claude-code-telegram/internal/version.Version=v1.2.3"
+var Version = "dev"
+
+// String returns the build version, falling back to the module version
+// recorded by "go install" when Version wasn't set.
+func String() string {
+	if Version != "dev" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return Version
+}
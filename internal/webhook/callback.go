@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+const (
+	maxBodySize = 1 << 20
+	// maxClockSkew is how old a signed callback may be before it is treated
+	// as a replay.
+	maxClockSkew = 5 * time.Minute
+)
+
+// Answer is the JSON body of an answer callback.
+type Answer struct {
+	Text string `json:"text"`
+	// User identifies who answered, for the logs.
+	User string `json:"user,omitempty"`
+}
+
+// AnswerPath is the callback path for the answer to question id.
+func AnswerPath(id string) string {
+	return "/answers/" + id
+}
+
+func (t *Transport) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /answers/{id}", t.handleAnswer)
+	return mux
+}
+
+func (t *Transport) handleAnswer(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "reading body")
+		return
+	}
+	id := r.PathValue("id")
+	if err := verify(t.secret, r.Method, AnswerPath(id), r.Header, body, time.Now()); err != nil {
+		log.Printf("webhook: rejecting answer from %s: %v", r.RemoteAddr, err)
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var a Answer
+	if err := json.Unmarshal(body, &a); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if a.Text == "" {
+		writeError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+
+	// Answered by ID only: an answer to a question that is gone must not
+	// be taken as an answer to whatever is oldest.
+	addr := transport.Address{Transport: transport.Webhook, Chat: transport.WebhookChat}
+	if !t.sessions.AnswerMessage(addr, id, a.Text, transport.Webhook) {
+		writeError(w, http.StatusNotFound, "no open question with this ID")
+		return
+	}
+	user := a.User
+	if user == "" {
+		user = "webhook"
+	}
+	log.Printf("webhook: question %s answered by %s", id, user)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	io.WriteString(w, `{"status":"accepted"}`+"\n")
+}
+
+// verify checks a callback's signature, made the same way as deliveries'.
+func verify(secret, method, path string, h http.Header, body []byte, now time.Time) error {
+	ts := h.Get(TimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid " + TimestampHeader)
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > maxClockSkew || age < -maxClockSkew {
+		return errors.New("request timestamp is too far from now")
+	}
+	if !hmac.Equal([]byte(Sign(secret, ts, method, path, body)), []byte(h.Get(SignatureHeader))) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,329 @@
+// Package webhook is a transport for custom integrations: every question is
+// POSTed as signed JSON to a configured URL, and the answer comes back on a
+// callback endpoint the daemon hosts, keyed by the question ID.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/session"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+const (
+	DefaultListen      = "127.0.0.1:8378"
+	DefaultMaxAttempts = 5
+
+	// Headers on deliveries and answer callbacks.
+	SignatureHeader = "X-Cctg-Signature"
+	TimestampHeader = "X-Cctg-Timestamp"
+	EventHeader     = "X-Cctg-Event"
+	DeliveryHeader  = "X-Cctg-Delivery"
+
+	// Event types.
+	EventQuestion = "question"
+	EventEdit     = "edit"
+	// EventNotice is a message that expects no answer: daemon status such as
+	// a timeout, or a notify request.
+	EventNotice = "notice"
+
+	requestTimeout = 30 * time.Second
+	minBackoff     = time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+var _ transport.Transport = (*Transport)(nil)
+
+// Delivery is the JSON body POSTed to the webhook URL.
+type Delivery struct {
+	Event   string `json:"event"`
+	ID      string `json:"id"`
+	Session string `json:"session,omitempty"`
+	Text    string `json:"text"`
+	Format  string `json:"format,omitempty"`
+	// Choices are the answers the asker offered.
+	Choices []string `json:"choices,omitempty"`
+	// CallbackURL is where the answer to a question is POSTed.
+	CallbackURL string    `json:"callback_url,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+type Transport struct {
+	url         string
+	secret      string
+	listen      string
+	callbackURL string
+	maxAttempts int
+	deadLetter  string
+	http        *http.Client
+	sessions    *session.Manager
+	incoming    chan transport.Incoming
+
+	// ready is closed once the callback endpoint is listening; addr is set
+	// by then.
+	ready chan struct{}
+	addr  net.Addr
+
+	deadMu sync.Mutex
+}
+
+func New(cfg *config.Config, sessions *session.Manager) (*Transport, error) {
+	hook := cfg.Webhook
+	if hook.URL == "" {
+		return nil, errors.New("webhook.url is not set")
+	}
+	if hook.Secret == "" {
+		return nil, errors.New("webhook secret is not set (use webhook.secret or CCTG_WEBHOOK_SECRET)")
+	}
+
+	t := &Transport{
+		url:         hook.URL,
+		secret:      hook.Secret,
+		listen:      hook.Listen,
+		callbackURL: strings.TrimRight(hook.CallbackURL, "/"),
+		maxAttempts: hook.MaxAttempts,
+		deadLetter:  hook.DeadLetterPath(),
+		http:        &http.Client{Timeout: requestTimeout},
+		sessions:    sessions,
+		incoming:    make(chan transport.Incoming),
+		ready:       make(chan struct{}),
+	}
+	if t.listen == "" {
+		t.listen = DefaultListen
+	}
+	if t.maxAttempts <= 0 {
+		t.maxAttempts = DefaultMaxAttempts
+	}
+	return t, nil
+}
+
+func (t *Transport) Name() string {
+	return transport.Webhook
+}
+
+func (t *Transport) Capabilities() transport.Capabilities {
+	return transport.Capabilities{
+		Edit:    true,
+		Replies: true,
+		Choices: true,
+		Formats: []string{config.FormatMarkdown, config.FormatHTML},
+	}
+}
+
+// Incoming never delivers anything: answers name their question, so the
+// callback resolves it directly.
+func (t *Transport) Incoming() <-chan transport.Incoming {
+	return t.incoming
+}
+
+// Start serves the answer callback endpoint until ctx is done.
+func (t *Transport) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", t.listen)
+	if err != nil {
+		return fmt.Errorf("webhook callback endpoint: %w", err)
+	}
+	t.addr = ln.Addr()
+	if t.callbackURL == "" {
+		t.callbackURL = "http://" + ln.Addr().String()
+	}
+	close(t.ready)
+
+	srv := &http.Server{Handler: t.handler(), ReadHeaderTimeout: 10 * time.Second}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+	defer srv.Close()
+	log.Printf("webhook callback endpoint listening on %s", ln.Addr())
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errCh:
+		return fmt.Errorf("webhook callback endpoint: %w", err)
+	}
+}
+
+// Send delivers msg and returns the question ID the answer must name.
+// Notices are delivered without a callback URL.
+func (t *Transport) Send(ctx context.Context, chat string, msg transport.Message) (string, error) {
+	select {
+	case <-t.ready:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	id := newID()
+	d := Delivery{
+		Event:   EventQuestion,
+		ID:      id,
+		Session: msg.Session,
+		Text:    msg.Text,
+		Format:  msg.Format,
+		Choices: msg.Choices,
+		Time:    time.Now().UTC(),
+	}
+	if msg.Notice {
+		d.Event = EventNotice
+	} else {
+		d.CallbackURL = t.callbackURL + AnswerPath(id)
+	}
+	if err := t.deliver(ctx, d); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Edit tells the receiver that the text of question id changed.
+func (t *Transport) Edit(ctx context.Context, chat, id, text string) error {
+	return t.deliver(ctx, Delivery{Event: EventEdit, ID: id, Text: text, Time: time.Now().UTC()})
+}
+
+// deliver POSTs d, retrying network errors, 5xx and 429 responses with
+// exponential backoff. A delivery that fails for good is appended to the
+// dead-letter file.
+func (t *Transport) deliver(ctx context.Context, d Delivery) error {
+	body, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("encoding delivery: %w", err)
+	}
+	deliveryID := newID()
+
+	backoff := minBackoff
+	attempt := 0
+	for {
+		attempt++
+		retry, err := t.post(ctx, d.Event, deliveryID, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= t.maxAttempts || ctx.Err() != nil {
+			t.writeDeadLetter(d, deliveryID, attempt, err)
+			return fmt.Errorf("webhook delivery failed after %d attempt(s): %w", attempt, err)
+		}
+
+		log.Printf("webhook delivery %s failed, retrying in %s: %v", deliveryID, backoff, err)
+		select {
+		case <-ctx.Done():
+			t.writeDeadLetter(d, deliveryID, attempt, ctx.Err())
+			return fmt.Errorf("webhook delivery failed after %d attempt(s): %w", attempt, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying.
+func (t *Transport) post(ctx context.Context, event, deliveryID string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	req.Header.Set(TimestampHeader, ts)
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	req.Header.Set(SignatureHeader, Sign(t.secret, ts, req.Method, path, body))
+
+	resp, err := t.http.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+// deadLetter is a line in the dead-letter file.
+type deadLetter struct {
+	Time       time.Time `json:"time"`
+	DeliveryID string    `json:"delivery_id"`
+	URL        string    `json:"url"`
+	Attempts   int       `json:"attempts"`
+	Error      string    `json:"error"`
+	Delivery   Delivery  `json:"delivery"`
+}
+
+func (t *Transport) writeDeadLetter(d Delivery, deliveryID string, attempts int, cause error) {
+	line, err := json.Marshal(deadLetter{
+		Time:       time.Now().UTC(),
+		DeliveryID: deliveryID,
+		URL:        t.url,
+		Attempts:   attempts,
+		Error:      cause.Error(),
+		Delivery:   d,
+	})
+	if err != nil {
+		log.Printf("encoding dead letter: %v", err)
+		return
+	}
+
+	t.deadMu.Lock()
+	defer t.deadMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(t.deadLetter), 0700); err != nil {
+		log.Printf("writing dead letter: %v", err)
+		return
+	}
+	f, err := os.OpenFile(t.deadLetter, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("writing dead letter: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("writing dead letter: %v", err)
+		return
+	}
+	log.Printf("webhook delivery %s written to %s", deliveryID, t.deadLetter)
+}
+
+// Sign returns the signature header value for a request with body sent at
+// timestamp ts: an HMAC-SHA256 of "<ts>.<method>.<path>.<body>" keyed with
+// secret. Covering the path stops an answer signed for one question from
+// being replayed against another.
+func Sign(secret, ts, method, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + method + "." + path + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
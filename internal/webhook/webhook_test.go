@@ -0,0 +1,238 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/session"
+	"github.com/bupd/go-claude-code-telegram/internal/testutil"
+	"github.com/bupd/go-claude-code-telegram/internal/transport"
+)
+
+const testSecret = "0123456789abcdef0123"
+
+var addr = transport.Address{Transport: transport.Webhook, Chat: transport.WebhookChat}
+
+// receiver is the integration on the other end: it checks signatures and
+// records deliveries, answering with status.
+type receiver struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	status     int
+	deliveries []Delivery
+	ids        []string
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	rc := &receiver{status: status}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if verify(testSecret, r.Method, r.URL.Path, r.Header, body, time.Now()) != nil {
+			t.Errorf("delivery with bad signature: %s", body)
+		}
+		var d Delivery
+		json.Unmarshal(body, &d)
+
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		rc.deliveries = append(rc.deliveries, d)
+		rc.ids = append(rc.ids, r.Header.Get(DeliveryHeader))
+		w.WriteHeader(rc.status)
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func (rc *receiver) got() ([]Delivery, []string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.deliveries, rc.ids
+}
+
+func start(t *testing.T, rc *receiver, maxAttempts int) (*Transport, *session.Manager, string) {
+	t.Helper()
+	deadLetter := filepath.Join(t.TempDir(), "dead.jsonl")
+	cfg := &config.Config{
+		Webhook: config.WebhookConfig{
+			URL:            rc.URL,
+			Secret:         testSecret,
+			Listen:         "127.0.0.1:0",
+			MaxAttempts:    maxAttempts,
+			DeadLetterFile: deadLetter,
+		},
+		Sessions: []config.SessionConfig{{Name: "proj", Transport: transport.Webhook}},
+	}
+	sessions := session.NewManager(cfg)
+	tr, err := New(cfg, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.WaitReady(t, tr.ready, testutil.Start(t, tr.Start))
+	return tr, sessions, deadLetter
+}
+
+func answer(t *testing.T, callbackURL, body, secret string) int {
+	t.Helper()
+	u, _ := url.Parse(callbackURL)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	return answerSigned(t, callbackURL, body, ts, Sign(secret, ts, http.MethodPost, u.Path, []byte(body)))
+}
+
+func answerSigned(t *testing.T, callbackURL, body, ts, signature string) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, callbackURL, strings.NewReader(body))
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, signature)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestQuestionAndAnswer(t *testing.T) {
+	rc := newReceiver(t, http.StatusOK)
+	tr, sessions, _ := start(t, rc, 3)
+
+	id, err := tr.Send(context.Background(), transport.WebhookChat, transport.Message{Session: "proj", Text: "Deploy?", Choices: []string{"yes", "no"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := sessions.AddPending(addr, id, session.Question{Session: "proj", Content: "Deploy?"}, time.Now().Add(time.Minute))
+
+	deliveries, _ := rc.got()
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.Event != EventQuestion || d.ID != id || d.Session != "proj" || len(d.Choices) != 2 {
+		t.Fatalf("delivery = %+v", d)
+	}
+	if want := "http://" + tr.addr.String() + AnswerPath(id); d.CallbackURL != want {
+		t.Fatalf("callback URL = %q, want %q", d.CallbackURL, want)
+	}
+
+	if code := answer(t, d.CallbackURL, `{"text":"yes"}`, "wrong secret"); code != http.StatusUnauthorized {
+		t.Errorf("answer with bad signature: HTTP %d, want 401", code)
+	}
+	base := "http://" + tr.addr.String()
+	if code := answer(t, base+AnswerPath("unknown"), `{"text":"yes"}`, testSecret); code != http.StatusNotFound {
+		t.Errorf("answer to unknown question: HTTP %d, want 404", code)
+	}
+	if code := answer(t, d.CallbackURL, `{"text":"yes","user":"pager:alice"}`, testSecret); code != http.StatusAccepted {
+		t.Fatalf("answer: HTTP %d, want 202", code)
+	}
+
+	select {
+	case reply := <-pm.ResponseCh:
+		if reply != "yes" {
+			t.Fatalf("answer = %q, want yes", reply)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("question was not answered")
+	}
+}
+
+func TestAnswerSignatureCoversQuestion(t *testing.T) {
+	rc := newReceiver(t, http.StatusOK)
+	tr, sessions, _ := start(t, rc, 1)
+
+	var ids []string
+	for _, q := range []string{"Deploy?", "Migrate?"} {
+		id, err := tr.Send(context.Background(), transport.WebhookChat, transport.Message{Session: "proj", Text: q})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions.AddPending(addr, id, session.Question{Session: "proj", Content: q}, time.Now().Add(time.Minute))
+		ids = append(ids, id)
+	}
+
+	// A signature captured from an answer to the first question doesn't
+	// answer the second.
+	body := `{"text":"yes"}`
+	base := "http://" + tr.addr.String()
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := Sign(testSecret, ts, http.MethodPost, AnswerPath(ids[0]), []byte(body))
+	if code := answerSigned(t, base+AnswerPath(ids[1]), body, ts, sig); code != http.StatusUnauthorized {
+		t.Fatalf("replayed answer: HTTP %d, want 401", code)
+	}
+	if code := answerSigned(t, base+AnswerPath(ids[0]), body, ts, sig); code != http.StatusAccepted {
+		t.Fatalf("original answer: HTTP %d, want 202", code)
+	}
+	if n := sessions.Stats()[addr].Pending; n != 1 {
+		t.Fatalf("%d questions pending, want only the second", n)
+	}
+}
+
+func TestNoticeHasNoCallback(t *testing.T) {
+	rc := newReceiver(t, http.StatusNoContent)
+	tr, _, _ := start(t, rc, 1)
+
+	if _, err := tr.Send(context.Background(), transport.WebhookChat, transport.Message{Session: "proj", Text: "Build finished", Notice: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Edit(context.Background(), transport.WebhookChat, "abc", "Deploy? (answered)"); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, _ := rc.got()
+	if len(deliveries) != 2 || deliveries[0].Event != EventNotice || deliveries[0].Session != "proj" || deliveries[0].CallbackURL != "" || deliveries[1].Event != EventEdit || deliveries[1].ID != "abc" {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+}
+
+func TestRetriesThenDeadLetter(t *testing.T) {
+	rc := newReceiver(t, http.StatusServiceUnavailable)
+	tr, _, deadLetterPath := start(t, rc, 2)
+
+	_, err := tr.Send(context.Background(), transport.WebhookChat, transport.Message{Session: "proj", Text: "Deploy?"})
+	if err == nil {
+		t.Fatal("Send succeeded against a failing webhook")
+	}
+	_, ids := rc.got()
+	if len(ids) != 2 || ids[0] != ids[1] {
+		t.Fatalf("delivery IDs = %v, want 2 attempts with the same ID", ids)
+	}
+
+	data, err := os.ReadFile(deadLetterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dl deadLetter
+	if err := json.Unmarshal(bytes.TrimSpace(data), &dl); err != nil {
+		t.Fatalf("dead letter %q: %v", data, err)
+	}
+	if dl.Attempts != 2 || dl.DeliveryID != ids[0] || dl.Delivery.Text != "Deploy?" || !strings.Contains(dl.Error, "503") {
+		t.Fatalf("dead letter = %+v", dl)
+	}
+}
+
+func TestClientErrorIsNotRetried(t *testing.T) {
+	rc := newReceiver(t, http.StatusBadRequest)
+	tr, _, deadLetter := start(t, rc, 5)
+
+	if _, err := tr.Send(context.Background(), transport.WebhookChat, transport.Message{Session: "proj", Text: "Deploy?"}); err == nil {
+		t.Fatal("Send succeeded against a rejecting webhook")
+	}
+	if _, ids := rc.got(); len(ids) != 1 {
+		t.Fatalf("got %d attempts, want 1", len(ids))
+	}
+	if _, err := os.Stat(deadLetter); err != nil {
+		t.Fatalf("no dead letter written: %v", err)
+	}
+}
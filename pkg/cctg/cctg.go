@@ -0,0 +1,341 @@
+// Package cctg is a client for the cctg daemon, for programs that want to
+// ask questions in chat without running the cctg command.
+//
+// A question is asked with Ask, which returns once the message is delivered,
+// and its answer collected with Wait:
+//
+//	c, err := cctg.New()
+//	if err != nil {
+//		return err
+//	}
+//	id, err := c.Ask(ctx, cctg.Question{Session: "myproject", Text: "Deploy now?", Choices: []string{"yes", "no"}})
+//	if err != nil {
+//		return err
+//	}
+//	answer, err := c.Wait(ctx, id)
+//
+// Every method gives up when its context is done. Errors from the daemon
+// are *Error values, which match ErrExpired, ErrCancelled and ErrNotFound
+// with errors.Is; errors reaching it match ErrDaemonNotRunning.
+package cctg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/config"
+	"github.com/bupd/go-claude-code-telegram/internal/ipc"
+)
+
+var (
+	// ErrDaemonNotRunning means nothing answered at the daemon's address.
+	ErrDaemonNotRunning = errors.New("cctg: daemon is not running")
+	// ErrExpired means the question timed out without an answer.
+	ErrExpired = errors.New("cctg: question expired without an answer")
+	// ErrCancelled means the question was withdrawn with Cancel.
+	ErrCancelled = errors.New("cctg: question was cancelled")
+	// ErrNotFound means the daemon doesn't know the question ID. The
+	// outcome of a question is forgotten some minutes after it settles, and
+	// when the daemon restarts.
+	ErrNotFound = errors.New("cctg: no such question")
+)
+
+// Error codes in Error.Code.
+const (
+	CodeVersionMismatch      = ipc.CodeVersionMismatch
+	CodeUnauthorized         = ipc.CodeUnauthorized
+	CodeForbidden            = ipc.CodeForbidden
+	CodeShuttingDown         = ipc.CodeShuttingDown
+	CodeNoSession            = ipc.CodeNoSession
+	CodeTransportUnavailable = ipc.CodeTransportUnavailable
+	CodeUnsupported          = ipc.CodeUnsupported
+	CodeDeliveryFailed       = ipc.CodeDeliveryFailed
+	CodeTimeout              = ipc.CodeTimeout
+	CodeNotFound             = ipc.CodeNotFound
+	CodeCancelled            = ipc.CodeCancelled
+)
+
+// Error is a request the daemon refused or couldn't carry out.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "cctg: " + e.Message
+}
+
+// Is matches the sentinel errors by code.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrExpired:
+		return e.Code == CodeTimeout
+	case ErrCancelled:
+		return e.Code == CodeCancelled
+	case ErrNotFound:
+		return e.Code == CodeNotFound
+	}
+	return false
+}
+
+// Client talks to one daemon. It is safe for concurrent use; each call
+// opens its own connection.
+type Client struct {
+	ipc *ipc.Client
+}
+
+type options struct {
+	address string
+	remote  ipc.RemoteOptions
+}
+
+// Option configures New.
+type Option func(*options)
+
+// WithSocket connects to the daemon's Unix socket at path.
+func WithSocket(path string) Option {
+	return func(o *options) { o.address = "unix://" + path }
+}
+
+// WithAddress connects to a daemon at unix:///path, tcp://host:port or
+// https://host:port.
+func WithAddress(addr string) Option {
+	return func(o *options) { o.address = addr }
+}
+
+// WithToken authenticates to a remote daemon with one of its
+// remote.tokens.
+func WithToken(token string) Option {
+	return func(o *options) { o.remote.Token = token }
+}
+
+// WithTLS sets the client certificate for an https:// daemon using mutual
+// TLS, and the CA that signed the daemon's certificate. Any of them may be
+// empty.
+func WithTLS(certFile, keyFile, caFile string) Option {
+	return func(o *options) {
+		o.remote.CertFile = certFile
+		o.remote.KeyFile = keyFile
+		o.remote.CAFile = caFile
+	}
+}
+
+// New returns a client for the daemon. Without options it uses the same
+// daemon as the cctg command: CCTG_DAEMON and its credential variables if
+// set, otherwise the local socket.
+func New(opts ...Option) (*Client, error) {
+	o := options{
+		address: os.Getenv("CCTG_DAEMON"),
+		remote: ipc.RemoteOptions{
+			Token:    os.Getenv("CCTG_DAEMON_TOKEN"),
+			CertFile: os.Getenv("CCTG_DAEMON_CERT"),
+			KeyFile:  os.Getenv("CCTG_DAEMON_KEY"),
+			CAFile:   os.Getenv("CCTG_DAEMON_CA"),
+		},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.address == "" {
+		o.address = "unix://" + config.GetSocketPath()
+	}
+	c, err := ipc.NewRemoteClient(o.address, o.remote)
+	if err != nil {
+		return nil, fmt.Errorf("cctg: %w", err)
+	}
+	return &Client{ipc: c}, nil
+}
+
+// Question is a message that expects an answer.
+type Question struct {
+	// Session names the configured session to ask in. If empty, the session
+	// is found from WorkDir.
+	Session string
+	WorkDir string
+	Text    string
+	// Choices are offered as buttons where the transport supports them.
+	Choices []string
+	// Attachments are file paths on the daemon's machine.
+	Attachments []string
+	// Timeout overrides the session's timeout, rounded up to whole seconds.
+	Timeout time.Duration
+}
+
+// Answer is the outcome of a question.
+type Answer struct {
+	ID string
+	// Text is the reply, including any messages sent in the chat before the
+	// question was asked. For an expired question it is the session's
+	// fallback message, if it has one.
+	Text string
+}
+
+// Ask sends q and returns its ID once delivered, without waiting for the
+// answer.
+func (c *Client) Ask(ctx context.Context, q Question) (string, error) {
+	caps := []string{ipc.CapAsync}
+	if len(q.Choices) > 0 {
+		caps = append(caps, ipc.CapChoices)
+	}
+	if len(q.Attachments) > 0 {
+		caps = append(caps, ipc.CapAttachments)
+	}
+	if err := c.require(ctx, caps...); err != nil {
+		return "", err
+	}
+
+	resp, err := c.send(ctx, &ipc.Request{
+		Type:        ipc.RequestTypeAsk,
+		Session:     q.Session,
+		WorkDir:     q.WorkDir,
+		Message:     q.Text,
+		Choices:     q.Choices,
+		Attachments: q.Attachments,
+		Timeout:     seconds(q.Timeout),
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.State == ipc.StateExpired {
+		return "", &Error{Code: CodeTimeout, Message: "question not delivered before timeout"}
+	}
+	return resp.ID, nil
+}
+
+// Wait returns the answer to question id, blocking until it is answered,
+// expires or is cancelled, or ctx is done. An expired question returns
+// ErrExpired along with any fallback answer.
+func (c *Client) Wait(ctx context.Context, id string) (*Answer, error) {
+	for {
+		req := &ipc.Request{Type: ipc.RequestTypeWait, ID: id}
+		if deadline, ok := ctx.Deadline(); ok {
+			req.Timeout = max(seconds(time.Until(deadline)), 1)
+		}
+		resp, err := c.send(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		switch resp.State {
+		case ipc.StatePending:
+			// The daemon gave up at our deadline.
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		case ipc.StateExpired:
+			return &Answer{ID: id, Text: resp.Reply}, &Error{Code: CodeTimeout, Message: "no reply before timeout"}
+		default:
+			return &Answer{ID: id, Text: resp.Reply}, nil
+		}
+	}
+}
+
+// Cancel withdraws question id. Its Wait returns ErrCancelled.
+func (c *Client) Cancel(ctx context.Context, id string) error {
+	if err := c.require(ctx, ipc.CapAsync); err != nil {
+		return err
+	}
+	_, err := c.send(ctx, &ipc.Request{Type: ipc.RequestTypeCancel, ID: id})
+	return err
+}
+
+// AskAndWait sends q and waits for its answer, like cctg send.
+func (c *Client) AskAndWait(ctx context.Context, q Question) (*Answer, error) {
+	id, err := c.Ask(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return c.Wait(ctx, id)
+}
+
+// Notify sends text to a session without expecting an answer.
+func (c *Client) Notify(ctx context.Context, session, text string) error {
+	if err := c.require(ctx, ipc.CapNotify); err != nil {
+		return err
+	}
+	_, err := c.send(ctx, &ipc.Request{Type: ipc.RequestTypeNotify, Session: session, Message: text})
+	return err
+}
+
+// Status reports the daemon's health and sessions.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	resp, err := c.send(ctx, &ipc.Request{Type: ipc.RequestTypeStatus})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status == nil {
+		return nil, &Error{Code: CodeUnsupported, Message: "the daemon didn't report its status"}
+	}
+	return newStatus(resp.Status), nil
+}
+
+// Subscribe streams events for session, or for all sessions if it is
+// empty. The channel is closed when ctx is done or the daemon stops; events
+// are dropped if the receiver falls far behind.
+func (c *Client) Subscribe(ctx context.Context, session string) (<-chan Event, error) {
+	if err := c.require(ctx, ipc.CapEvents); err != nil {
+		return nil, err
+	}
+	events, err := c.ipc.Subscribe(ctx, &ipc.Request{Session: session})
+	if err != nil {
+		return nil, wrap(err)
+	}
+
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		for ev := range events {
+			select {
+			case out <- Event(ev):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// send sends req and turns failures into errors.
+func (c *Client) send(ctx context.Context, req *ipc.Request) (*ipc.Response, error) {
+	resp, err := c.ipc.SendContext(ctx, req)
+	if err != nil {
+		return nil, wrap(err)
+	}
+	if !resp.Success {
+		return nil, &Error{Code: resp.Code, Message: resp.Error}
+	}
+	return resp, nil
+}
+
+func (c *Client) require(ctx context.Context, caps ...string) error {
+	err := c.ipc.RequireContext(ctx, caps...)
+	var mismatch *ipc.MismatchError
+	if errors.As(err, &mismatch) {
+		return &Error{Code: CodeVersionMismatch, Message: mismatch.Error()}
+	}
+	return wrap(err)
+}
+
+func wrap(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	var resp *ipc.ResponseError
+	if errors.As(err, &resp) {
+		return &Error{Code: resp.Code, Message: resp.Message}
+	}
+	var op *net.OpError
+	if errors.As(err, &op) && op.Op == "dial" {
+		return fmt.Errorf("%w: %v", ErrDaemonNotRunning, err)
+	}
+	return fmt.Errorf("cctg: %w", err)
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
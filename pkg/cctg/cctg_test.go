@@ -0,0 +1,287 @@
+package cctg
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/ipc"
+)
+
+// fakeDaemon answers requests the way the daemon does, for one session
+// named "dev" with a question in each settled state.
+type fakeDaemon struct {
+	caps []string
+
+	mu   sync.Mutex
+	reqs []ipc.Request
+}
+
+func (d *fakeDaemon) handle(req *ipc.Request) *ipc.Response {
+	d.mu.Lock()
+	d.reqs = append(d.reqs, *req)
+	d.mu.Unlock()
+
+	switch req.Type {
+	case ipc.RequestTypeHello:
+		return &ipc.Response{Success: true, Hello: &ipc.Hello{Version: ipc.ProtocolVersion, MinVersion: ipc.MinProtocolVersion, Capabilities: d.caps}}
+	case ipc.RequestTypeAsk:
+		if req.Session != "dev" {
+			return ipc.Errorf(ipc.CodeNoSession, "no session named %q", req.Session)
+		}
+		return &ipc.Response{Success: true, ID: "1", State: ipc.StatePending}
+	case ipc.RequestTypeWait:
+		switch req.ID {
+		case "answered":
+			return &ipc.Response{Success: true, ID: req.ID, State: ipc.StateAnswered, Reply: "yes"}
+		case "expired":
+			return &ipc.Response{Success: true, ID: req.ID, State: ipc.StateExpired, Reply: "fallback"}
+		case "cancelled":
+			return ipc.Errorf(ipc.CodeCancelled, "question %s was cancelled", req.ID)
+		}
+		return ipc.Errorf(ipc.CodeNotFound, "no question with id %q", req.ID)
+	case ipc.RequestTypeCancel:
+		if req.ID != "1" {
+			return ipc.Errorf(ipc.CodeNotFound, "no question with id %q", req.ID)
+		}
+		return &ipc.Response{Success: true, ID: req.ID, State: ipc.StateCancelled}
+	case ipc.RequestTypeNotify:
+		return &ipc.Response{Success: true}
+	case ipc.RequestTypeSubscribe:
+		events := make(chan ipc.Event, 1)
+		events <- ipc.Event{Type: EventAsked, Session: req.Session, QuestionID: "1", Question: "Deploy?"}
+		close(events)
+		return &ipc.Response{Success: true, Events: events, Unsubscribe: func() {}}
+	}
+	return ipc.Errorf(ipc.CodeUnknownRequest, "unknown request type: %s", req.Type)
+}
+
+// last returns the last request of type typ the daemon received.
+func (d *fakeDaemon) last(typ string) ipc.Request {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := len(d.reqs) - 1; i >= 0; i-- {
+		if d.reqs[i].Type == typ {
+			return d.reqs[i]
+		}
+	}
+	return ipc.Request{}
+}
+
+// startDaemon serves d on path until stop is called or the test ends.
+func startDaemon(t *testing.T, path string, d *fakeDaemon) (stop func()) {
+	t.Helper()
+	s := ipc.NewServer(path, d.handle)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			cancel()
+			s.Stop()
+		})
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+var allCaps = []string{ipc.CapAsync, ipc.CapChoices, ipc.CapNotify, ipc.CapEvents}
+
+func newTestClient(t *testing.T, caps []string) (*Client, *fakeDaemon) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cctg.sock")
+	d := &fakeDaemon{caps: caps}
+	startDaemon(t, path, d)
+	c, err := New(WithSocket(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, d
+}
+
+func TestDaemonNotRunning(t *testing.T) {
+	c, err := New(WithSocket(filepath.Join(t.TempDir(), "cctg.sock")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Status(context.Background()); !errors.Is(err, ErrDaemonNotRunning) {
+		t.Fatalf("Status = %v, want ErrDaemonNotRunning", err)
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	for _, tc := range []struct {
+		code string
+		want error
+	}{
+		{CodeTimeout, ErrExpired},
+		{CodeCancelled, ErrCancelled},
+		{CodeNotFound, ErrNotFound},
+	} {
+		err := error(&Error{Code: tc.code, Message: "x"})
+		if !errors.Is(err, tc.want) {
+			t.Errorf("errors.Is(%s, %v) = false", tc.code, tc.want)
+		}
+	}
+	if errors.Is(&Error{Code: CodeNoSession}, ErrNotFound) {
+		t.Error("no_session matched ErrNotFound")
+	}
+}
+
+func TestAsk(t *testing.T) {
+	c, d := newTestClient(t, allCaps)
+	ctx := context.Background()
+
+	id, err := c.Ask(ctx, Question{Session: "dev", Text: "Deploy?", Choices: []string{"yes", "no"}, Timeout: 1500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "1" {
+		t.Errorf("Ask = %q, want 1", id)
+	}
+	req := d.last(ipc.RequestTypeAsk)
+	if req.Message != "Deploy?" || !slices.Equal(req.Choices, []string{"yes", "no"}) || req.Timeout != 2 {
+		t.Errorf("daemon got %+v", req)
+	}
+
+	_, err = c.Ask(ctx, Question{Session: "prod", Text: "Deploy?"})
+	var e *Error
+	if !errors.As(err, &e) || e.Code != CodeNoSession {
+		t.Fatalf("Ask in unknown session = %v, want *Error with code %s", err, CodeNoSession)
+	}
+}
+
+func TestWait(t *testing.T) {
+	c, _ := newTestClient(t, allCaps)
+
+	tests := []struct {
+		id      string
+		text    string
+		wantErr error
+	}{
+		{id: "answered", text: "yes"},
+		{id: "expired", text: "fallback", wantErr: ErrExpired},
+		{id: "cancelled", wantErr: ErrCancelled},
+		{id: "unknown", wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			answer, err := c.Wait(ctx, tt.id)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Wait = %v, want %v", err, tt.wantErr)
+			}
+			var text string
+			if answer != nil {
+				text = answer.Text
+			}
+			if text != tt.text {
+				t.Errorf("answer = %q, want %q", text, tt.text)
+			}
+		})
+	}
+}
+
+func TestCancelAndNotify(t *testing.T) {
+	c, d := newTestClient(t, allCaps)
+	ctx := context.Background()
+
+	if err := c.Cancel(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Cancel(ctx, "2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Cancel of unknown question = %v, want ErrNotFound", err)
+	}
+
+	if err := c.Notify(ctx, "dev", "Build finished"); err != nil {
+		t.Fatal(err)
+	}
+	if req := d.last(ipc.RequestTypeNotify); req.Session != "dev" || req.Message != "Build finished" {
+		t.Errorf("daemon got %+v", req)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	c, _ := newTestClient(t, allCaps)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events, err := c.Subscribe(ctx, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Event
+	for ev := range events {
+		got = append(got, ev)
+	}
+	if len(got) != 1 || got[0].Type != EventAsked || got[0].Session != "dev" || got[0].Question != "Deploy?" {
+		t.Fatalf("events = %+v", got)
+	}
+}
+
+func TestMissingCapability(t *testing.T) {
+	c, d := newTestClient(t, []string{ipc.CapAsync})
+	ctx := context.Background()
+
+	for name, call := range map[string]func() error{
+		"Ask with choices": func() error {
+			_, err := c.Ask(ctx, Question{Session: "dev", Text: "Deploy?", Choices: []string{"yes"}})
+			return err
+		},
+		"Notify": func() error { return c.Notify(ctx, "dev", "hi") },
+		"Subscribe": func() error {
+			_, err := c.Subscribe(ctx, "")
+			return err
+		},
+	} {
+		var e *Error
+		if err := call(); !errors.As(err, &e) || e.Code != CodeVersionMismatch {
+			t.Errorf("%s = %v, want *Error with code %s", name, err, CodeVersionMismatch)
+		}
+	}
+	if req := d.last(ipc.RequestTypeAsk); req.Type != "" {
+		t.Errorf("daemon got %+v despite the missing capability", req)
+	}
+}
+
+func TestHelloRefreshedAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cctg.sock")
+	ctx := context.Background()
+	c, err := New(WithSocket(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *Error
+
+	// An old daemon that can't notify is replaced by one that can.
+	stop := startDaemon(t, path, &fakeDaemon{caps: []string{ipc.CapAsync}})
+	if err := c.Notify(ctx, "dev", "hi"); !errors.As(err, &e) || e.Code != CodeVersionMismatch {
+		t.Fatalf("Notify on old daemon = %v, want *Error with code %s", err, CodeVersionMismatch)
+	}
+	stop()
+	stop = startDaemon(t, path, &fakeDaemon{caps: allCaps})
+	if err := c.Notify(ctx, "dev", "hi"); err != nil {
+		t.Fatalf("Notify on new daemon = %v", err)
+	}
+
+	// The connection is lost while it is replaced by one that can't.
+	stop()
+	if _, err := c.Status(ctx); !errors.Is(err, ErrDaemonNotRunning) {
+		t.Fatalf("Status while stopped = %v, want ErrDaemonNotRunning", err)
+	}
+	d := &fakeDaemon{caps: []string{ipc.CapAsync}}
+	startDaemon(t, path, d)
+	if err := c.Notify(ctx, "dev", "hi"); !errors.As(err, &e) || e.Code != CodeVersionMismatch {
+		t.Fatalf("Notify on downgraded daemon = %v, want *Error with code %s", err, CodeVersionMismatch)
+	}
+	if req := d.last(ipc.RequestTypeNotify); req.Type != "" {
+		t.Errorf("downgraded daemon got %+v; the stale hello was used", req)
+	}
+}
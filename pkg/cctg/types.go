@@ -0,0 +1,95 @@
+package cctg
+
+import (
+	"time"
+
+	"github.com/bupd/go-claude-code-telegram/internal/ipc"
+)
+
+// Event types in Event.Type.
+const (
+	EventAsked     = "asked"
+	EventAnswered  = "answered"
+	EventExpired   = "expired"
+	EventCancelled = "cancelled"
+	// EventQueued is a message sent in a chat with no question pending. It
+	// is handed to the next question asked there.
+	EventQueued = "queued"
+	// EventCommand is a bot command, such as /status on Telegram. It is
+	// also routed like any other message.
+	EventCommand = "command"
+	// EventConnection is a change in a transport's connection state.
+	EventConnection = "connection"
+)
+
+// Event is something that happened to a question or chat.
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Session   string    `json:"session,omitempty"`
+	Transport string    `json:"transport"`
+	Chat      string    `json:"chat"`
+	// QuestionID is the ID returned by Ask.
+	QuestionID string   `json:"question_id,omitempty"`
+	Question   string   `json:"question,omitempty"`
+	Choices    []string `json:"choices,omitempty"`
+	// Text is the answer, message or command. For connection events it
+	// is the last error.
+	Text string `json:"text,omitempty"`
+	// Source is where an answer came from, a transport name or
+	// "dashboard", or the user who sent a command.
+	Source string `json:"source,omitempty"`
+	// State is the new state of a connection event.
+	State string `json:"state,omitempty"`
+}
+
+// Status is the daemon's health and sessions.
+type Status struct {
+	Version       string          `json:"version"`
+	PID           int             `json:"pid"`
+	StartedAt     time.Time       `json:"started_at"`
+	UptimeSeconds int64           `json:"uptime_seconds"`
+	ConfigPath    string          `json:"config_path"`
+	BotUsername   string          `json:"bot_username,omitempty"`
+	Telegram      TelegramStatus  `json:"telegram"`
+	Sessions      []SessionStatus `json:"sessions"`
+}
+
+// TelegramStatus is the state of the Telegram connection.
+type TelegramStatus struct {
+	State         string    `json:"state"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorAt   time.Time `json:"last_error_at"`
+	ConflictSince time.Time `json:"conflict_since"`
+	LastUpdateAt  time.Time `json:"last_update_at"`
+}
+
+// SessionStatus counts the questions and messages waiting in a session's
+// chat.
+type SessionStatus struct {
+	Name      string `json:"name"`
+	Transport string `json:"transport"`
+	// Chat is the chat in the transport's own terms; ChatID is set as well
+	// for Telegram.
+	Chat                    string `json:"chat"`
+	ChatID                  int64  `json:"chat_id,omitempty"`
+	Pending                 int    `json:"pending"`
+	Queued                  int    `json:"queued"`
+	OldestPendingAgeSeconds int64  `json:"oldest_pending_age_seconds,omitempty"`
+}
+
+func newStatus(st *ipc.Status) *Status {
+	s := &Status{
+		Version:       st.Version,
+		PID:           st.PID,
+		StartedAt:     st.StartedAt,
+		UptimeSeconds: st.UptimeSeconds,
+		ConfigPath:    st.ConfigPath,
+		BotUsername:   st.BotUsername,
+		Telegram:      TelegramStatus(st.Telegram),
+	}
+	for _, ss := range st.Sessions {
+		s.Sessions = append(s.Sessions, SessionStatus(ss))
+	}
+	return s
+}